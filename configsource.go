@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	altsrc "github.com/urfave/cli-altsrc/v3"
+	"github.com/urfave/cli-altsrc/v3/json"
+	"github.com/urfave/cli-altsrc/v3/toml"
+	"github.com/urfave/cli-altsrc/v3/yaml"
+	"github.com/urfave/cli/v3"
+)
+
+// configFileSource reads a flag value from the configuration file, parsing it according
+// to the configured format or, when unset, to the file extension (YAML by default)
+type configFileSource struct {
+	key    string
+	path   *string
+	format *string
+}
+
+func newConfigFileSource(key string, path *string, format *string) cli.ValueSource {
+	return &configFileSource{
+		key:    key,
+		path:   path,
+		format: format,
+	}
+}
+
+func configFileFormat(path string, format string) string {
+	if format != "" {
+		return format
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return "toml"
+	case ".json":
+		return "json"
+	default:
+		return "yaml"
+	}
+}
+
+func (s *configFileSource) source() *altsrc.ValueSource {
+	sourcer := altsrc.NewStringPtrSourcer(s.path)
+
+	switch configFileFormat(*s.path, *s.format) {
+	case "toml":
+		return toml.TOML(s.key, sourcer)
+	case "json":
+		return json.JSON(s.key, sourcer)
+	default:
+		return yaml.YAML(s.key, sourcer)
+	}
+}
+
+func (s *configFileSource) Lookup() (string, bool) {
+	return s.source().Lookup()
+}
+
+func (s *configFileSource) String() string {
+	return s.source().String()
+}
+
+func (s *configFileSource) GoString() string {
+	return s.source().GoString()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/urfave/cli/v3"
+)
+
+// envVarSource reads a flag value from the environment variable namespaced with the configured prefix,
+// falling back to the deprecated unprefixed variable. Each variable can also be set with its _FILE variant.
+type envVarSource struct {
+	name   string
+	prefix *string
+}
+
+func newEnvVarSource(name string, prefix *string) cli.ValueSource {
+	return &envVarSource{
+		name:   name,
+		prefix: prefix,
+	}
+}
+
+func (s *envVarSource) Lookup() (string, bool) {
+	if value, found := lookupEnvOrFile(s.Key()); found {
+		return value, true
+	}
+	if *s.prefix == "" {
+		return "", false
+	}
+
+	value, found := lookupEnvOrFile(s.name)
+	if found {
+		slog.Warn("Unprefixed environment variable is deprecated", "variable", s.name, "replacement", s.Key())
+	}
+	return value, found
+}
+
+func (s *envVarSource) Key() string {
+	return *s.prefix + s.name
+}
+
+func (s *envVarSource) IsFromEnv() bool {
+	return true
+}
+
+func (s *envVarSource) String() string {
+	return fmt.Sprintf("environment variable %q", s.Key())
+}
+
+func (s *envVarSource) GoString() string {
+	return fmt.Sprintf("&envVarSource{name:%q,prefix:%q}", s.name, *s.prefix)
+}
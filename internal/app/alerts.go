@@ -0,0 +1,21 @@
+package app
+
+import "fmt"
+
+// getRunAlerts returns a message for each alert threshold exceeded by the run
+func getRunAlerts(c *Config) []string {
+	var alerts []string
+
+	if c.AlertOnDeletions > 0 && len(c.deletedScrobbles) > c.AlertOnDeletions {
+		alerts = append(alerts, fmt.Sprintf("Duplicated scrobbles count %d exceeds alert threshold %d", len(c.deletedScrobbles), c.AlertOnDeletions))
+	}
+
+	if c.AlertOnFailureRate > 0 && c.canDelete && len(c.deletedScrobbles) > 0 {
+		failureRate := float64(c.runStats.scrobbleDeleteFails.Load()) / float64(len(c.deletedScrobbles))
+		if failureRate > c.AlertOnFailureRate {
+			alerts = append(alerts, fmt.Sprintf("Delete failure rate %.2f exceeds alert threshold %.2f", failureRate, c.AlertOnFailureRate))
+		}
+	}
+
+	return alerts
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// exportedTrack is a scrobble as returned by the user.getRecentTracks method of the Last.fm API,
+// which is the format of the JSON exports of Last.fm history tools
+type exportedTrack struct {
+	Artist struct {
+		Text string `json:"#text"`
+		// Set instead of #text in extended responses
+		Name string `json:"name"`
+	} `json:"artist"`
+	Name  string `json:"name"`
+	Album struct {
+		Text string `json:"#text"`
+	} `json:"album"`
+	// Missing for the track being played
+	Date *struct {
+		UTS string `json:"uts"`
+	} `json:"date"`
+	URL string `json:"url"`
+}
+
+// exportPage is a page of the user.getRecentTracks method, the export may hold the API response or the pages it contains
+type exportPage struct {
+	RecentTracks *exportPage     `json:"recenttracks"`
+	Track        []exportedTrack `json:"track"`
+}
+
+// Analyze runs the detection over a JSON export of the scrobbles of a user instead of the Last.fm library pages,
+// without browser and without deletion
+func Analyze(ctx context.Context, c *Config, r io.Reader) error {
+	scrobbles, err := parseScrobblesExport(r)
+	if err != nil {
+		return err
+	}
+	slog.Info("Loaded scrobbles export", "count", len(scrobbles))
+
+	c.analyzeOnly = true
+	c.analyzeScrobbles = scrobbles
+	return Run(ctx, c)
+}
+
+// parseScrobblesExport reads an export holding a list of tracks, a list of pages or an API response,
+// and returns its scrobbles from the oldest to the newest as they are processed
+func parseScrobblesExport(r io.Reader) ([]scrobble, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scrobbles export: %w", err)
+	}
+
+	tracks, err := exportedTracks(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scrobbles export: %w", err)
+	}
+
+	scrobbles := make([]scrobble, 0, len(tracks))
+	for _, t := range tracks {
+		if t.Date == nil {
+			continue
+		}
+		uts, err := strconv.ParseInt(t.Date.UTS, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse timestamp of %s - %s: %w", t.Artist.Text, t.Name, err)
+		}
+		artist := t.Artist.Text
+		if artist == "" {
+			artist = t.Artist.Name
+		}
+		scrobbles = append(scrobbles, scrobble{
+			artist:          artist,
+			track:           t.Name,
+			album:           t.Album.Text,
+			timestamp:       time.Unix(uts, 0),
+			timestampString: t.Date.UTS,
+			url:             t.URL,
+		})
+	}
+
+	slices.SortStableFunc(scrobbles, func(a, b scrobble) int {
+		return a.timestamp.Compare(b.timestamp)
+	})
+	return scrobbles, nil
+}
+
+func exportedTracks(b []byte) ([]exportedTrack, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		var page exportPage
+		if err := json.Unmarshal(b, &page); err != nil {
+			return nil, err
+		}
+		if page.RecentTracks != nil {
+			return page.RecentTracks.Track, nil
+		}
+		return page.Track, nil
+	}
+
+	var items []struct {
+		exportedTrack
+		Track []exportedTrack `json:"track"`
+	}
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+
+	var tracks []exportedTrack
+	for _, item := range items {
+		if item.Track != nil {
+			tracks = append(tracks, item.Track...)
+			continue
+		}
+		tracks = append(tracks, item.exportedTrack)
+	}
+	return tracks, nil
+}
+
+// analyzedScrobblesFetcher returns all the scrobbles of the export as a single page
+func analyzedScrobblesFetcher(c *Config) pageFetcher {
+	return func(int) ([]scrobble, error) {
+		return c.analyzeScrobbles, nil
+	}
+}
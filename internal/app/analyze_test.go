@@ -0,0 +1,40 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseScrobblesExport(t *testing.T) {
+	const track = `{"artist": {"#text": "Artist"}, "name": "Song", "album": {"#text": "Album"}, "date": {"uts": "1704164645", "#text": "02 Jan 2024, 03:04"}, "url": "https://www.last.fm/music/Artist/_/Song"}`
+	const nowPlaying = `{"artist": {"#text": "Artist"}, "name": "Playing", "@attr": {"nowplaying": "true"}}`
+	tests := []struct {
+		name        string
+		export      string
+		expected    int
+		expectedErr bool
+	}{
+		{name: "list of tracks", export: "[" + track + "," + nowPlaying + "]", expected: 1},
+		{name: "api response", export: `{"recenttracks": {"track": [` + track + `]}}`, expected: 1},
+		{name: "list of pages", export: `[{"track": [` + track + `]}, {"track": [` + track + `]}]`, expected: 2},
+		{name: "invalid timestamp", export: `[{"name": "Song", "date": {"uts": "soon"}}]`, expectedErr: true},
+		{name: "invalid json", export: "[", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseScrobblesExport(strings.NewReader(tt.export))
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("parseScrobblesExport() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if len(got) != tt.expected {
+				t.Fatalf("parseScrobblesExport() returned %d scrobbles, expected %d", len(got), tt.expected)
+			}
+			for _, s := range got {
+				// The timestamp string identifies the scrobble like the timestamp input of the library pages
+				if s.artist != "Artist" || s.track != "Song" || s.timestamp.Unix() != 1704164645 || s.timestampString != "1704164645" {
+					t.Errorf("scrobble = %s - %s at %d (%q), expected Artist - Song at 1704164645", s.artist, s.track, s.timestamp.Unix(), s.timestampString)
+				}
+			}
+		})
+	}
+}
@@ -3,14 +3,16 @@ package app
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/csv"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
 	"maps"
+	"math/rand/v2"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -23,27 +25,58 @@ import (
 	"github.com/chromedp/chromedp"
 	"github.com/cterence/scrobble-deduplicator/internal/cache"
 	"github.com/cterence/scrobble-deduplicator/internal/helpers"
-	"github.com/go-telegram/bot"
-	"github.com/go-telegram/bot/models"
 	"github.com/goccy/go-yaml"
+	"golang.org/x/text/unicode/norm"
 )
 
 type scrobble struct {
 	artist          string
 	track           string
+	album           string
 	timestamp       time.Time
 	timestampString string
 	trackDuration   time.Duration
 	url             string
+	// Library page the scrobble was found on
+	page int
+	// Timestamp of the scrobble kept from the pair, set when the scrobble is detected
+	survivingTimestamp time.Time
+	// XPath of the scrobble's timestamp input used to delete it, set when the scrobble is detected
+	deleteXPath string
+	// Set when the play of the scrobble reached the complete threshold
+	complete bool
+	// Timestamp of the first scrobble of the burst of duplicates the scrobble belongs to, set when it is a duplicate
+	burstStart time.Time
+	// Completion percentage of the play between the scrobble and the surviving one, set when the scrobble is detected
+	completion float64
+}
+
+// playStart returns the timestamp of the genuine play a scrobble belongs to, the start of its burst of duplicates
+func (s *scrobble) playStart() time.Time {
+	if s.burstStart.IsZero() {
+		return s.timestamp
+	}
+	return s.burstStart
 }
 
 type durationByTrackByArtist map[string]map[string]string
 
 const (
 	customTrackDurationsFile = "track-durations.yaml"
-	browserOperationsTimeout = 30 * time.Second
-	InputDayFormat           = "02-01-2006"
-	LastFMQueryDayFormat     = "2006-01-02"
+	// Unknown track durations are flushed to their file past this count to bound memory usage
+	maxUnknownTrackDurationsInMemory = 1000
+	browserOperationsTimeout         = 30 * time.Second
+	InputDayFormat                   = "02-01-2006"
+	InputDayMinuteFormat             = "02-01-2006 15:04"
+	InputDaySecondFormat             = "02-01-2006 15:04:05"
+	LastFMQueryDayFormat             = "2006-01-02"
+
+	// Clock skew allowed between the scrobbling device and this machine
+	futureScrobbleTolerance = 5 * time.Minute
+	// Last.fm records a scrobble after half of the track or 4 minutes of play, whichever comes first
+	maxFullPlayDuration = 4 * time.Minute
+
+	trackDurationCacheKeyPrefix = "mbquery:"
 )
 
 func clickConsentBanner(ctx context.Context) error {
@@ -92,6 +125,11 @@ func clickConsentBanner(ctx context.Context) error {
 	return nil
 }
 
+var ErrLibraryPrivate = errors.New("library is private, check that the login succeeded and that lastfm-username is the logged in account")
+
+// Message shown instead of the library when the user's listening history is hidden
+const privateLibraryXPath = `//p[contains(@class, 'no-data-message')][contains(., 'private')]`
+
 func getStartPage(c *Config) (int, error) {
 	timeoutCtx, cancel := context.WithTimeout(c.taskCtx, browserOperationsTimeout)
 	defer cancel()
@@ -101,6 +139,7 @@ func getStartPage(c *Config) (int, error) {
 		scrobbleCount int
 	)
 	noScrobbles := false
+	libraryPrivate := false
 	err := chromedp.Run(timeoutCtx,
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			err := chromedp.Navigate("https://www.last.fm/user/" + c.LastFMUsername + "/library").Do(ctx)
@@ -141,11 +180,21 @@ func getStartPage(c *Config) (int, error) {
 				return fmt.Errorf("failed to navigate to user library with from / to dates: %w", err)
 			}
 
-			err = chromedp.WaitVisible(`//h1[@class='content-top-header']`, chromedp.BySearch).Do(ctx)
+			err = chromedp.WaitVisible(`//h1[@class='content-top-header'] | `+privateLibraryXPath, chromedp.BySearch).Do(ctx)
 			if err != nil {
 				return fmt.Errorf("failed to wait for h1 with content-top-header class: %w", err)
 			}
 
+			var privateNodes []*cdp.Node
+			err = chromedp.Nodes(privateLibraryXPath, &privateNodes, chromedp.AtLeast(0)).Do(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to get private library message: %w", err)
+			}
+			if len(privateNodes) > 0 {
+				libraryPrivate = true
+				return nil
+			}
+
 			var noDataNodes []*cdp.Node
 			err = chromedp.Nodes(`//p[@class='no-data-message']`, &noDataNodes, chromedp.AtLeast(0)).Do(ctx)
 			if err != nil {
@@ -188,6 +237,9 @@ func getStartPage(c *Config) (int, error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to retrieve total pages: %w", err)
 	}
+	if libraryPrivate {
+		return 0, ErrLibraryPrivate
+	}
 	if noScrobbles {
 		return 0, ErrNoScrobbles
 	}
@@ -227,25 +279,136 @@ func getUserTrackDurations(dataDir string) (durationByTrackByArtist, error) {
 
 	var userTrackDurations durationByTrackByArtist
 	if len(customTrackDurationsBytes) > 0 {
-		err = yaml.Unmarshal(customTrackDurationsBytes, &userTrackDurations)
+		userTrackDurations, err = parseUserTrackDurations(customTrackDurationsBytes)
 		if err != nil {
+			return nil, err
+		}
+	}
+
+	for artist, tracks := range userTrackDurations {
+		for track := range tracks {
+			if strings.HasPrefix(track, artist+" - ") {
+				slog.Warn(fmt.Sprintf("Track in %s likely repeats its artist, it must be the track name only to match scrobbles", customTrackDurationsFile), "artist", artist, "track", track)
+			}
+		}
+	}
+	return userTrackDurations, nil
+}
+
+// parseUserTrackDurations reads the durations of each artist's tracks. A duration set on a single "Artist - Track" key
+// instead of an artist and its tracks is skipped with a warning showing the expected format.
+func parseUserTrackDurations(customTrackDurationsBytes []byte) (durationByTrackByArtist, error) {
+	var entries map[string]yaml.RawMessage
+	if err := yaml.Unmarshal(customTrackDurationsBytes, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	userTrackDurations := make(durationByTrackByArtist, len(entries))
+	for key, raw := range entries {
+		var tracks map[string]string
+		if err := yaml.Unmarshal(raw, &tracks); err != nil {
+			var value any
+			if artist, track, found := strings.Cut(key, " - "); found && yaml.Unmarshal(raw, &value) == nil {
+				slog.Warn(fmt.Sprintf("Skipping duration in %s set on an artist and a track in a single key, set it under the artist instead", customTrackDurationsFile), "key", key, "expected", fmt.Sprintf("%q:\n  %q: %q", artist, track, fmt.Sprint(value)))
+				continue
+			}
 			return nil, fmt.Errorf("failed to parse config file: %w", err)
 		}
+		userTrackDurations[key] = tracks
 	}
 	return userTrackDurations, nil
 }
 
 var ErrNoScrobbles = errors.New("no scrobbles found for the selected period")
 
-func getScrobbles(c *Config, currentPage int) ([]scrobble, error) {
-	timeoutCtx, timeoutCancel := context.WithTimeout(c.taskCtx, browserOperationsTimeout)
+// getScrobbles returns the scrobbles of a library page, loaded in the browser tab of tabCtx
+func getScrobbles(tabCtx context.Context, c *Config, currentPage int) ([]scrobble, error) {
+	var (
+		scrobbleRows []string
+		err          error
+	)
+	if c.ReplayPages {
+		scrobbleRows, err = loadPageRows(c, currentPage)
+	} else {
+		scrobbleRows, err = getScrobbleRows(tabCtx, c, currentPage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cachingPages(c) {
+		if err := savePageRows(c, currentPage, scrobbleRows); err != nil {
+			slog.Warn("Failed to cache page rows", "page", currentPage, "error", err)
+		}
+	}
+
+	scrobbles := []scrobble{}
+
+	slog.Info("Scrobbles found on page", "count", len(scrobbleRows))
+	for _, row := range scrobbleRows {
+		scrobble, err := generateScrobble(row)
+		if err != nil {
+			slog.Error("Failed to generate scrobble", "error", err)
+			continue
+		}
+		slog.Debug("Generated scrobble", "artist", scrobble.artist, "track", scrobble.track, "timestamp", scrobble.timestamp)
+		scrobble.page = currentPage
+		scrobbles = append(scrobbles, scrobble)
+	}
+
+	slices.Reverse(scrobbles)
+	return scrobbles, nil
+}
+
+func getScrobbleRows(tabCtx context.Context, c *Config, currentPage int) ([]string, error) {
+	timeoutCtx, timeoutCancel := context.WithTimeout(tabCtx, browserOperationsTimeout)
 	defer timeoutCancel()
 
-	query := fmt.Sprintf("https://www.last.fm/user/%s/library?page=%s", c.LastFMUsername, strconv.Itoa(currentPage))
+	// The main tab is also used to delete scrobbles, it remembers its loaded page
+	load := navigateToLibraryPage
+	if tabCtx == c.taskCtx {
+		load = loadLibraryPage
+	}
+
+	// Rows extracted after a failed navigation would make the page look empty, retry it instead
+	if err := load(timeoutCtx, c, currentPage); err != nil {
+		slog.Warn("Failed to navigate to page", "page", currentPage, "error", err)
+		return nil, fmt.Errorf("failed to navigate to page %d: %w", currentPage, err)
+	}
+
+	if c.SaveTrace && !c.dataDirReadOnly {
+		if err := savePageTrace(timeoutCtx, c, currentPage); err != nil {
+			slog.Warn("Failed to save page trace", "page", currentPage, "error", err)
+		}
+	}
+
+	var scrobbleRows []string
+	err := chromedp.Run(timeoutCtx,
+		chromedp.Evaluate(`[...document.querySelectorAll('.chartlist-row')].map((e) => e.outerHTML)`, &scrobbleRows),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve scrobble rows: %w", err)
+	}
+
+	return scrobbleRows, nil
+}
+
+// loadLibraryPage navigates the browser to a page of the user's library and remembers it as the loaded page
+func loadLibraryPage(ctx context.Context, c *Config, page int) error {
+	c.loadedPage = 0
+	if err := navigateToLibraryPage(ctx, c, page); err != nil {
+		return err
+	}
+	c.loadedPage = page
+	return nil
+}
+
+func navigateToLibraryPage(ctx context.Context, c *Config, page int) error {
+	query := fmt.Sprintf("https://www.last.fm/user/%s/library?page=%s", c.LastFMUsername, strconv.Itoa(page))
 
 	url, err := url.Parse(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse library query URL: %w", err)
+		return fmt.Errorf("failed to parse library query URL: %w", err)
 	}
 
 	if !c.From.IsZero() {
@@ -263,41 +426,38 @@ func getScrobbles(c *Config, currentPage int) ([]scrobble, error) {
 	}
 
 	slog.Debug("get scrobble library page", "query", query)
+	touchBrowser(c)
 
-	err = chromedp.Run(timeoutCtx,
+	return chromedp.Run(ctx,
+		slowMo(c),
 		chromedp.Navigate(url.String()),
 		chromedp.WaitVisible(`.top-bar`, chromedp.ByQuery),
 		// Remove the top bar to avoid clicking on it by accident when deleting scrobbles
 		chromedp.Evaluate("let node1 = document.querySelector('.top-bar'); node1.parentNode.removeChild(node1)", nil),
 		chromedp.Evaluate("let node2 = document.querySelector('.masthead'); node2.parentNode.removeChild(node2)", nil),
 	)
-	if err != nil {
-		slog.Error("Failed to navigate to page", "page", currentPage, "error", err)
-	}
-
-	var scrobbleRows []string
-	err = chromedp.Run(timeoutCtx,
-		chromedp.Evaluate(`[...document.querySelectorAll('.chartlist-row')].map((e) => e.outerHTML)`, &scrobbleRows),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve scrobble rows: %w", err)
-	}
+}
 
-	scrobbles := []scrobble{}
+func hasTimeOfDay(t time.Time) bool {
+	return !t.IsZero() && (t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0)
+}
 
-	slog.Info("Scrobbles found on page", "count", len(scrobbleRows))
-	for _, row := range scrobbleRows {
-		scrobble, err := generateScrobble(row)
-		if err != nil {
-			slog.Error("Failed to generate scrobble", "error", err)
-			continue
-		}
-		slog.Debug("Generated scrobble", "artist", scrobble.artist, "track", scrobble.track, "timestamp", scrobble.timestamp)
-		scrobbles = append(scrobbles, scrobble)
+// filterScrobblesInRange drops the scrobbles outside of the from / to bounds that have a time of day,
+// the Last.fm library only filters by day so boundary pages may contain out of range scrobbles
+func filterScrobblesInRange(c *Config, scrobbles []scrobble) []scrobble {
+	filterFrom, filterTo := hasTimeOfDay(c.From), hasTimeOfDay(c.To)
+	if !filterFrom && !filterTo {
+		return scrobbles
 	}
 
-	slices.Reverse(scrobbles)
-	return scrobbles, nil
+	count := len(scrobbles)
+	scrobbles = slices.DeleteFunc(scrobbles, func(s scrobble) bool {
+		return (filterFrom && s.timestamp.Before(c.From)) || (filterTo && s.timestamp.After(c.To))
+	})
+	if filtered := count - len(scrobbles); filtered > 0 {
+		slog.Info("Ignored scrobbles outside of the from / to range", "count", filtered)
+	}
+	return scrobbles
 }
 
 func generateScrobble(row string) (scrobble, error) {
@@ -342,6 +502,12 @@ func generateScrobble(row string) (scrobble, error) {
 		return scrobble{}, fmt.Errorf("timestamp not found in row: %s", row)
 	}
 
+	// The album is only shown as the alt text of the cover, it is missing for some scrobbles
+	var album string
+	if albumNode := htmlquery.FindOne(doc, `.//td[contains(@class,'chartlist-image')]//img`); albumNode != nil {
+		album = strings.TrimSpace(htmlquery.SelectAttr(albumNode, "alt"))
+	}
+
 	urlNode := htmlquery.FindOne(doc, `.//td[contains(@class,'chartlist-name')]/a`)
 	if urlNode != nil {
 		scrobblePath := strings.TrimSpace(htmlquery.SelectAttr(urlNode, "href"))
@@ -357,15 +523,26 @@ func generateScrobble(row string) (scrobble, error) {
 	return scrobble{
 		artist:          artist,
 		track:           track,
+		album:           album,
 		timestamp:       timestamp,
 		timestampString: timestampStr,
 		url:             scrobbleURL,
 	}, nil
 }
 
-var ErrUnknownTrackAlreadyInMap = errors.New("no duration found in cache or MusicBrainz API, track already saved in unknown track durations")
+var (
+	ErrUnknownTrackAlreadyInMap = errors.New("no duration found in cache or MusicBrainz API, track already saved in unknown track durations")
+	ErrTrackDurationNotFound    = errors.New("no duration found in cache or MusicBrainz API")
+)
 
 func getTrackDuration(ctx context.Context, c *Config, userTrackDurations durationByTrackByArtist, s *scrobble) error {
+	if c.PerScrobbleTimeout > 0 {
+		// Retries and requests stop at the deadline, a MusicBrainz request in flight is abandoned
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.PerScrobbleTimeout)
+		defer cancel()
+	}
+
 	// Check if track is in userTrackDurations
 	if userTrackDurations != nil && userTrackDurations[s.artist] != nil && userTrackDurations[s.artist][s.track] != "" {
 		// Convert to duration with 4m0s format
@@ -379,54 +556,46 @@ func getTrackDuration(ctx context.Context, c *Config, userTrackDurations duratio
 		return nil
 	}
 
-	if c.unknownTrackDurations[s.artist] != nil {
-		if _, found := c.unknownTrackDurations[s.artist][s.track]; found {
-			return ErrUnknownTrackAlreadyInMap
-		}
+	if duration, found := c.localLibrary[localTrackKey(s.artist, s.track)]; found {
+		s.trackDuration = duration
+		slog.Debug("Found track duration in local library", "artist", s.artist, "track", s.track, "duration", s.trackDuration)
+		return nil
 	}
 
-	query := fmt.Sprintf(`artist:"%s" AND recording:"%s"`, s.artist, s.track)
-	// Hash the query
-	queryHasher := sha256.New()
-	queryHasher.Write([]byte(query))
-	cacheKey := fmt.Sprintf("mbquery:%x", queryHasher.Sum(nil))
+	if isKnownUnknownTrack(c, s.artist, s.track) {
+		return ErrUnknownTrackAlreadyInMap
+	}
+
+	// Keyed by the artist of the MusicBrainz query, so that a track is looked up again once its artist is aliased
+	cacheKey := trackDurationCacheKey(musicBrainzArtist(c, s.artist), s.track)
+	if mbid, found := pinnedMBID(c, s.artist, s.track); found {
+		cacheKey = pinnedTrackDurationCacheKey(mbid)
+	}
 
 	cacheGetStartTime := time.Now()
 	cachedTrackDuration, err := c.cache.Get(ctx, cacheKey)
 	slog.Debug("Cache get", "took", time.Since(cacheGetStartTime), "key", cacheKey)
 	if err != nil {
 		if errors.Is(err, cache.ErrCacheMiss) {
-			c.runStats.cacheMisses++
+			c.runStats.cacheMisses.Add(1)
 			slog.Debug("Cache miss for track duration query", "artist", s.artist, "track", s.track)
-
-			trackDuration, err := backoff.Retry(ctx, func() (time.Duration, error) {
-				return getTrackDurationFromMusicBrainz(c, s.artist, s.track)
-			}, backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(10))
-			if err != nil {
-				return fmt.Errorf("failed to get track duration from MusicBrainz API: %w", err)
-			}
-			if trackDuration == 0 {
-				trackDuration, err = getTrackDurationFromLastFM(c, s.url)
-				if err != nil {
-					slog.Warn("Could not get track duration from Last.fm", "error", err, "scrobbleURL", s.url)
-				}
-			}
-			if trackDuration <= 0 {
-				return addToUnknownTrackDurations(c, s.artist, s.track)
-			}
-			s.trackDuration = trackDuration
-			cacheTrackDuration(ctx, c, cacheKey, trackDuration)
-			slog.Debug("Found track duration", "artist", s.artist, "track", s.track, "duration", s.trackDuration)
-			return nil
+			return lookupTrackDuration(ctx, c, cacheKey, s)
 		}
 		return fmt.Errorf("failed to get cached track duration: %w", err)
 	}
 
-	c.runStats.cacheHits++
-	s.trackDuration, err = time.ParseDuration(cachedTrackDuration)
+	s.trackDuration, err = parseCachedTrackDuration(cachedTrackDuration)
 	if err != nil {
-		return fmt.Errorf("failed to parse cached track duration: %w", err)
+		// A partial write or a format change must not fail the track on every run, the entry is looked up again
+		slog.Warn("Purging corrupted cache entry", "key", cacheKey, "artist", s.artist, "track", s.track, "value", cachedTrackDuration, "error", err)
+		c.runStats.corruptedCacheEntries.Add(1)
+		if err := c.cache.Delete(ctx, cacheKey); err != nil {
+			slog.Warn("Failed to delete cache entry", "key", cacheKey)
+		}
+		c.runStats.cacheMisses.Add(1)
+		return lookupTrackDuration(ctx, c, cacheKey, s)
 	}
+	c.runStats.cacheHits.Add(1)
 	if s.trackDuration <= 0 {
 		cacheDeleteStartTime := time.Now()
 		if err := c.cache.Delete(ctx, cacheKey); err != nil {
@@ -440,53 +609,177 @@ func getTrackDuration(ctx context.Context, c *Config, userTrackDurations duratio
 	return nil
 }
 
-func addToUnknownTrackDurations(c *Config, artist, track string) error {
-	if c.unknownTrackDurations[artist] == nil {
-		c.unknownTrackDurations[artist] = make(map[string]string)
+// lookupTrackDuration looks up the duration of a track missing from the cache and caches it
+func lookupTrackDuration(ctx context.Context, c *Config, cacheKey string, s *scrobble) error {
+	lookupStartTime := time.Now()
+	var (
+		trackDuration time.Duration
+		fromPlayGaps  bool
+		err           error
+	)
+	if !c.DisableMusicBrainz {
+		lookup, err := backoff.Retry(ctx, func() (musicBrainzLookup, error) {
+			return lookupMusicBrainzTrackDuration(ctx, c, s.artist, s.track)
+		}, backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(10))
+		if err != nil {
+			return fmt.Errorf("failed to get track duration from MusicBrainz API: %w", err)
+		}
+		trackDuration, fromPlayGaps = lookup.duration, lookup.fromPlayGaps
 	}
-	if _, found := c.unknownTrackDurations[artist][track]; !found {
-		c.unknownTrackDurations[artist][track] = ""
-		c.runStats.unknownTrackDurationsCount++
+	if trackDuration == 0 && !c.DisableLastFMFallback {
+		switch {
+		case c.LastFMAPIKey != "":
+			trackDuration, err = getTrackDurationFromLastFMAPI(ctx, c, s.artist, s.track)
+			if err != nil {
+				slog.Warn("Could not get track duration from Last.fm API", "error", err, "artist", s.artist, "track", s.track)
+			}
+		// Replayed pages and exports run without a browser, the Last.fm track page can't be scraped
+		case !c.ReplayPages && !c.analyzeOnly:
+			trackDuration, err = getTrackDurationFromLastFM(ctx, c, s.url)
+			if err != nil {
+				slog.Warn("Could not get track duration from Last.fm", "error", err, "scrobbleURL", s.url)
+			}
+		}
+	}
+	c.runStats.durationLookups.Add(1)
+	c.runStats.durationLookupTime.Add(int64(time.Since(lookupStartTime)))
+	if trackDuration <= 0 {
+		return addToUnknownTrackDurations(c, s.artist, s.track)
+	}
+	s.trackDuration = trackDuration
+	if fromPlayGaps {
+		// Cached, the duration would be used for every page whatever the play gaps on it
+		slog.Debug("Not caching track duration chosen with the play gaps of the page", "artist", s.artist, "track", s.track, "duration", trackDuration)
 	} else {
-		return ErrUnknownTrackAlreadyInMap
+		cacheTrackDuration(ctx, c, cacheKey, s.artist, s.track, trackDuration)
 	}
-	return fmt.Errorf("track %s - %s, saved to unknown track durations", artist, track)
+	slog.Debug("Found track duration", "artist", s.artist, "track", s.track, "duration", s.trackDuration)
+	return nil
 }
 
-func getTrackDurationFromMusicBrainz(c *Config, artist, track string) (time.Duration, error) {
-	query := fmt.Sprintf(`artist:"%s" AND recording:"%s"`, artist, track)
-	resp, err := c.mb.SearchRecording(query, -1, -1)
+func parseCachedTrackDuration(value string) (time.Duration, error) {
+	entry, err := decodeTrackDurationCacheEntry(value)
 	if err != nil {
-		return 0, fmt.Errorf("failed to search MusicBrainz: %w", err)
+		return 0, err
 	}
+	duration, err := time.ParseDuration(entry.Duration)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse cached track duration: %w", err)
+	}
+	return duration, nil
+}
+
+var (
+	// Featured artists, credited in the artist or the track on Last.fm and in the artist credit on MusicBrainz
+	featuringRegexp          = regexp.MustCompile(`(?i)\s*[(\[]\s*(feat\.?|ft\.?|featuring|with)\s[^)\]]*[)\]]|\s+(feat\.?|ft\.?|featuring)\s.*$`)
+	versionParenthesesRegexp = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*\b(remaster(ed)?|version|mono|stereo|deluxe)\b[^)\]]*[)\]]`)
+	versionSuffixRegexp      = regexp.MustCompile(`(?i)\s+-\s+[^-]*\b(remaster(ed)?|version|mono|stereo|deluxe)\b[^-]*$`)
+	luceneSpecialCharacters  = strings.NewReplacer(
+		`\`, `\\`, `+`, `\+`, `-`, `\-`, `&`, `\&`, `|`, `\|`, `!`, `\!`, `(`, `\(`, `)`, `\)`, `{`, `\{`, `}`, `\}`,
+		`[`, `\[`, `]`, `\]`, `^`, `\^`, `"`, `\"`, `~`, `\~`, `*`, `\*`, `?`, `\?`, `:`, `\:`, `/`, `\/`,
+	)
+	typographicPunctuation = strings.NewReplacer("‘", "'", "’", "'", "“", `"`, "”", `"`, "–", "-", "—", "-")
+)
+
+// normalizeForSearch turns the artist and track of a scrobble into MusicBrainz query terms: featured artists and
+// remaster or version suffixes that Last.fm adds are removed, unicode is normalized and Lucene special characters escaped.
+// The original strings remain the identity of the track, for the cache keys among others.
+func normalizeForSearch(artist, track string) (string, string) {
+	return luceneSpecialCharacters.Replace(normalizeName(artist)), luceneSpecialCharacters.Replace(normalizeName(track))
+}
 
-	if len(resp.Recordings) == 0 {
-		// Not found, don't return an error and skip setting cache
-		return 0, nil
+// normalizeName removes the featured artists and the remaster or version suffixes of an artist or track name
+func normalizeName(s string) string {
+	s = typographicPunctuation.Replace(norm.NFC.String(s))
+	s = featuringRegexp.ReplaceAllString(s, "")
+	s = versionParenthesesRegexp.ReplaceAllString(s, "")
+	s = versionSuffixRegexp.ReplaceAllString(s, "")
+	return strings.TrimSpace(s)
+}
+
+// trackDurationCacheKey returns the cache key of the duration of a track, a hash of its MusicBrainz query
+func trackDurationCacheKey(artist, track string) string {
+	query := fmt.Sprintf(`artist:"%s" AND recording:"%s"`, artist, track)
+	queryHasher := sha256.New()
+	queryHasher.Write([]byte(query))
+	return fmt.Sprintf("%s%x", trackDurationCacheKeyPrefix, queryHasher.Sum(nil))
+}
+
+func addToUnknownTrackDurations(c *Config, artist, track string) error {
+	if isKnownUnknownTrack(c, artist, track) {
+		return ErrUnknownTrackAlreadyInMap
 	}
+	if c.unknownTrackDurations[artist] == nil {
+		c.unknownTrackDurations[artist] = make(map[string]string)
+	}
+	c.unknownTrackDurations[artist][track] = ""
+	c.runStats.unknownTrackDurationsCount.Add(1)
+	c.unknownTrackDurationsInMemory++
 
-	if len(resp.Recordings) > 1 {
-		slog.Debug("Multiple MusicBrainz recordings found, using the first one", "query", query, "count", len(resp.Recordings))
-		for i, rec := range resp.Recordings {
-			slog.Debug("Recording", "index", i, "artist", rec.ArtistCredit.NameCredits, "track", rec.Title, "duration", rec.Length)
+	if c.unknownTrackDurationsInMemory >= maxUnknownTrackDurationsInMemory && !c.dataDirReadOnly && !c.CountOnly {
+		if err := flushUnknownTrackDurations(c); err != nil {
+			slog.Warn("Failed to flush unknown track durations, keeping them in memory", "error", err)
 		}
 	}
+	return fmt.Errorf("%w: track %s - %s, saved to unknown track durations", ErrTrackDurationNotFound, artist, track)
+}
 
-	duration := time.Duration(resp.Recordings[0].Length) * time.Millisecond
-	return duration, nil
+// isKnownUnknownTrack reports whether a track was already saved to the unknown track durations during this run
+func isKnownUnknownTrack(c *Config, artist, track string) bool {
+	if _, found := c.unknownTrackDurations[artist][track]; found {
+		return true
+	}
+	_, found := c.flushedUnknownTracks[unknownTrackHash(artist, track)]
+	return found
+}
+
+func unknownTrackHash(artist, track string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(artist))
+	h.Write([]byte{0})
+	h.Write([]byte(track))
+	return h.Sum64()
+}
+
+// flushUnknownTrackDurations merges the unknown track durations into the file and clears them from memory,
+// only a hash of each flushed track is kept to avoid looking it up again
+func flushUnknownTrackDurations(c *Config) error {
+	// Hash before writing, the write merges the tracks of the file into the map
+	hashes := make([]uint64, 0, c.unknownTrackDurationsInMemory)
+	for artist, tracks := range c.unknownTrackDurations {
+		for track := range tracks {
+			hashes = append(hashes, unknownTrackHash(artist, track))
+		}
+	}
+
+	if err := writeUnknownTrackDurations(c.unknownTrackDurations, c.DataDir, c.dataDirReadOnly); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		c.flushedUnknownTracks[h] = struct{}{}
+	}
+	slog.Debug("Flushed unknown track durations", "count", c.unknownTrackDurationsInMemory)
+	c.unknownTrackDurations = make(durationByTrackByArtist)
+	c.unknownTrackDurationsInMemory = 0
+	return nil
 }
 
-func getTrackDurationFromLastFM(c *Config, url string) (time.Duration, error) {
+func getTrackDurationFromLastFM(ctx context.Context, c *Config, url string) (time.Duration, error) {
 	var duration time.Duration
 
+	// The tab belongs to the browser of the task context, it only takes the deadline of ctx
 	timeoutCtx, cancel := context.WithTimeout(c.taskCtx, browserOperationsTimeout)
 	defer cancel()
+	if deadline, found := ctx.Deadline(); found {
+		timeoutCtx, cancel = context.WithDeadline(timeoutCtx, deadline)
+		defer cancel()
+	}
 
-	ctx, cancel := chromedp.NewContext(timeoutCtx)
+	tabCtx, cancel := chromedp.NewContext(timeoutCtx)
 	defer cancel()
 
 	trackDurationText := ""
-	err := chromedp.Run(ctx,
+	err := chromedp.Run(tabCtx,
 		chromedp.Navigate(url),
 		chromedp.WaitVisible(`//div[@class='header-new-content']`, chromedp.BySearch),
 		chromedp.Evaluate(`[...document.querySelectorAll('.catalogue-metadata-heading')].find((e) => e.innerText == "Length")?.nextElementSibling?.innerText`, &trackDurationText),
@@ -498,116 +791,415 @@ func getTrackDurationFromLastFM(c *Config, url string) (time.Duration, error) {
 		return duration, err
 	}
 
-	duration, err = time.ParseDuration(fmt.Sprintf("%ss", strings.ReplaceAll(trackDurationText, ":", "m")))
+	// No length shown on the track page returns a zero duration
+	duration, err = helpers.ParseClockDuration(trackDurationText)
 	if err != nil {
-		return duration, err
+		return duration, fmt.Errorf("failed to parse Last.fm track length: %w", err)
 	}
 	slog.Debug("Parsed duration from last.fm", "trackDurationText", trackDurationText, "calculatedDuration", duration)
 
 	return duration, nil
 }
 
-func cacheTrackDuration(ctx context.Context, c *Config, cacheKey string, duration time.Duration) {
+func cacheTrackDuration(ctx context.Context, c *Config, cacheKey, artist, track string, duration time.Duration) {
+	value, err := encodeTrackDurationCacheEntry(artist, track, duration)
+	if err != nil {
+		slog.Error("Failed to encode track duration", "error", err)
+		return
+	}
 	cacheSetStartTime := time.Now()
-	err := c.cache.Set(ctx, cacheKey, duration.String())
+	err = c.cache.SetWithTTL(ctx, cacheKey, value, c.CacheTTL)
 	slog.Debug("Cache set", "took", time.Since(cacheSetStartTime), "key", cacheKey)
 	if err != nil {
 		slog.Error("Failed to cache track duration", "error", err)
 	}
 }
 
-func processScrobblesFromStartToEndPage(ctx context.Context, c *Config, startPage int, endPage int, userTrackDurations durationByTrackByArtist) error {
+// pageFetcher returns the scrobbles of a library page, pages are requested from the start page to the end page
+type pageFetcher func(page int) ([]scrobble, error)
+
+// sequentialPageFetcher loads each page in the main browser tab when it is requested
+func sequentialPageFetcher(ctx context.Context, c *Config, startPage int) pageFetcher {
+	return func(page int) ([]scrobble, error) {
+		if page != startPage {
+			if err := pauseBetweenPages(ctx, c); err != nil {
+				return nil, err
+			}
+		}
+		return fetchPage(ctx, c.taskCtx, c, page)
+	}
+}
+
+func fetchPage(ctx context.Context, tabCtx context.Context, c *Config, page int) ([]scrobble, error) {
+	return backoff.Retry(ctx, func() ([]scrobble, error) {
+		return getScrobbles(tabCtx, c, page)
+	}, backoff.WithMaxTries(3))
+}
 
+func processScrobblesFromStartToEndPage(ctx context.Context, c *Config, startPage int, endPage int, userTrackDurations durationByTrackByArtist, getPage pageFetcher) error {
+	// Carried over pages so that duplicates split across a page boundary are detected
+	var previousScrobble *scrobble
 	for currentPage := startPage; currentPage >= endPage; currentPage-- {
 		slog.Info("Processing page", "page", currentPage)
-		scrobbles, err := backoff.Retry(ctx, func() ([]scrobble, error) {
-			return getScrobbles(c, currentPage)
-		}, backoff.WithMaxTries(3))
+		scrobbles, err := getPage(currentPage)
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !c.ContinueOnPageError {
+				return err
+			}
+			slog.Error("Failed to get page scrobbles, skipping page", "page", currentPage, "error", err)
+			c.failedPages = append(c.failedPages, currentPage)
+			// The scrobbles around the failed page are not successive
+			resolveDuplicateCluster(ctx, c, nil)
+			resolvePendingSkip(ctx, c, nil)
+			previousScrobble = nil
+			continue
 		}
+		scrobbles = filterScrobblesInRange(c, scrobbles)
+		c.pageTrackGaps = getTrackGaps(scrobbles)
 
-		var previousScrobble *scrobble
 		for _, currentScrobble := range scrobbles {
+			if isFutureScrobble(&currentScrobble) {
+				// Neither compared nor kept as context, its gaps with the other scrobbles are meaningless
+				slog.Warn("Ignoring scrobble with a timestamp in the future", "artist", currentScrobble.artist, "track", currentScrobble.track, "timestamp", currentScrobble.timestamp)
+				c.runStats.futureScrobbles.Add(1)
+				continue
+			}
+			// Already processed scrobbles count too, they can be the surviving scrobble of the first pair
+			countProcessedScrobble(c, &currentScrobble)
+			if isAlreadyProcessed(c, &currentScrobble) {
+				// Keep it as context so the first new scrobble can still be compared to it
+				previousScrobble = &currentScrobble
+				continue
+			}
+			if c.scanOnly {
+				scanScrobble(ctx, c, &currentScrobble, userTrackDurations)
+				continue
+			}
+			if c.warmCacheOnly {
+				warmCacheScrobble(ctx, c, &currentScrobble, userTrackDurations)
+				continue
+			}
 			previousScrobble = processPreviousAndCurrentScrobbles(ctx, c, previousScrobble, &currentScrobble, userTrackDurations)
-			c.runStats.processedScrobbles++
+			c.runStats.processedScrobbles.Add(1)
+			if currentScrobble.timestamp.After(c.lastProcessedTimestamp) {
+				c.lastProcessedTimestamp = currentScrobble.timestamp
+			}
+			if err := checkDeleteFailures(c); err != nil {
+				return err
+			}
+		}
+		saveCheckpoint(c, currentPage)
+		notifyProgress(ctx, c, startPage-currentPage+1, startPage-endPage+1)
+
+		if c.stopCtx != nil && c.stopCtx.Err() != nil {
+			slog.Warn("Stopping after page due to interrupt", "page", currentPage)
+			break
 		}
 	}
-	return nil
+	resolveDuplicateCluster(ctx, c, nil)
+	resolvePendingSkip(ctx, c, nil)
+	return checkDeleteFailures(c)
+}
+
+// isFutureScrobble reports whether a scrobble was recorded with a timestamp in the future, which some devices do
+func isFutureScrobble(s *scrobble) bool {
+	return s.timestamp.After(time.Now().Add(futureScrobbleTolerance))
+}
+
+// pageDelay returns the configured page delay plus a random jitter
+func pageDelay(c *Config) time.Duration {
+	delay := c.PageDelay
+	if c.PageDelayJitter > 0 {
+		delay += rand.N(c.PageDelayJitter + 1)
+	}
+	return delay
+}
+
+// pauseBetweenPages waits for the page delay, returning early if ctx is done
+func pauseBetweenPages(ctx context.Context, c *Config) error {
+	delay := pageDelay(c)
+	if delay <= 0 {
+		return nil
+	}
+
+	slog.Debug("Pausing between pages", "delay", delay)
+	return helpers.SleepContext(ctx, delay)
 }
 
 func processPreviousAndCurrentScrobbles(ctx context.Context, c *Config, previousScrobble *scrobble, currentScrobble *scrobble, userTrackDurations durationByTrackByArtist) *scrobble {
+	if previousScrobble != nil && startsNewSession(c, previousScrobble, currentScrobble) {
+		// The previous session ended with the previous scrobble
+		resolveDuplicateCluster(ctx, c, nil)
+		resolvePendingSkip(ctx, c, nil)
+		previousScrobble = nil
+	}
+
+	if c.SkipUncomparedDurations && (previousScrobble == nil || !isSameTrack(previousScrobble, currentScrobble)) {
+		// Only duplicate detection is enabled, it never compares scrobbles of different tracks
+		c.runStats.uncomparedScrobbles.Add(1)
+		resolveDuplicateCluster(ctx, c, currentScrobble)
+		return currentScrobble
+	}
+
 	err := getTrackDuration(ctx, c, userTrackDurations, currentScrobble)
 	if err != nil {
-		if !errors.Is(err, ErrUnknownTrackAlreadyInMap) {
+		switch {
+		case errors.Is(err, ErrUnknownTrackAlreadyInMap):
+			c.runStats.skippedKnownUnknownDuration.Add(1)
+		case errors.Is(err, ErrTrackDurationNotFound):
+			slog.Warn("failed to get track duration, skipping scrobble", "error", err)
+			c.runStats.skippedDurationNotFound.Add(1)
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			slog.Warn("track duration lookup timed out, skipping scrobble", "artist", currentScrobble.artist, "track", currentScrobble.track, "timeout", c.PerScrobbleTimeout, "error", err)
+			c.runStats.skippedDurationLookupError.Add(1)
+			c.runStats.lookupTimeouts.Add(1)
+		default:
 			slog.Warn("failed to get track duration, skipping scrobble", "error", err)
+			c.runStats.skippedDurationLookupError.Add(1)
 		}
-		c.runStats.skippedScrobbleUnknownDuration++
+		resolveDuplicateCluster(ctx, c, currentScrobble)
 		return currentScrobble
 	}
 	slog.Debug("Track duration found", "artist", currentScrobble.artist, "track", currentScrobble.track, "duration", currentScrobble.trackDuration)
+	if previousScrobble != nil && previousScrobble.trackDuration == 0 && isSameTrack(previousScrobble, currentScrobble) {
+		// Not looked up with skip-uncompared-durations, its duration is exported with it when it is deleted
+		previousScrobble.trackDuration = currentScrobble.trackDuration
+	}
 
 	if previousScrobble != nil {
 		isDuplicate, err := detectDuplicateScrobble(c, previousScrobble, currentScrobble)
 		if err != nil {
 			slog.Warn("failed to detect duplicated scrobble", "error", err)
+			resolvePendingSkip(ctx, c, currentScrobble)
 			return currentScrobble
 		}
 		if isDuplicate {
-			c.deletedScrobbles = append(c.deletedScrobbles, currentScrobble)
-			if c.CanDelete {
-				if err := deleteScrobbleWithRetries(ctx, c, previousScrobble.timestampString, false, 3); err != nil {
-					slog.Warn("failed to delete scrobble", "error", err)
-				}
-				slog.Info("Previous scrobble deleted", "artist", currentScrobble.artist, "track", currentScrobble.track, "timestamp", previousScrobble.timestamp)
+			// The next scrobbles of the burst are compared with its first play, not with this duplicate
+			currentScrobble.burstStart = previousScrobble.playStart()
+			resolvePendingSkip(ctx, c, currentScrobble)
+			if c.Keep == KeepHighestCompletion {
+				addToDuplicateCluster(c, previousScrobble, currentScrobble)
+				return currentScrobble
+			}
+			if _, err := handleDetectedScrobble(ctx, c, previousScrobble, currentScrobble, false, "duplicate"); err != nil {
+				slog.Warn("failed to delete scrobble", "error", err)
 			}
 			return currentScrobble
 		}
+		resolveDuplicateCluster(ctx, c, currentScrobble)
 
 		if c.CompleteThreshold > 0 {
 			isIncomplete, err := detectIncompleteScrobble(c, previousScrobble, currentScrobble)
 			if err != nil {
 				slog.Warn("failed to detect incomplete scrobble", "error", err)
+				resolvePendingSkip(ctx, c, currentScrobble)
 				return currentScrobble
 			}
+			currentScrobble.complete = !isIncomplete
+			resolvePendingSkip(ctx, c, currentScrobble)
 
 			if isIncomplete {
-				c.deletedScrobbles = append(c.deletedScrobbles, currentScrobble)
-				if c.CanDelete {
-					if err := deleteScrobbleWithRetries(ctx, c, currentScrobble.timestampString, true, 3); err != nil {
-						slog.Warn("failed to delete scrobble", "error", err)
-						return currentScrobble
-					}
-					slog.Info("Current scrobble deleted", "artist", currentScrobble.artist, "track", currentScrobble.track, "timestamp", currentScrobble.timestamp)
+				if c.SkipOnlyWhenSurrounded {
+					// Whether the next scrobble is a complete play is only known once it is processed
+					deferSkip(c, previousScrobble, currentScrobble)
+					return currentScrobble
+				}
+				// Depending on the target, the surviving scrobble is the one compared with the next scrobble
+				scrobbleToDelete, survivingScrobble := currentScrobble, previousScrobble
+				deleteCurrentScrobble := true
+				if c.IncompleteDeleteTarget == IncompleteDeleteTargetPrevious {
+					scrobbleToDelete, survivingScrobble = previousScrobble, currentScrobble
+					deleteCurrentScrobble = false
 				}
-				return previousScrobble
+
+				kept, err := handleDetectedScrobble(ctx, c, scrobbleToDelete, survivingScrobble, deleteCurrentScrobble, "incomplete")
+				if err != nil {
+					slog.Warn("failed to delete scrobble", "error", err)
+					return currentScrobble
+				}
+				if kept {
+					return currentScrobble
+				}
+				return survivingScrobble
 			}
 		}
 	}
 	return currentScrobble
 }
 
+// handleDetectedScrobble records a detected scrobble and deletes it when deletion is enabled.
+// It returns true if the scrobble was kept during review.
+func handleDetectedScrobble(ctx context.Context, c *Config, scrobbleToDelete *scrobble, survivingScrobble *scrobble, deleteCurrentScrobble bool, reason string) (bool, error) {
+	if keepLastRemainingScrobble(c, scrobbleToDelete, reason) {
+		return true, nil
+	}
+
+	canDelete := c.canDelete
+	if c.Review {
+		decision, err := reviewScrobble(c, scrobbleToDelete, deleteCurrentScrobble, reason)
+		if err != nil {
+			return false, fmt.Errorf("failed to review scrobble: %w", err)
+		}
+		switch decision {
+		case reviewDecisionKeep:
+			c.runStats.reviewKeptScrobbles.Add(1)
+			slog.Info("Scrobble kept after review", "artist", scrobbleToDelete.artist, "track", scrobbleToDelete.track, "timestamp", scrobbleToDelete.timestamp)
+			return true, nil
+		case reviewDecisionDelete:
+			canDelete = true
+		case reviewDecisionSkip:
+			canDelete = false
+		}
+	}
+
+	if canDelete && c.DeleteSample > 0 && c.runStats.sampleDeletions.Load() >= int64(c.DeleteSample) {
+		canDelete = false
+	}
+
+	// Record the scrobble targeted by the deletion, not the surviving one of the pair
+	scrobbleToDelete.survivingTimestamp = survivingScrobble.timestamp
+	scrobbleToDelete.completion = pairCompletion(c, scrobbleToDelete, survivingScrobble, reason)
+	scrobbleToDelete.deleteXPath = deleteScrobbleXPath(scrobbleToDelete, deleteCurrentScrobble)
+	c.deletedScrobbles = append(c.deletedScrobbles, scrobbleToDelete)
+	c.detectedByTrack[trackKey{scrobbleToDelete.artist, scrobbleToDelete.track}]++
+	if !canDelete {
+		slog.Debug("Scrobble not deleted", "reason", reason, "artist", scrobbleToDelete.artist, "track", scrobbleToDelete.track, "xpath", scrobbleToDelete.deleteXPath)
+		if c.DeleteSample > 0 {
+			c.runStats.sampleDryRunScrobbles.Add(1)
+		}
+		return false, nil
+	}
+
+	if err := deleteScrobbleWithRetries(ctx, c, scrobbleToDelete, deleteCurrentScrobble, 3); err != nil {
+		return false, err
+	}
+	countDeletion(c)
+	slog.Info("Scrobble deleted", "reason", reason, "artist", scrobbleToDelete.artist, "track", scrobbleToDelete.track, "timestamp", scrobbleToDelete.timestamp)
+	if c.webhook != nil {
+		c.webhook.notify(scrobbleToDelete, reason)
+	}
+	return false, nil
+}
+
+// countDeletion counts a successful deletion, failed deletions neither shrink the library nor use up the delete sample
+func countDeletion(c *Config) {
+	c.runStats.deletions.Add(1)
+	// Add returns the count of this deletion, the sample is reached by a single one of them
+	if c.DeleteSample > 0 && c.runStats.sampleDeletions.Add(1) == int64(c.DeleteSample) {
+		slog.Warn("Delete sample reached, continuing in dry-run mode", "deleteSample", c.DeleteSample)
+	}
+}
+
+// isBelowThreshold reports whether a completion percentage is strictly below a threshold.
+// A percentage within epsilon of the threshold counts as reaching it, so a scrobble
+// completing at exactly the threshold (give or take float jitter) is never flagged.
+func isBelowThreshold(completionPercentage float64, threshold int, epsilon float64) bool {
+	return completionPercentage < float64(threshold)-epsilon
+}
+
+// fullPlayDuration returns the play duration of a track at which Last.fm records a scrobble, a play reaching it counts
+// as a full play
+func fullPlayDuration(c *Config, trackDuration time.Duration) time.Duration {
+	return min(time.Duration(float64(trackDuration)*float64(c.FullPlayAt)/100.0), maxFullPlayDuration)
+}
+
+// playCompletion returns the completion percentage of a play of a track as measured by duplicate detection,
+// relative to MinReplayGap when set and to the full play duration otherwise
+func playCompletion(c *Config, playDuration, trackDuration time.Duration) float64 {
+	fullPlay := fullPlayDuration(c, trackDuration)
+	if c.MinReplayGap > 0 {
+		fullPlay = c.MinReplayGap
+	}
+	if fullPlay <= 0 {
+		return 0
+	}
+	return min(float64(playDuration)/float64(fullPlay)*100, 100)
+}
+
+// duplicateCompletion returns the completion percentage of the play from the start of the previous scrobble's play to
+// the current scrobble, see detectDuplicateScrobble
+func duplicateCompletion(c *Config, previousScrobble *scrobble, currentScrobble *scrobble) float64 {
+	return playCompletion(c, currentScrobble.timestamp.Sub(previousScrobble.playStart()), currentScrobble.trackDuration)
+}
+
+// incompleteCompletion returns the completion percentage of the whole track duration played between the previous
+// scrobble and the current one, see detectIncompleteScrobble
+func incompleteCompletion(previousScrobble *scrobble, currentScrobble *scrobble) float64 {
+	if currentScrobble.trackDuration <= 0 {
+		return 0
+	}
+	return min(float64(currentScrobble.timestamp.Sub(previousScrobble.timestamp))/float64(currentScrobble.trackDuration)*100, 100)
+}
+
+// pairCompletion returns the completion percentage of the play between a detected scrobble and the surviving one,
+// calculated like the detection that found it. The earliest scrobble of the pair is the previous one.
+func pairCompletion(c *Config, scrobbleToDelete *scrobble, survivingScrobble *scrobble, reason string) float64 {
+	previousScrobble, currentScrobble := scrobbleToDelete, survivingScrobble
+	if currentScrobble.timestamp.Before(previousScrobble.timestamp) {
+		previousScrobble, currentScrobble = currentScrobble, previousScrobble
+	}
+	if reason == "incomplete" {
+		return incompleteCompletion(previousScrobble, currentScrobble)
+	}
+	return duplicateCompletion(c, previousScrobble, currentScrobble)
+}
+
+// detectDuplicateScrobble checks if two successive scrobbles of the same track are too close to be distinct plays.
+// When the previous scrobble is itself a duplicate, the gap is measured from the first scrobble of the burst, so that
+// scrobbles closer to each other than a play but spanning several plays are not collapsed into a single one.
+// Scrobbles with identical timestamps are excluded unless IncludeEqualTimestamps is set, they are then always duplicates.
+// Scrobbles more than MaxDuplicateGap apart are never duplicates, whatever their completion percentage.
+// With MinReplayGap, the gap alone decides: closer scrobbles are duplicates, the others are distinct plays.
 func detectDuplicateScrobble(c *Config, previousScrobble *scrobble, currentScrobble *scrobble) (bool, error) {
-	if currentScrobble.artist == previousScrobble.artist && currentScrobble.track == previousScrobble.track && currentScrobble.timestamp != previousScrobble.timestamp {
-		currentScrobbleDuration := currentScrobble.timestamp.Sub(previousScrobble.timestamp)
-		currentScrobbleCompletionPercentage := min((float64(currentScrobbleDuration)/float64(currentScrobble.trackDuration))*100, 100)
-		duplicateDurationThreshold := time.Duration(float64(currentScrobble.trackDuration) * float64(c.DuplicateThreshold) / 100.0)
-		isDuplicate := currentScrobbleCompletionPercentage < float64(c.DuplicateThreshold)
+	if currentScrobble.artist != previousScrobble.artist || currentScrobble.track != previousScrobble.track {
+		return false, nil
+	}
 
-		slog.Debug("duplicate scrobble detection calculations", "previousScrobbleTimestamp", previousScrobble.timestamp, "currentScrobbleTimestamp", currentScrobble.timestamp, "currentScrobbleDuration", currentScrobbleDuration, "duplicateThreshold", c.DuplicateThreshold, "duplicateDurationThreshold", duplicateDurationThreshold, "currentScrobbleCompletionPercentage", currentScrobbleCompletionPercentage, "isDuplicate", isDuplicate)
-		if isDuplicate {
-			slog.Info("🎯 Duplicate scrobble detected!", "artist", currentScrobble.artist, "track", currentScrobble.track, "duration", currentScrobble.trackDuration, "timeBetweenScrobbles", duplicateDurationThreshold, "scrobbleToDeleteTimestamp", previousScrobble.timestamp.Format(time.RFC822))
+	if currentScrobble.timestamp.Equal(previousScrobble.timestamp) {
+		if c.IncludeEqualTimestamps {
+			slog.Info("🎯 Exact duplicate scrobble detected!", "artist", currentScrobble.artist, "track", currentScrobble.track, "scrobbleToDeleteTimestamp", previousScrobble.timestamp.Format(time.RFC822))
 			return true, nil
 		}
+		slog.Debug("Ignoring scrobbles with identical timestamps", "artist", currentScrobble.artist, "track", currentScrobble.track, "timestamp", currentScrobble.timestamp)
+		return false, nil
+	}
+
+	currentScrobbleDuration := currentScrobble.timestamp.Sub(previousScrobble.playStart())
+	if c.MaxDuplicateGap > 0 && currentScrobbleDuration > c.MaxDuplicateGap {
+		slog.Debug("Scrobbles too far apart to be duplicates", "artist", currentScrobble.artist, "track", currentScrobble.track, "timeBetweenScrobbles", currentScrobbleDuration, "maxDuplicateGap", c.MaxDuplicateGap)
+		return false, nil
+	}
+
+	if c.MinReplayGap > 0 {
+		isDuplicate := currentScrobbleDuration < c.MinReplayGap
+		slog.Debug("duplicate scrobble detection by replay gap", "artist", currentScrobble.artist, "track", currentScrobble.track, "timeBetweenScrobbles", currentScrobbleDuration, "minReplayGap", c.MinReplayGap, "isDuplicate", isDuplicate)
+		if isDuplicate {
+			slog.Info("🎯 Duplicate scrobble detected!", "artist", currentScrobble.artist, "track", currentScrobble.track, "timeBetweenScrobbles", currentScrobbleDuration, "minReplayGap", c.MinReplayGap, "scrobbleToDeleteTimestamp", previousScrobble.timestamp.Format(time.RFC822))
+		}
+		return isDuplicate, nil
+	}
+
+	fullPlay := fullPlayDuration(c, currentScrobble.trackDuration)
+	currentScrobbleCompletionPercentage := duplicateCompletion(c, previousScrobble, currentScrobble)
+	duplicateDurationThreshold := time.Duration(float64(fullPlay) * float64(c.DuplicateThreshold) / 100.0)
+	isDuplicate := isBelowThreshold(currentScrobbleCompletionPercentage, c.DuplicateThreshold, c.ThresholdEpsilon)
+
+	slog.Debug("duplicate scrobble detection calculations", "previousScrobbleTimestamp", previousScrobble.timestamp, "playStartTimestamp", previousScrobble.playStart(), "currentScrobbleTimestamp", currentScrobble.timestamp, "currentScrobbleDuration", currentScrobbleDuration, "fullPlayDuration", fullPlay, "duplicateThreshold", c.DuplicateThreshold, "duplicateDurationThreshold", duplicateDurationThreshold, "currentScrobbleCompletionPercentage", currentScrobbleCompletionPercentage, "isDuplicate", isDuplicate)
+	if isDuplicate {
+		slog.Info("🎯 Duplicate scrobble detected!", "artist", currentScrobble.artist, "track", currentScrobble.track, "duration", currentScrobble.trackDuration, "timeBetweenScrobbles", duplicateDurationThreshold, "scrobbleToDeleteTimestamp", previousScrobble.timestamp.Format(time.RFC822))
+		return true, nil
 	}
 	return false, nil
 }
 
 func detectIncompleteScrobble(c *Config, previousScrobble *scrobble, currentScrobble *scrobble) (bool, error) {
 	currentScrobbleDuration := currentScrobble.timestamp.Sub(previousScrobble.timestamp)
-	currentScrobbleCompletionPercentage := min((float64(currentScrobbleDuration)/float64(currentScrobble.trackDuration))*100, 100)
+	currentScrobbleCompletionPercentage := incompleteCompletion(previousScrobble, currentScrobble)
 	completeDurationThreshold := time.Duration(float64(currentScrobble.trackDuration) * float64(c.CompleteThreshold) / 100.0)
-	isIncomplete := currentScrobbleCompletionPercentage < float64(c.CompleteThreshold)
+	isIncomplete := isBelowThreshold(currentScrobbleCompletionPercentage, c.CompleteThreshold, c.ThresholdEpsilon)
 
 	slog.Debug("incomplete scrobble detection calculations", "previousScrobbleTimestamp", previousScrobble.timestamp, "currentTrackDuration", currentScrobble.trackDuration, "currentScrobbleTimestamp", currentScrobble.timestamp, "currentScrobbleDuration", currentScrobbleDuration, "completeThreshold", c.CompleteThreshold, "completeDurationThreshold", completeDurationThreshold, "currentScrobbleCompletionPercentage", currentScrobbleCompletionPercentage, "isIncomplete", isIncomplete)
 	if isIncomplete {
@@ -617,25 +1209,34 @@ func detectIncompleteScrobble(c *Config, previousScrobble *scrobble, currentScro
 	return false, nil
 }
 
-func deleteScrobble(c *Config, timestamp string, deleteCurrentScrobble bool) error {
-	timeoutCtx, cancel := context.WithTimeout(c.taskCtx, 3*time.Second)
+func deleteScrobble(c *Config, s *scrobble, deleteCurrentScrobble bool) error {
+	// The scrobble may be on the previous page when its pair spans a page boundary
+	if s.page != 0 && s.page != c.loadedPage {
+		slog.Debug("Scrobble to delete is not on the loaded page, navigating to its page", "page", s.page, "loadedPage", c.loadedPage)
+		navigateCtx, cancel := context.WithTimeout(c.taskCtx, browserOperationsTimeout)
+		defer cancel()
+		if err := loadLibraryPage(navigateCtx, c, s.page); err != nil {
+			return fmt.Errorf("failed to navigate to the page of the scrobble to delete: %w", err)
+		}
+	}
+
+	// Leave time for the slow motion pauses before each click
+	timeoutCtx, cancel := context.WithTimeout(c.taskCtx, 3*time.Second+4*c.SlowMo)
 	defer cancel()
 
-	// Sometimes two scrobbles have an identical timestamp
-	// Depending on if we want to delete the previous or the current scrobble, we modify the xpath expression
-	xpathPrefix := `(//input[@value='` + timestamp + `'])`
-	if deleteCurrentScrobble {
-		xpathPrefix += `[last()]`
-	}
+	xpathPrefix := deleteScrobbleXPath(s, deleteCurrentScrobble)
 
-	slog.Debug("Attempting to delete scrobble", "timestamp", timestamp, "xpath", xpathPrefix)
+	slog.Debug("Attempting to delete scrobble", "timestamp", s.timestampString, "xpath", xpathPrefix)
 	err := chromedp.Run(timeoutCtx,
 		// Click away to close any previous popup
 		chromedp.MouseClickXY(0, 0),
+		slowMo(c),
 		chromedp.Click(xpathPrefix+`/../../../../button`, chromedp.BySearch),
 		chromedp.WaitVisible(`//tr[contains(@class,'show-focus-controls')]`, chromedp.BySearch),
+		slowMo(c),
 		chromedp.Click(xpathPrefix+`/../../../../button`, chromedp.BySearch),
 		chromedp.WaitVisible(xpathPrefix+`/../button`, chromedp.BySearch),
+		slowMo(c),
 		chromedp.Click(xpathPrefix+`/../button`, chromedp.BySearch),
 	)
 	if err != nil {
@@ -645,14 +1246,37 @@ func deleteScrobble(c *Config, timestamp string, deleteCurrentScrobble bool) err
 	return nil
 }
 
-func deleteScrobbleWithRetries(ctx context.Context, c *Config, timestamp string, deleteCurrentScrobble bool, retryCount uint) error {
+// deleteScrobbleXPath returns the XPath of the timestamp input from which the delete buttons of a scrobble are found
+func deleteScrobbleXPath(s *scrobble, deleteCurrentScrobble bool) string {
+	// Sometimes two scrobbles have an identical timestamp
+	// Depending on if we want to delete the previous or the current scrobble, we modify the xpath expression
+	xpath := `(//input[@value='` + s.timestampString + `'])`
+	if deleteCurrentScrobble {
+		xpath += `[last()]`
+	}
+	return xpath
+}
+
+var ErrTooManyDeleteFailures = errors.New("too many consecutive scrobble deletion failures, the Last.fm delete buttons selector likely broke")
+
+func deleteScrobbleWithRetries(ctx context.Context, c *Config, s *scrobble, deleteCurrentScrobble bool, retryCount uint) error {
 	_, err := backoff.Retry(ctx, func() (struct{}, error) {
-		return struct{}{}, deleteScrobble(c, timestamp, deleteCurrentScrobble)
+		return struct{}{}, deleteScrobble(c, s, deleteCurrentScrobble)
 	}, backoff.WithMaxTries(retryCount))
 	if err != nil {
-		c.runStats.scrobbleDeleteFails++
+		c.runStats.scrobbleDeleteFails.Add(1)
+		c.consecutiveDeleteFailures++
 		return err
 	}
+	c.consecutiveDeleteFailures = 0
+	return nil
+}
+
+// checkDeleteFailures stops the run once deletions keep failing instead of failing for every remaining scrobble
+func checkDeleteFailures(c *Config) error {
+	if c.MaxDeleteFailures > 0 && c.consecutiveDeleteFailures >= c.MaxDeleteFailures {
+		return fmt.Errorf("%w (%d failures)", ErrTooManyDeleteFailures, c.consecutiveDeleteFailures)
+	}
 	return nil
 }
 
@@ -662,7 +1286,7 @@ func logStats(ctx context.Context, c *Config) error {
 	telegramMessage := fmt.Sprintf("Run of %s\n", c.startTime.Format(time.RFC1123))
 
 	var deletedScrobblesStat string
-	if c.CanDelete {
+	if c.canDelete {
 		deletedScrobblesStat = fmt.Sprintf("Duplicated scrobbles deleted: %d", len(c.deletedScrobbles))
 	} else {
 		deletedScrobblesStat = fmt.Sprintf("Duplicated scrobbles not deleted: %d", len(c.deletedScrobbles))
@@ -671,21 +1295,94 @@ func logStats(ctx context.Context, c *Config) error {
 	messages := []string{
 		"Run statistics:",
 		deletedScrobblesStat,
-		fmt.Sprintf("MusicBrainz API cache hits: %d", c.runStats.cacheHits),
-		fmt.Sprintf("MusicBrainz API cache misses: %d", c.runStats.cacheMisses),
-		fmt.Sprintf("Scrobbles processed: %d", c.runStats.processedScrobbles),
-		fmt.Sprintf("Unknown duration track count: %d", c.runStats.unknownTrackDurationsCount),
-		fmt.Sprintf("Scrobbles skipped due to unknown track duration: %d", c.runStats.skippedScrobbleUnknownDuration),
-		fmt.Sprintf("Scrobbles not deleted due to error: %d", c.runStats.scrobbleDeleteFails),
+		fmt.Sprintf("MusicBrainz API cache hits: %d", c.runStats.cacheHits.Load()),
+		fmt.Sprintf("MusicBrainz API cache misses: %d", c.runStats.cacheMisses.Load()),
+		fmt.Sprintf("MusicBrainz API cache hit rate: %.1f%%", c.runStats.cacheHitRate()),
+		fmt.Sprintf("Scrobbles processed: %d", c.runStats.processedScrobbles.Load()),
+		fmt.Sprintf("Unknown duration track count: %d", c.runStats.unknownTrackDurationsCount.Load()),
+		fmt.Sprintf("Scrobbles skipped due to unknown track duration: %d", c.runStats.skippedScrobbles()),
+		fmt.Sprintf("Scrobbles skipped as no track duration was found: %d", c.runStats.skippedDurationNotFound.Load()),
+		fmt.Sprintf("Scrobbles skipped as their track duration was already unknown: %d", c.runStats.skippedKnownUnknownDuration.Load()),
+		fmt.Sprintf("Scrobbles skipped due to a track duration lookup error: %d", c.runStats.skippedDurationLookupError.Load()),
+		fmt.Sprintf("Scrobbles not deleted due to error: %d", c.runStats.scrobbleDeleteFails.Load()),
 		fmt.Sprintf("Elapsed time: %s", c.runStats.elapsedTime.Truncate(time.Millisecond/10)),
 	}
 
+	// Without a lookup during the run, there is no latency to estimate the saved time from
+	if c.runStats.durationLookups.Load() > 0 {
+		messages = append(messages, fmt.Sprintf("Estimated lookup time saved by the cache: %s", c.runStats.cacheTimeSaved().Truncate(time.Second)))
+	}
+
+	if corrupted := c.runStats.corruptedCacheEntries.Load(); corrupted > 0 {
+		messages = append(messages, fmt.Sprintf("Corrupted cache entries purged and looked up again: %d", corrupted))
+	}
+
+	if futureScrobbles := c.runStats.futureScrobbles.Load(); futureScrobbles > 0 {
+		messages = append(messages, fmt.Sprintf("Scrobbles ignored due to a timestamp in the future: %d", futureScrobbles))
+	}
+
+	if c.SkipOnlyWhenSurrounded {
+		messages = append(messages, fmt.Sprintf("Incomplete scrobbles kept as not surrounded by complete plays: %d", c.runStats.notSurroundedSkips.Load()))
+	}
+
+	if c.KeepOnePerTrack {
+		messages = append(messages, fmt.Sprintf("Scrobbles kept as the last one of their track: %d", c.runStats.lastOfTrackKept.Load()))
+	}
+
+	if c.SkipUncomparedDurations {
+		messages = append(messages, fmt.Sprintf("Scrobbles without duration lookup as not following the same track: %d", c.runStats.uncomparedScrobbles.Load()))
+	}
+
+	if c.PerScrobbleTimeout > 0 {
+		messages = append(messages, fmt.Sprintf("Scrobbles skipped as their track duration lookup timed out: %d", c.runStats.lookupTimeouts.Load()))
+	}
+
+	if c.ImportDeletions != "" {
+		messages = append(messages, fmt.Sprintf("Imported scrobbles not found in the library: %d", c.runStats.importedDeletionsNotFound.Load()))
+	}
+
+	if c.Review {
+		messages = append(messages, fmt.Sprintf("Scrobbles kept after review: %d", c.runStats.reviewKeptScrobbles.Load()))
+	}
+
+	if c.DeleteSample > 0 {
+		messages = append(messages,
+			fmt.Sprintf("Scrobbles deleted as part of the delete sample: %d", c.runStats.sampleDeletions.Load()),
+			fmt.Sprintf("Scrobbles recorded in dry-run after the delete sample: %d", c.runStats.sampleDryRunScrobbles.Load()),
+		)
+	}
+
+	if c.webhook != nil {
+		messages = append(messages, fmt.Sprintf("Deletion webhook events dropped: %d", c.webhook.dropped))
+	}
+
+	if len(c.failedPages) > 0 {
+		messages = append(messages, fmt.Sprintf("Pages skipped due to error, rerun with start-page to process them: %v", c.failedPages))
+	}
+
 	for _, m := range messages {
 		slog.Info(m)
 		telegramMessage = strings.Join([]string{telegramMessage, m}, "\n")
 	}
 
+	alerts := getRunAlerts(c)
+	if len(alerts) > 0 {
+		for _, a := range alerts {
+			slog.Warn("🚨 " + a)
+		}
+		telegramMessage = strings.Join(append([]string{"🚨 ALERT: anomalous run"}, append(alerts, "", telegramMessage)...), "\n")
+	}
+
 	if c.telegramBot != nil {
+		if c.DigestPeriod > 0 && !c.dataDirReadOnly {
+			if len(alerts) == 0 {
+				return digestRun(ctx, c)
+			}
+			// Anomalous runs are notified right away instead of waiting for the digest, which still counts them
+			if err := digestAlertRun(c); err != nil {
+				slog.Warn("Could not add run to digest", "error", err)
+			}
+		}
 		if err := sendTelegramMessage(ctx, c, telegramMessage); err != nil {
 			return fmt.Errorf("failed to send telegram message: %w", err)
 		}
@@ -694,7 +1391,13 @@ func logStats(ctx context.Context, c *Config) error {
 	return nil
 }
 
-func writeUnknownTrackDurations(unknownTrackDurations durationByTrackByArtist, dataDir string) error {
+func printCounts(c *Config) {
+	fmt.Printf("Duplicated scrobbles: %d\n", len(c.deletedScrobbles))
+	fmt.Printf("Scrobbles processed: %d\n", c.runStats.processedScrobbles.Load())
+	fmt.Printf("Scrobbles skipped due to unknown track duration: %d\n", c.runStats.skippedScrobbles())
+}
+
+func writeUnknownTrackDurations(unknownTrackDurations durationByTrackByArtist, dataDir string, readOnly bool) error {
 	userTrackDurations, err := getUserTrackDurations(dataDir)
 	if err != nil {
 		return err
@@ -714,9 +1417,12 @@ func writeUnknownTrackDurations(unknownTrackDurations durationByTrackByArtist, d
 
 	bytes = append([]byte("# This file lists tracks that the program could not find a duration for using the MusicBrainz API\n# If a track has an unknown duration, this program will never delete its duplicate scrobbles\n# Specify the duration of each track using the Go time ParseDuration format (ex: 5m06s), then rerun the program\n# You may use it to override a track length, but you must strictly match the scrobble's artist and track name\n\n"), bytes...)
 
-	file, err := os.OpenFile(path.Join(dataDir, customTrackDurationsFile), os.O_RDWR|os.O_CREATE, 0666)
-	if err != nil {
-		if errors.Is(err, os.ErrPermission) {
+	var file *os.File
+	if !readOnly {
+		file, err = os.OpenFile(path.Join(dataDir, customTrackDurationsFile), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	}
+	if readOnly || err != nil {
+		if readOnly || isReadOnlyError(err) {
 			slog.Warn(fmt.Sprintf("Failed to save unknown track durations in %s", customTrackDurationsFile), "error", err)
 			slog.Info(fmt.Sprintf(`Save the following YAML in a file named "%s" in this program's directory and follow the instructions`, customTrackDurationsFile))
 			fmt.Println("\n" + string(bytes))
@@ -724,6 +1430,7 @@ func writeUnknownTrackDurations(unknownTrackDurations durationByTrackByArtist, d
 		}
 		return fmt.Errorf("failed to open unknown track durations file: %w", err)
 	}
+	defer helpers.CloseFile(file)
 
 	_, err = file.Write(bytes)
 	if err != nil {
@@ -733,92 +1440,95 @@ func writeUnknownTrackDurations(unknownTrackDurations durationByTrackByArtist, d
 	return nil
 }
 
-func exportScrobblesToCSV(c *Config, baseFilename string) {
-	timestamp := c.startTime.Format("20060102-150405")
-	filename := fmt.Sprintf("%s-%s.csv", baseFilename, timestamp)
-
-	slices.SortFunc(c.deletedScrobbles, func(s1, s2 *scrobble) int {
-		return s1.timestamp.Compare(s2.timestamp)
-	})
-
-	file, err := os.Create(path.Join(c.DataDir, filename))
-	if err != nil {
-		slog.Warn("⚠️ Could not create deleted scrobble file, falling back to logging scrobbles as CSV", "file", filename, "error", err)
-		logScrobblesCSV(c.deletedScrobbles)
-		return
+func finishRun(ctx context.Context, c *Config) error {
+	defer c.close()
+	if c.webhook != nil {
+		c.webhook.close()
 	}
-	defer helpers.CloseFile(file)
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
 
-	// header
-	_ = writer.Write([]string{"Artist", "Track", "Timestamp", "TimestampString"})
-
-	for _, s := range c.deletedScrobbles {
-		record := []string{
-			s.artist,
-			s.track,
-			s.timestamp.Format(time.RFC3339),
-			s.timestampString,
+	if cachingPages(c) {
+		if err := finishPageRows(c); err != nil {
+			slog.Warn("Failed to save cached pages", "error", err)
 		}
-		_ = writer.Write(record)
 	}
 
-	if c.CanDelete {
-		slog.Info("Deleted scrobbles saved to file", "file", file.Name())
-	} else {
-		slog.Info("Would-be deleted scrobbles saved to file", "file", file.Name())
+	if fileCache, ok := c.cache.(*cache.File); ok {
+		if err := fileCache.LastFlushError(); err != nil {
+			slog.Warn("⚠️ The file cache could not be saved during the run, track durations may have to be looked up again", "error", err)
+		}
 	}
-}
-
-func logScrobblesCSV(scrobbles []*scrobble) {
-	var sb strings.Builder
 
-	// header
-	sb.WriteString("Artist,Track,Timestamp,TimestampString\n")
+	if c.CountOnly {
+		printCounts(c)
+		return nil
+	}
 
-	for _, s := range scrobbles {
-		sb.WriteString(fmt.Sprintf("%s,%s,%s,%s\n",
-			s.artist,
-			s.track,
-			s.timestamp.Format(time.RFC3339),
-			s.timestampString,
-		))
+	if c.scanOnly {
+		printScanReport(c.scanOutput, c.scan)
+		return nil
 	}
 
-	fmt.Printf("Scrobbles CSV:\n%s", sb.String())
-}
+	if c.warmCacheOnly {
+		printWarmCacheReport(c.warmCacheOutput, c)
+		if len(c.unknownTrackDurations) > 0 {
+			return writeUnknownTrackDurations(c.unknownTrackDurations, c.DataDir, c.dataDirReadOnly)
+		}
+		return nil
+	}
 
-func finishRun(ctx context.Context, c *Config) error {
-	defer c.close()
 	if err := logStats(ctx, c); err != nil {
 		return fmt.Errorf("failed to log stats: %w", err)
 	}
 
 	if len(c.unknownTrackDurations) > 0 {
-		err := writeUnknownTrackDurations(c.unknownTrackDurations, c.DataDir)
+		err := writeUnknownTrackDurations(c.unknownTrackDurations, c.DataDir, c.dataDirReadOnly)
 		if err != nil {
 			return err
 		}
 	}
 
 	if len(c.deletedScrobbles) > 0 {
-		exportScrobblesToCSV(c, "deleted-scrobbles")
+		if c.ExportFormat != ExportFormatJSON {
+			exportScrobblesToCSV(c, "deleted-scrobbles")
+		}
+		if c.ExportFormat != ExportFormatCSV {
+			exportScrobblesToJSON(c, "deleted-scrobbles")
+		}
 	}
 
-	return nil
-}
+	if c.WebhookURL != "" {
+		if err := postRunWebhook(ctx, c); err != nil {
+			slog.Warn("Failed to post run webhook", "error", err)
+		} else {
+			slog.Info("Posted run webhook")
+		}
+	}
 
-func sendTelegramMessage(ctx context.Context, c *Config, message string) error {
-	params := &bot.SendMessageParams{
-		ParseMode: models.ParseModeMarkdown,
-		ChatID:    c.TelegramChatID,
-		Text:      bot.EscapeMarkdown(message),
+	if c.OnlyNewSinceLastRun && !c.lastProcessedTimestamp.IsZero() {
+		if len(c.failedPages) > 0 {
+			// Newer pages were processed, saving their timestamp would skip the failed pages in the next run
+			slog.Warn("Pages failed, not saving last processed scrobble timestamp", "failedPages", c.failedPages)
+		} else if c.dataDirReadOnly {
+			slog.Warn("Data directory is read-only, could not save last processed scrobble timestamp", "timestamp", c.lastProcessedTimestamp.Unix())
+		} else if err := writeLastProcessedTimestamp(c.DataDir, c.lastProcessedTimestamp); err != nil {
+			return err
+		}
 	}
-	_, err := c.telegramBot.SendMessage(ctx, params)
-	if err != nil {
-		return err
+
+	if c.runCompleted && checkpointEnabled(c) {
+		if len(c.failedPages) > 0 {
+			// The checkpoint stays before the first failed page for a resumed run to process it again
+			slog.Warn("Pages failed, keeping checkpoint", "failedPages", c.failedPages)
+		} else if err := clearCheckpoint(c.DataDir); err != nil {
+			return err
+		}
 	}
+
+	if c.ResultsDB != "" {
+		if err := saveRunResults(c); err != nil {
+			return fmt.Errorf("failed to save run results: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,379 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cterence/scrobble-deduplicator/internal/cache"
+	"github.com/michiwend/gomusicbrainz"
+)
+
+func testDetectionConfig() *Config {
+	return &Config{
+		DuplicateThreshold: 90,
+		FullPlayAt:         50,
+		CompleteThreshold:  50,
+		ThresholdEpsilon:   0.001,
+	}
+}
+
+func testScrobblePair(gap, trackDuration time.Duration) (*scrobble, *scrobble) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	previous := &scrobble{artist: "Artist", track: "Song", timestamp: start, trackDuration: trackDuration}
+	current := &scrobble{artist: "Artist", track: "Song", timestamp: start.Add(gap), trackDuration: trackDuration}
+	return previous, current
+}
+
+func TestIsBelowThreshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		completion float64
+		threshold  int
+		epsilon    float64
+		expected   bool
+	}{
+		{name: "below", completion: 50, threshold: 90, epsilon: 0.001, expected: true},
+		{name: "equal is not below", completion: 90, threshold: 90, epsilon: 0.001, expected: false},
+		{name: "within epsilon is not below", completion: 89.9995, threshold: 90, epsilon: 0.001, expected: false},
+		{name: "beyond epsilon", completion: 89.998, threshold: 90, epsilon: 0.001, expected: true},
+		{name: "above", completion: 100, threshold: 90, epsilon: 0.001, expected: false},
+		{name: "zero threshold", completion: 0, threshold: 0, epsilon: 0.001, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isBelowThreshold(tt.completion, tt.threshold, tt.epsilon); got != tt.expected {
+				t.Errorf("isBelowThreshold(%v, %d, %v) = %v, expected %v", tt.completion, tt.threshold, tt.epsilon, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetectDuplicateScrobble(t *testing.T) {
+	tests := []struct {
+		name          string
+		configure     func(c *Config)
+		gap           time.Duration
+		trackDuration time.Duration
+		// Start of the burst of duplicates the previous scrobble belongs to, before it
+		burstStartBefore time.Duration
+		otherTrack       bool
+		expected         bool
+	}{
+		{name: "half of the full play", gap: time.Minute, trackDuration: 4 * time.Minute, expected: true},
+		{name: "full play", gap: 2 * time.Minute, trackDuration: 4 * time.Minute, expected: false},
+		{name: "just below the threshold", gap: 107 * time.Second, trackDuration: 4 * time.Minute, expected: true},
+		{name: "at the threshold", gap: 108 * time.Second, trackDuration: 4 * time.Minute, expected: false},
+		{name: "other track", gap: time.Second, trackDuration: 4 * time.Minute, otherTrack: true, expected: false},
+		{
+			name:          "whole track as full play",
+			configure:     func(c *Config) { c.FullPlayAt = 100 },
+			gap:           3 * time.Minute,
+			trackDuration: 4 * time.Minute,
+			expected:      true,
+		},
+		// Half of a 10 minutes track is 5 minutes, Last.fm records the scrobble after 4 minutes
+		{name: "full play capped at 4 minutes", gap: 4*time.Minute + 15*time.Second, trackDuration: 10 * time.Minute, expected: false},
+		{name: "below the capped full play", gap: 3 * time.Minute, trackDuration: 10 * time.Minute, expected: true},
+		{name: "identical timestamps excluded", gap: 0, trackDuration: 4 * time.Minute, expected: false},
+		{
+			name:          "identical timestamps included",
+			configure:     func(c *Config) { c.IncludeEqualTimestamps = true },
+			gap:           0,
+			trackDuration: 4 * time.Minute,
+			expected:      true,
+		},
+		{
+			name:          "beyond the max duplicate gap",
+			configure:     func(c *Config) { c.MaxDuplicateGap = 30 * time.Second },
+			gap:           time.Minute,
+			trackDuration: 4 * time.Minute,
+			expected:      false,
+		},
+		{
+			name:          "below the min replay gap",
+			configure:     func(c *Config) { c.MinReplayGap = 10 * time.Second },
+			gap:           5 * time.Second,
+			trackDuration: 4 * time.Minute,
+			expected:      true,
+		},
+		{
+			name:          "beyond the min replay gap",
+			configure:     func(c *Config) { c.MinReplayGap = 10 * time.Second },
+			gap:           20 * time.Second,
+			trackDuration: 4 * time.Minute,
+			expected:      false,
+		},
+		{
+			name:             "gap measured from the burst start",
+			gap:              time.Minute,
+			trackDuration:    4 * time.Minute,
+			burstStartBefore: 90 * time.Second,
+			expected:         false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testDetectionConfig()
+			if tt.configure != nil {
+				tt.configure(c)
+			}
+			previous, current := testScrobblePair(tt.gap, tt.trackDuration)
+			if tt.otherTrack {
+				current.track = "Other"
+			}
+			if tt.burstStartBefore > 0 {
+				previous.burstStart = previous.timestamp.Add(-tt.burstStartBefore)
+			}
+
+			got, err := detectDuplicateScrobble(c, previous, current)
+			if err != nil {
+				t.Fatalf("detectDuplicateScrobble() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("detectDuplicateScrobble() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDetectIncompleteScrobble(t *testing.T) {
+	tests := []struct {
+		name     string
+		gap      time.Duration
+		expected bool
+	}{
+		{name: "quarter of the track", gap: time.Minute, expected: true},
+		{name: "half of the track", gap: 2 * time.Minute, expected: false},
+		{name: "whole track", gap: 4 * time.Minute, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, current := testScrobblePair(tt.gap, 4*time.Minute)
+			got, err := detectIncompleteScrobble(testDetectionConfig(), previous, current)
+			if err != nil {
+				t.Fatalf("detectIncompleteScrobble() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("detectIncompleteScrobble() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPairCompletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *Config)
+		gap       time.Duration
+		// Whether the deleted scrobble is the later one of the pair
+		deleteCurrent bool
+		reason        string
+		expected      float64
+	}{
+		// Duplicates are measured against the full play, half of the track
+		{name: "duplicate", gap: time.Minute, reason: "duplicate", expected: 50},
+		{name: "duplicate beyond the full play", gap: 3 * time.Minute, reason: "duplicate", expected: 100},
+		{name: "duplicate with min replay gap", configure: func(c *Config) { c.MinReplayGap = 4 * time.Minute }, gap: time.Minute, reason: "duplicate", expected: 25},
+		{name: "later duplicate deleted", gap: time.Minute, deleteCurrent: true, reason: "duplicate", expected: 50},
+		// Incomplete scrobbles are measured against the whole track
+		{name: "incomplete", gap: time.Minute, deleteCurrent: true, reason: "incomplete", expected: 25},
+		{name: "incomplete previous deleted", gap: time.Minute, reason: "incomplete", expected: 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testDetectionConfig()
+			if tt.configure != nil {
+				tt.configure(c)
+			}
+			previous, current := testScrobblePair(tt.gap, 4*time.Minute)
+			scrobbleToDelete, survivingScrobble := previous, current
+			if tt.deleteCurrent {
+				scrobbleToDelete, survivingScrobble = current, previous
+			}
+
+			if got := pairCompletion(c, scrobbleToDelete, survivingScrobble, tt.reason); got != tt.expected {
+				t.Errorf("pairCompletion() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCountDeletion(t *testing.T) {
+	tests := []struct {
+		name                    string
+		deleteSample            int
+		deletions               int
+		expectedSampleDeletions int64
+		expectedWarnings        int
+	}{
+		{name: "no sample", deletions: 3, expectedSampleDeletions: 0, expectedWarnings: 0},
+		{name: "below the sample", deleteSample: 5, deletions: 3, expectedSampleDeletions: 3, expectedWarnings: 0},
+		{name: "sample reached", deleteSample: 3, deletions: 3, expectedSampleDeletions: 3, expectedWarnings: 1},
+		{name: "deletions beyond the sample warn once", deleteSample: 2, deletions: 5, expectedSampleDeletions: 5, expectedWarnings: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			c := &Config{DeleteSample: tt.deleteSample}
+
+			var wg sync.WaitGroup
+			for range tt.deletions {
+				wg.Go(func() { countDeletion(c) })
+			}
+			wg.Wait()
+
+			if got := c.runStats.deletions.Load(); got != int64(tt.deletions) {
+				t.Errorf("deletions = %d, expected %d", got, tt.deletions)
+			}
+			if got := c.runStats.sampleDeletions.Load(); got != tt.expectedSampleDeletions {
+				t.Errorf("sampleDeletions = %d, expected %d", got, tt.expectedSampleDeletions)
+			}
+			if got := strings.Count(logs.String(), "Delete sample reached"); got != tt.expectedWarnings {
+				t.Errorf("sample warnings = %d, expected %d", got, tt.expectedWarnings)
+			}
+		})
+	}
+}
+
+func TestDeleteImportedScrobbleAfterSample(t *testing.T) {
+	c := &Config{DeleteSample: 1, canDelete: true}
+	c.runStats.sampleDeletions.Store(1)
+	previous, _ := testScrobblePair(0, 4*time.Minute)
+
+	// The sample is reached, the scrobble is recorded without reaching the browser
+	deleteImportedScrobble(context.Background(), c, &importedDeletion{scrobble: previous}, 1)
+
+	if got := c.runStats.sampleDryRunScrobbles.Load(); got != 1 {
+		t.Errorf("sampleDryRunScrobbles = %d, expected 1", got)
+	}
+	if got := c.runStats.deletions.Load(); got != 0 {
+		t.Errorf("deletions = %d, expected 0", got)
+	}
+	if len(c.deletedScrobbles) != 1 {
+		t.Errorf("recorded %d scrobbles, expected 1", len(c.deletedScrobbles))
+	}
+}
+
+// captureLogs sends the logs of a test to a buffer
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&syncWriter{w: &logs}, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	return &logs
+}
+
+// syncWriter serializes the writes of concurrent loggers
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestParseUserTrackDurations(t *testing.T) {
+	tests := []struct {
+		name             string
+		yaml             string
+		expected         durationByTrackByArtist
+		expectedErr      bool
+		expectedWarnings int
+	}{
+		{name: "empty", yaml: "", expected: durationByTrackByArtist{}},
+		{name: "durations under the artist", yaml: "Artist:\n  Song: \"4:05\"\n  Other: \"245\"\n", expected: durationByTrackByArtist{"Artist": {"Song": "4:05", "Other": "245"}}},
+		{name: "artist without tracks", yaml: "Artist:\n", expected: durationByTrackByArtist{"Artist": nil}},
+		{
+			name:             "artist and track in a single key are skipped",
+			yaml:             "Artist - Song: \"4:05\"\nOther:\n  Song: \"3:00\"\n",
+			expected:         durationByTrackByArtist{"Other": {"Song": "3:00"}},
+			expectedWarnings: 1,
+		},
+		{name: "scalar without a separator", yaml: "Artist: \"4:05\"\n", expectedErr: true},
+		{name: "invalid yaml", yaml: "Artist: [\n", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			got, err := parseUserTrackDurations([]byte(tt.yaml))
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("parseUserTrackDurations() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if !tt.expectedErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseUserTrackDurations() = %v, expected %v", got, tt.expected)
+			}
+			if got := strings.Count(logs.String(), "set it under the artist instead"); got != tt.expectedWarnings {
+				t.Errorf("warnings = %d, expected %d", got, tt.expectedWarnings)
+			}
+		})
+	}
+}
+
+func TestGetTrackDurationCachedEntry(t *testing.T) {
+	tests := []struct {
+		name              string
+		cached            func(t *testing.T) string
+		expected          time.Duration
+		expectedQueries   int
+		expectedCorrupted int64
+	}{
+		{
+			name: "valid entry",
+			cached: func(t *testing.T) string {
+				value, err := encodeTrackDurationCacheEntry("Artist", "Song", 4*time.Minute)
+				if err != nil {
+					t.Fatalf("encodeTrackDurationCacheEntry() error = %v", err)
+				}
+				return value
+			},
+			expected: 4 * time.Minute,
+		},
+		// The corrupted entry is purged and the duration looked up again
+		{name: "corrupted entry", cached: func(*testing.T) string { return "{not json" }, expected: 3 * time.Minute, expectedQueries: 1, expectedCorrupted: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mb := &fakeMusicBrainzClient{recordings: []*gomusicbrainz.Recording{testRecording("studio", "Song", 3*time.Minute)}}
+			c := &Config{cache: cache.NewInMemory(), mb: mb, DisableLastFMFallback: true}
+			key := trackDurationCacheKey("Artist", "Song")
+			if err := c.cache.Set(ctx, key, tt.cached(t)); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			s := &scrobble{artist: "Artist", track: "Song"}
+			if err := getTrackDuration(ctx, c, nil, s); err != nil {
+				t.Fatalf("getTrackDuration() error = %v", err)
+			}
+			if s.trackDuration != tt.expected {
+				t.Errorf("trackDuration = %s, expected %s", s.trackDuration, tt.expected)
+			}
+			if len(mb.queries) != tt.expectedQueries {
+				t.Errorf("MusicBrainz queries = %d, expected %d", len(mb.queries), tt.expectedQueries)
+			}
+			if got := c.runStats.corruptedCacheEntries.Load(); got != tt.expectedCorrupted {
+				t.Errorf("corruptedCacheEntries = %d, expected %d", got, tt.expectedCorrupted)
+			}
+
+			// The entry left in the cache is a valid one
+			value, err := c.cache.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if duration, err := parseCachedTrackDuration(value); err != nil || duration != tt.expected {
+				t.Errorf("cached duration = %s (%v), expected %s", duration, err, tt.expected)
+			}
+		})
+	}
+}
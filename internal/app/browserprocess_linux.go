@@ -0,0 +1,13 @@
+package app
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachBrowserProcess starts the local browser in its own process group, so that an interrupt in the terminal only
+// stops this program and the browser finishes loading the current page. Like chromedp does by default, the browser is
+// killed when this program dies.
+func detachBrowserProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
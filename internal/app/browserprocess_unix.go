@@ -0,0 +1,14 @@
+//go:build !windows && !linux
+
+package app
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachBrowserProcess starts the local browser in its own process group, so that an interrupt in the terminal only
+// stops this program and the browser finishes loading the current page
+func detachBrowserProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
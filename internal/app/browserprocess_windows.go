@@ -0,0 +1,12 @@
+package app
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachBrowserProcess starts the local browser in its own process group, so that a Ctrl+C in the console only
+// stops this program and the browser finishes loading the current page
+func detachBrowserProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
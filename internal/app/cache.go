@@ -0,0 +1,226 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cterence/scrobble-deduplicator/internal/cache"
+	"github.com/michiwend/gomusicbrainz"
+)
+
+// CompactCache rewrites the file cache with a line per key, the other cache types update their entries in place
+func CompactCache(c *Config, backup bool) error {
+	if c.CacheType != "file" {
+		slog.Info("Only the file cache needs compacting", "cacheType", c.CacheType)
+		return nil
+	}
+
+	cacheFile := path.Join(c.DataDir, cache.CacheFileName)
+	slog.Info("Compacting file cache", "file", cacheFile)
+
+	stats, err := cache.CompactFile(cacheFile, backup)
+	if err != nil {
+		return fmt.Errorf("failed to compact file cache: %w", err)
+	}
+
+	slog.Info("File cache compacted", "totalLines", stats.TotalLines, "uniqueKeys", stats.UniqueKeys, "duplicateKeys", stats.DuplicateKeys, "malformedLines", stats.MalformedLines)
+	return nil
+}
+
+// PurgeCacheEntry deletes the cached durations of a track so that the next run looks it up again, both the searched
+// one and the one of its pinned recording
+func PurgeCacheEntry(ctx context.Context, c *Config, artist, track string) error {
+	if c.CacheType == "inmemory" {
+		return errors.New("the inmemory cache is not persisted between runs, there is nothing to purge")
+	}
+
+	if err := ensureDataDir(c.DataDir); err != nil {
+		return err
+	}
+
+	var err error
+	c.artistAliases, err = getArtistAliases(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get artist aliases: %w", err)
+	}
+	mbidMap, err := getMBIDMap(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get MBID map: %w", err)
+	}
+	cacheKeys := []string{trackDurationCacheKey(musicBrainzArtist(c, artist), track)}
+	if mbid := mbidMap[artist][track]; mbid != "" {
+		cacheKeys = append(cacheKeys, pinnedTrackDurationCacheKey(gomusicbrainz.MBID(mbid)))
+	}
+
+	if err := initCache(ctx, c); err != nil {
+		return err
+	}
+	defer c.cache.Close()
+
+	var purged int
+	for _, cacheKey := range cacheKeys {
+		duration, err := c.cache.Get(ctx, cacheKey)
+		if err != nil {
+			if errors.Is(err, cache.ErrCacheMiss) {
+				continue
+			}
+			return fmt.Errorf("failed to get cached track duration: %w", err)
+		}
+
+		if err := c.cache.Delete(ctx, cacheKey); err != nil {
+			return fmt.Errorf("failed to delete cached track duration: %w", err)
+		}
+		slog.Info("Purged cached track duration", "artist", artist, "track", track, "duration", duration, "key", cacheKey)
+		purged++
+	}
+	if purged == 0 {
+		slog.Info("No cached duration for track", "artist", artist, "track", track)
+	}
+	return nil
+}
+
+// trackDurationCacheEntry is the cached value of a track duration, it names the track so that the cache can be exported.
+// Entries cached before only hold the duration, and entries cached before refresh-durations have no cache time.
+type trackDurationCacheEntry struct {
+	Duration string    `json:"duration"`
+	Artist   string    `json:"artist"`
+	Track    string    `json:"track"`
+	CachedAt time.Time `json:"cachedAt,omitzero"`
+}
+
+func encodeTrackDurationCacheEntry(artist, track string, duration time.Duration) (string, error) {
+	b, err := json.Marshal(trackDurationCacheEntry{Duration: duration.String(), Artist: artist, Track: track, CachedAt: time.Now().UTC()})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeTrackDurationCacheEntry(value string) (trackDurationCacheEntry, error) {
+	if !strings.HasPrefix(value, "{") {
+		return trackDurationCacheEntry{Duration: value}, nil
+	}
+
+	var entry trackDurationCacheEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		return entry, fmt.Errorf("failed to parse cached track duration: %w", err)
+	}
+	return entry, nil
+}
+
+var cacheExportHeader = []string{"Artist", "Track", "Duration"}
+
+// ExportCache writes the cached track durations as an artist, track and duration CSV.
+// Entries cached before tracks were named in the cache can't be exported.
+func ExportCache(ctx context.Context, c *Config, w io.Writer) error {
+	if c.CacheType == "inmemory" {
+		return errors.New("the inmemory cache is not persisted between runs, there is nothing to export")
+	}
+
+	if err := ensureDataDir(c.DataDir); err != nil {
+		return err
+	}
+	if err := initCache(ctx, c); err != nil {
+		return err
+	}
+	defer c.cache.Close()
+
+	entries, err := c.cache.Entries(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list cache entries: %w", err)
+	}
+
+	durations := make(map[trackKey]string)
+	var unnamed int
+	for key, value := range entries {
+		entry, err := decodeTrackDurationCacheEntry(value)
+		if err != nil {
+			slog.Warn("Ignoring malformed cache entry", "key", key, "error", err)
+			continue
+		}
+		if entry.Artist == "" || entry.Track == "" {
+			unnamed++
+			continue
+		}
+		k := trackKey{entry.Artist, entry.Track}
+		// The duration of a pinned recording takes precedence over the searched one
+		if _, found := durations[k]; found && !strings.HasPrefix(key, pinnedCacheKeyPrefix) {
+			continue
+		}
+		durations[k] = entry.Duration
+	}
+
+	keys := slices.SortedFunc(maps.Keys(durations), func(a, b trackKey) int {
+		return cmp.Or(cmp.Compare(a.artist, b.artist), cmp.Compare(a.track, b.track))
+	})
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(cacheExportHeader); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	for _, k := range keys {
+		if err := cw.Write([]string{k.artist, k.track, durations[k]}); err != nil {
+			return fmt.Errorf("failed to write CSV record: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	slog.Info("Exported cached track durations", "count", len(keys), "unnamed", unnamed)
+	return nil
+}
+
+// ImportCache caches the track durations of a CSV written by ExportCache
+func ImportCache(ctx context.Context, c *Config, r io.Reader) error {
+	if c.CacheType == "inmemory" {
+		return errors.New("the inmemory cache is not persisted between runs, use the file, sqlite or redis cache type to import into it")
+	}
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if len(records) == 0 || !slices.Equal(records[0], cacheExportHeader) {
+		return fmt.Errorf("unexpected CSV header, expected %v", cacheExportHeader)
+	}
+
+	if err := ensureDataDir(c.DataDir); err != nil {
+		return err
+	}
+	c.artistAliases, err = getArtistAliases(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get artist aliases: %w", err)
+	}
+	if err := initCache(ctx, c); err != nil {
+		return err
+	}
+	defer c.cache.Close()
+
+	var imported int
+	for i, record := range records[1:] {
+		artist, track := record[0], record[1]
+		duration, err := time.ParseDuration(record[2])
+		if err != nil || duration <= 0 {
+			slog.Warn("Ignoring invalid track duration", "line", i+2, "artist", artist, "track", track, "duration", record[2])
+			continue
+		}
+		cacheTrackDuration(ctx, c, trackDurationCacheKey(musicBrainzArtist(c, artist), track), artist, track, duration)
+		imported++
+	}
+
+	slog.Info("Imported track durations", "count", imported)
+	return nil
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cterence/scrobble-deduplicator/internal/cache"
+)
+
+func TestCacheExportImportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		imported string
+		expected string
+	}{
+		{name: "empty", imported: "Artist,Track,Duration\n", expected: "Artist,Track,Duration\n"},
+		{
+			name:     "sorted by artist and track",
+			imported: "Artist,Track,Duration\nB,Song,3m0s\nA,Song,4m5s\nA,Other,1m0s\n",
+			expected: "Artist,Track,Duration\nA,Other,1m0s\nA,Song,4m5s\nB,Song,3m0s\n",
+		},
+		{
+			name:     "invalid durations skipped",
+			imported: "Artist,Track,Duration\nA,Song,soon\nA,Other,0s\nB,Song,3m0s\n",
+			expected: "Artist,Track,Duration\nB,Song,3m0s\n",
+		},
+		{
+			name:     "quoted fields",
+			imported: "Artist,Track,Duration\n\"Artist, The\",\"Song \"\"Live\"\"\",2m0s\n",
+			expected: "Artist,Track,Duration\n\"Artist, The\",\"Song \"\"Live\"\"\",2m0s\n",
+		},
+	}
+	for _, cacheType := range []string{"file", "sqlite"} {
+		for _, tt := range tests {
+			t.Run(cacheType+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				c := &Config{CacheType: cacheType, DataDir: path.Join(t.TempDir(), "data")}
+				if err := ImportCache(ctx, c, strings.NewReader(tt.imported)); err != nil {
+					t.Fatalf("ImportCache() error = %v", err)
+				}
+
+				var exported bytes.Buffer
+				if err := ExportCache(ctx, c, &exported); err != nil {
+					t.Fatalf("ExportCache() error = %v", err)
+				}
+				if got := exported.String(); got != tt.expected {
+					t.Errorf("ExportCache() = %q, expected %q", got, tt.expected)
+				}
+			})
+		}
+	}
+}
+
+func TestImportCacheErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		cacheType string
+		imported  string
+	}{
+		{name: "inmemory cache", cacheType: "inmemory", imported: "Artist,Track,Duration\n"},
+		{name: "empty file", cacheType: "file", imported: ""},
+		{name: "unexpected header", cacheType: "file", imported: "Track,Artist,Duration\n"},
+		{name: "missing column", cacheType: "file", imported: "Artist,Track,Duration\nA,Song\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{CacheType: tt.cacheType, DataDir: t.TempDir()}
+			if err := ImportCache(context.Background(), c, strings.NewReader(tt.imported)); err == nil {
+				t.Errorf("ImportCache() error = nil, expected an error")
+			}
+		})
+	}
+}
+
+func TestPurgeCacheEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		mbidMap string
+		// Whether the pinned recording duration is cached, the searched duration always is
+		pinned       bool
+		expectedLeft []string
+	}{
+		{name: "searched duration"},
+		{name: "searched and pinned durations", mbidMap: "Artist:\n  Song: live\n", pinned: true},
+		{name: "pinned duration of another track kept", mbidMap: "Artist:\n  Other: live\n", pinned: true, expectedLeft: []string{pinnedTrackDurationCacheKey("live")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := &Config{CacheType: "file", DataDir: t.TempDir()}
+			if tt.mbidMap != "" {
+				if err := os.WriteFile(path.Join(c.DataDir, mbidMapFile), []byte(tt.mbidMap), 0644); err != nil {
+					t.Fatalf("failed to write MBID map: %v", err)
+				}
+			}
+
+			if err := initCache(ctx, c); err != nil {
+				t.Fatalf("initCache() error = %v", err)
+			}
+			cacheTrackDuration(ctx, c, trackDurationCacheKey("Artist", "Song"), "Artist", "Song", 3*time.Minute)
+			if tt.pinned {
+				cacheTrackDuration(ctx, c, pinnedTrackDurationCacheKey("live"), "Artist", "Song", 6*time.Minute)
+			}
+			c.cache.Close()
+
+			if err := PurgeCacheEntry(ctx, c, "Artist", "Song"); err != nil {
+				t.Fatalf("PurgeCacheEntry() error = %v", err)
+			}
+
+			if err := initCache(ctx, c); err != nil {
+				t.Fatalf("initCache() error = %v", err)
+			}
+			defer c.cache.Close()
+			for _, key := range []string{trackDurationCacheKey("Artist", "Song"), pinnedTrackDurationCacheKey("live")} {
+				_, err := c.cache.Get(ctx, key)
+				left := !errors.Is(err, cache.ErrCacheMiss)
+				expected := slices.Contains(tt.expectedLeft, key)
+				if left != expected {
+					t.Errorf("%s cached after the purge = %v, expected %v", key, left, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	checkpointFile   = "checkpoint.json"
+	scrobblesPerPage = 50
+)
+
+// checkpoint records the progress of a run through the library pages, so that an interrupted run can be resumed
+type checkpoint struct {
+	Username string `json:"username"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Delete   bool   `json:"delete"`
+	// Last page fully processed, out of the pages of the library when the run started
+	Page       int `json:"page"`
+	TotalPages int `json:"totalPages"`
+	// Scrobbles deleted by the run, which shrink the library
+	Deleted       int64     `json:"deleted"`
+	LastProcessed time.Time `json:"lastProcessed"`
+}
+
+func readCheckpoint(dataDir string) (*checkpoint, error) {
+	b, err := os.ReadFile(path.Join(dataDir, checkpointFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// writeCheckpoint replaces the checkpoint through a temporary file, so that a crash never leaves a partial one
+func writeCheckpoint(dataDir string, cp checkpoint) error {
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	tmpPath := path.Join(dataDir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmpPath, b, 0666); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmpPath, path.Join(dataDir, checkpointFile)); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+func clearCheckpoint(dataDir string) error {
+	if err := os.Remove(path.Join(dataDir, checkpointFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete checkpoint: %w", err)
+	}
+	return nil
+}
+
+func newCheckpoint(c *Config, page int) checkpoint {
+	cp := checkpoint{
+		Username:      c.LastFMUsername,
+		Delete:        c.canDelete,
+		Page:          page,
+		TotalPages:    c.totalPages,
+		LastProcessed: c.lastProcessedTimestamp,
+	}
+	if c.canDelete {
+		cp.Deleted = c.runStats.deletions.Load()
+	}
+	if !c.From.IsZero() {
+		cp.From = c.From.Format(LastFMQueryDayFormat)
+	}
+	if !c.To.IsZero() {
+		cp.To = c.To.Format(LastFMQueryDayFormat)
+	}
+	return cp
+}
+
+// matches reports whether the checkpoint was written by a run over the same library pages with the same deletion setting
+func (cp *checkpoint) matches(c *Config) bool {
+	current := newCheckpoint(c, 0)
+	return cp.Username == current.Username && cp.From == current.From && cp.To == current.To && cp.Delete == current.Delete
+}
+
+// checkpointEnabled reports whether the run goes through library pages and can record its progress
+func checkpointEnabled(c *Config) bool {
+	return !c.dataDirReadOnly && !c.analyzeOnly && !c.scanOnly && !c.warmCacheOnly && !c.CountOnly && c.ImportDeletions == ""
+}
+
+// initCheckpoint loads the checkpoint of an interrupted run when Resume is set, scrobbles up to its last
+// processed one are then skipped
+func initCheckpoint(c *Config) error {
+	cp, err := readCheckpoint(c.DataDir)
+	if err != nil {
+		return err
+	}
+	if cp == nil {
+		if c.Resume {
+			slog.Info("No checkpoint found, processing the whole selected period")
+		}
+		return nil
+	}
+	if !c.Resume {
+		slog.Info("A previous run was interrupted, set resume to continue it", "page", cp.Page, "lastProcessed", cp.LastProcessed)
+		return nil
+	}
+	if !cp.matches(c) {
+		slog.Warn("Ignoring checkpoint of a run with other settings", "username", cp.Username, "from", cp.From, "to", cp.To, "delete", cp.Delete)
+		return nil
+	}
+
+	c.checkpoint = cp
+	c.resumeAfter = cp.LastProcessed
+	return nil
+}
+
+// resumedStartPage returns the page to resume the checkpoint from. Pages are numbered from the newest scrobble:
+// scrobbles added since the checkpoint move the remaining pages up, while the deleted ones shrink the library without
+// moving them. A page more is processed to absorb rounding, its already processed scrobbles are skipped.
+func resumedStartPage(c *Config, totalPages int) int {
+	cp := c.checkpoint
+	if cp == nil {
+		return totalPages
+	}
+
+	deletedPages := int((cp.Deleted + scrobblesPerPage - 1) / scrobblesPerPage)
+	shift := max(0, totalPages-cp.TotalPages+deletedPages)
+	page := min(totalPages, cp.Page+shift+1)
+	slog.Info("Resuming from checkpoint", "page", page, "checkpointPage", cp.Page, "lastProcessed", cp.LastProcessed)
+	return page
+}
+
+// saveCheckpoint records that a page was fully processed. Once a page failed, the checkpoint stays before it
+// so that a resumed run processes it again.
+func saveCheckpoint(c *Config, page int) {
+	if !checkpointEnabled(c) || len(c.failedPages) > 0 || c.lastProcessedTimestamp.IsZero() {
+		return
+	}
+	if err := writeCheckpoint(c.DataDir, newCheckpoint(c, page)); err != nil {
+		slog.Warn("Failed to save checkpoint", "page", page, "error", err)
+	}
+}
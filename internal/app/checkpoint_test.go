@@ -0,0 +1,115 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResumedStartPage(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkpoint *checkpoint
+		totalPages int
+		expected   int
+	}{
+		{name: "no checkpoint", totalPages: 100, expected: 100},
+		{name: "unchanged library", checkpoint: &checkpoint{Page: 80, TotalPages: 100}, totalPages: 100, expected: 81},
+		{name: "scrobbles added since the checkpoint", checkpoint: &checkpoint{Page: 80, TotalPages: 100}, totalPages: 102, expected: 83},
+		{name: "deleted scrobbles shrink the library", checkpoint: &checkpoint{Page: 80, TotalPages: 100, Deleted: 50}, totalPages: 99, expected: 81},
+		{name: "partial page of deleted scrobbles", checkpoint: &checkpoint{Page: 80, TotalPages: 100, Deleted: 60}, totalPages: 99, expected: 82},
+		{name: "fewer pages than the checkpoint", checkpoint: &checkpoint{Page: 80, TotalPages: 100}, totalPages: 90, expected: 81},
+		{name: "capped at the oldest page", checkpoint: &checkpoint{Page: 100, TotalPages: 100}, totalPages: 100, expected: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{checkpoint: tt.checkpoint}
+			if got := resumedStartPage(c, tt.totalPages); got != tt.expected {
+				t.Errorf("resumedStartPage() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dataDir := t.TempDir()
+
+	cp, err := readCheckpoint(dataDir)
+	if err != nil || cp != nil {
+		t.Fatalf("readCheckpoint() without checkpoint = %v, %v, expected nil, nil", cp, err)
+	}
+
+	c := &Config{
+		DataDir:                dataDir,
+		LastFMUsername:         "alice",
+		From:                   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		canDelete:              true,
+		totalPages:             100,
+		lastProcessedTimestamp: time.Unix(1700000000, 0).UTC(),
+	}
+	c.runStats.deletions.Store(12)
+	// Only the deletions that succeeded shrink the library
+	c.runStats.scrobbleDeleteFails.Store(3)
+	expected := checkpoint{
+		Username:      "alice",
+		From:          "2024-01-02",
+		Delete:        true,
+		Page:          42,
+		TotalPages:    100,
+		Deleted:       12,
+		LastProcessed: c.lastProcessedTimestamp,
+	}
+	if got := newCheckpoint(c, 42); got != expected {
+		t.Fatalf("newCheckpoint() = %+v, expected %+v", got, expected)
+	}
+
+	saveCheckpoint(c, 42)
+	cp, err = readCheckpoint(dataDir)
+	if err != nil {
+		t.Fatalf("readCheckpoint() error = %v", err)
+	}
+	if cp == nil || *cp != expected {
+		t.Fatalf("readCheckpoint() = %+v, expected %+v", cp, expected)
+	}
+
+	if err := clearCheckpoint(dataDir); err != nil {
+		t.Fatalf("clearCheckpoint() error = %v", err)
+	}
+	if cp, err := readCheckpoint(dataDir); err != nil || cp != nil {
+		t.Errorf("readCheckpoint() after clearCheckpoint() = %v, %v, expected nil, nil", cp, err)
+	}
+}
+
+func TestInitCheckpoint(t *testing.T) {
+	lastProcessed := time.Unix(1700000000, 0).UTC()
+	saved := checkpoint{Username: "alice", Delete: true, Page: 42, TotalPages: 100, LastProcessed: lastProcessed}
+	tests := []struct {
+		name            string
+		resume          bool
+		username        string
+		canDelete       bool
+		expectedResumed bool
+	}{
+		{name: "resume", resume: true, username: "alice", canDelete: true, expectedResumed: true},
+		{name: "resume not set", username: "alice", canDelete: true},
+		{name: "other user", resume: true, username: "bob", canDelete: true},
+		{name: "dry run resuming a deleting run", resume: true, username: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{DataDir: t.TempDir(), LastFMUsername: tt.username, Resume: tt.resume, canDelete: tt.canDelete}
+			if err := writeCheckpoint(c.DataDir, saved); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := initCheckpoint(c); err != nil {
+				t.Fatalf("initCheckpoint() error = %v", err)
+			}
+			if resumed := c.checkpoint != nil; resumed != tt.expectedResumed {
+				t.Fatalf("checkpoint loaded = %v, expected %v", resumed, tt.expectedResumed)
+			}
+			if tt.expectedResumed && !c.resumeAfter.Equal(lastProcessed) {
+				t.Errorf("resumeAfter = %v, expected %v", c.resumeAfter, lastProcessed)
+			}
+		})
+	}
+}
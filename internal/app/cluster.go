@@ -0,0 +1,64 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+)
+
+const (
+	KeepLast              = "last"
+	KeepHighestCompletion = "highest-completion"
+)
+
+// addToDuplicateCluster groups successive duplicates of a track so that the scrobble to keep is chosen once the cluster ends
+func addToDuplicateCluster(c *Config, previousScrobble *scrobble, currentScrobble *scrobble) {
+	if len(c.duplicateCluster) == 0 {
+		c.duplicateCluster = append(c.duplicateCluster, previousScrobble)
+	}
+	c.duplicateCluster = append(c.duplicateCluster, currentScrobble)
+}
+
+// resolveDuplicateCluster keeps the scrobble of the cluster with the highest completion and handles the others as duplicates.
+// The completion of a scrobble is measured up to the following scrobble, nextScrobble is nil when the cluster ends the library.
+func resolveDuplicateCluster(ctx context.Context, c *Config, nextScrobble *scrobble) {
+	cluster := c.duplicateCluster
+	if len(cluster) == 0 {
+		return
+	}
+	c.duplicateCluster = nil
+
+	keptIndex := highestCompletionIndex(c, cluster, nextScrobble)
+	slog.Debug("Resolved duplicate cluster", "artist", cluster[keptIndex].artist, "track", cluster[keptIndex].track, "size", len(cluster), "keptTimestamp", cluster[keptIndex].timestamp)
+
+	for i, s := range cluster {
+		if i == keptIndex {
+			continue
+		}
+		if _, err := handleDetectedScrobble(ctx, c, s, cluster[keptIndex], false, "duplicate"); err != nil {
+			slog.Warn("failed to delete scrobble", "error", err)
+		}
+	}
+}
+
+// highestCompletionIndex returns the index of the most complete scrobble of a cluster, the latest one on ties.
+// The play of each scrobble lasts until the following one and is measured like duplicate detection does.
+func highestCompletionIndex(c *Config, cluster []*scrobble, nextScrobble *scrobble) int {
+	// The first scrobble may come from a previous run and have no duration, all of them share the track of the last one
+	trackDuration := cluster[len(cluster)-1].trackDuration
+
+	keptIndex := 0
+	highestCompletion := -1.0
+	for i, s := range cluster {
+		// Without a following scrobble, the play is assumed complete
+		completion := 100.0
+		if i+1 < len(cluster) {
+			completion = playCompletion(c, cluster[i+1].timestamp.Sub(s.timestamp), trackDuration)
+		} else if nextScrobble != nil {
+			completion = playCompletion(c, nextScrobble.timestamp.Sub(s.timestamp), trackDuration)
+		}
+		if completion >= highestCompletion {
+			keptIndex, highestCompletion = i, completion
+		}
+	}
+	return keptIndex
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHighestCompletionIndex(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(c *Config)
+		// Gaps between the scrobbles of the cluster, the last one to the next scrobble
+		gaps     []time.Duration
+		noNext   bool
+		expected int
+	}{
+		// The full play of a 4 minutes track is 2 minutes
+		{name: "middle scrobble most complete", gaps: []time.Duration{30 * time.Second, time.Minute, 15 * time.Second}, expected: 1},
+		{name: "plays beyond the full play tie", gaps: []time.Duration{3 * time.Minute, 2*time.Minute + 30*time.Second, 15 * time.Second}, expected: 1},
+		{name: "last scrobble without next", gaps: []time.Duration{30 * time.Second, time.Minute, 0}, noNext: true, expected: 2},
+		{
+			name:      "measured against the min replay gap",
+			configure: func(c *Config) { c.MinReplayGap = 5 * time.Minute },
+			gaps:      []time.Duration{3 * time.Minute, 2*time.Minute + 30*time.Second, 15 * time.Second},
+			expected:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testDetectionConfig()
+			if tt.configure != nil {
+				tt.configure(c)
+			}
+			timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			var cluster []*scrobble
+			for _, gap := range tt.gaps {
+				cluster = append(cluster, &scrobble{artist: "Artist", track: "Song", timestamp: timestamp, trackDuration: 4 * time.Minute})
+				timestamp = timestamp.Add(gap)
+			}
+			var next *scrobble
+			if !tt.noNext {
+				next = &scrobble{artist: "Artist", track: "Other", timestamp: timestamp}
+			}
+
+			if got := highestCompletionIndex(c, cluster, next); got != tt.expected {
+				t.Errorf("highestCompletionIndex() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
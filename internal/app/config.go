@@ -1,75 +1,264 @@
 package app
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/cterence/scrobble-deduplicator/internal/cache"
 	"github.com/go-telegram/bot"
-	"github.com/michiwend/gomusicbrainz"
 )
 
 type Config struct {
 	// Inputs
-	FilePath           string
-	CacheType          string
-	LastFMUsername     string
-	LastFMPassword     string
-	CanDelete          bool
-	StartPage          int
-	From               time.Time
-	To                 time.Time
-	BrowserHeadful     bool
-	RedisURL           string
-	BrowserURL         string
-	LogLevel           string
-	DuplicateThreshold int
-	CompleteThreshold  int
-	ProcessingMode     string
-	DataDir            string
-	TelegramBotToken   string
-	TelegramChatID     string
+	FilePath       string
+	CacheType      string
+	CacheTTL       time.Duration
+	LastFMUsername string
+	LastFMPassword string
+	// Deletion intent, the effective setting is computed by finalize
+	Delete         bool
+	DeleteSample   int
+	StartPage      int
+	From           time.Time
+	To             time.Time
+	BrowserHeadful bool
+	// Pause before browser actions, only applied in headful mode
+	SlowMo                 time.Duration
+	RedisURL               string
+	SQLitePath             string
+	BrowserURL             string
+	BrowserAuthToken       string
+	BrowserHeaders         []string
+	BrowserKeepAlive       time.Duration
+	LogLevel               string
+	DuplicateThreshold     int
+	CompleteThreshold      int
+	ThresholdEpsilon       float64
+	FullPlayAt             int
+	IncludeEqualTimestamps bool
+	MaxDuplicateGap        time.Duration
+	MinReplayGap           time.Duration
+	SessionGap             time.Duration
+	ProcessingMode         string
+	// Browser tabs loading library pages ahead in parallel processing mode
+	Workers          int
+	DataDir          string
+	TelegramBotToken string
+	TelegramChatID   string
+	// Topic of a forum chat, 0 sends to the main thread
+	TelegramMessageThreadID int
+	// Headers of the webhook-url request in the "Name: value" form, for authentication
+	WebhookHeaders []string
+	// Send a progress update every interval (ex: 30m) or number of pages (ex: 20) of long runs
+	ProgressNotifyEvery string
+	// Accumulate the runs in a digest sent once per period instead of notifying each run, 0 disables it
+	DigestPeriod       time.Duration
+	DeletionWebhookURL string
+	WebhookURL         string
+	CachePages         bool
+	ReplayPages        bool
+	// Save the redacted HTML of each library page to reproduce scraping issues
+	SaveTrace bool
+	// Skip the pages failing all retries instead of aborting the run
+	ContinueOnPageError bool
+	PageDelay           time.Duration
+	PageDelayJitter     time.Duration
+	ResultsDB           string
+	CSVDialect          string
+	ExportFormat        string
+	// Go template of the exported file names, without extension
+	OutputNameTemplate  string
+	CSVSanitize         bool
+	CSVDelimiter        string
+	CSVBOM              bool
+	OnlyNewSinceLastRun bool
+	Resume              bool
+	// Deleted scrobbles CSV export whose scrobbles are deleted again instead of detecting duplicates
+	ImportDeletions string
+	// Scrobble of an incomplete pair to delete, duplicates always delete the previous scrobble
+	IncompleteDeleteTarget string
+	// Delete an incomplete scrobble only when both its neighbours are complete plays of other tracks
+	SkipOnlyWhenSurrounded bool
+	// Never delete the last remaining scrobble of a track among the processed ones
+	KeepOnePerTrack bool
+	// Only look up the duration of scrobbles following a scrobble of the same track, the others are never compared
+	SkipUncomparedDurations bool
+	// Scrobble kept from a cluster of successive duplicates
+	Keep                  string
+	DisableMusicBrainz    bool
+	DisableLastFMFallback bool
+	// Key of the Last.fm API, durations unknown to MusicBrainz are then read from it instead of the track page
+	LastFMAPIKey string
+	// Deadline of the duration lookup of each scrobble, a scrobble whose lookup exceeds it is skipped
+	PerScrobbleTimeout time.Duration
+	// Directory of FLAC files whose tags take precedence over MusicBrainz for track durations
+	LocalLibrary       string
+	LockWait           time.Duration
+	Review             bool
+	CountOnly          bool
+	AlertOnDeletions   int
+	AlertOnFailureRate float64
+	// Abort the run after this many deletions failed in a row, 0 disables it
+	MaxDeleteFailures int
+	// Version of the tool, recorded in the metadata of the exports
+	Version string
 
 	// Internal dependencies
-	startTime   time.Time
-	cache       cache.Cache
-	runStats    stats
-	mb          *gomusicbrainz.WS2Client
-	taskCtx     context.Context
-	telegramBot *bot.Bot
+	startTime       time.Time
+	cache           cache.Cache
+	runStats        stats
+	mb              MusicBrainzClient
+	taskCtx         context.Context
+	stopCtx         context.Context
+	telegramBot     *bot.Bot
+	webhook         *deletionWebhook
+	progress        *progressNotifier
+	lock            *runLock
+	reviewInput     *bufio.Reader
+	reviewOutput    io.Writer
+	scanOutput      io.Writer
+	warmCacheOutput io.Writer
 
 	// Internal variables
-	noLogin               bool
-	unknownTrackDurations durationByTrackByArtist
-	deletedScrobbles      []*scrobble
+	noLogin                       bool
+	canDelete                     bool
+	scanOnly                      bool
+	scan                          scanStats
+	warmCacheOnly                 bool
+	warmCache                     warmCacheStats
+	analyzeOnly                   bool
+	analyzeScrobbles              []scrobble
+	importedDeletions             []*importedDeletion
+	dataDirReadOnly               bool
+	loadedPage                    int
+	resumeAfter                   time.Time
+	checkpoint                    *checkpoint
+	totalPages                    int
+	runCompleted                  bool
+	lastProcessedTimestamp        time.Time
+	unknownTrackDurations         durationByTrackByArtist
+	unknownTrackDurationsInMemory int
+	flushedUnknownTracks          map[uint64]struct{}
+	browserActivity               atomic.Int64
+	deletedScrobbles              []*scrobble
+	duplicateCluster              []*scrobble
+	pendingSkip                   *pendingSkip
+	processedByTrack              map[trackKey]int
+	detectedByTrack               map[trackKey]int
+	consecutiveDeleteFailures     int
+	failedPages                   []int
+	pageTrackGaps                 map[trackKey][]time.Duration
+	artistAliases                 map[string]string
+	mbidMap                       mbidByTrackByArtist
+	localLibrary                  map[trackKey]time.Duration
+	outputNameTemplate            *template.Template
+	progressCadence               progressCadence
+	webhookHeaders                http.Header
+	browserHeaders                http.Header
 
 	// Closing functions
 	allocCancel context.CancelFunc
 	taskCancel  context.CancelFunc
 }
 
+const (
+	ProcessingModeSequential = "sequential"
+	ProcessingModeParallel   = "parallel"
+)
+
+const (
+	IncompleteDeleteTargetCurrent  = "current"
+	IncompleteDeleteTargetPrevious = "previous"
+)
+
+// Counters are atomic so that they can be updated while pages or durations are processed concurrently
 type stats struct {
-	cacheHits                      int
-	cacheMisses                    int
-	processedScrobbles             int
-	unknownTrackDurationsCount     int
-	skippedScrobbleUnknownDuration int
-	scrobbleDeleteFails            int
-	elapsedTime                    time.Duration
+	cacheHits                  atomic.Int64
+	cacheMisses                atomic.Int64
+	processedScrobbles         atomic.Int64
+	unknownTrackDurationsCount atomic.Int64
+	// Track duration lookups done on cache misses and their total time in nanoseconds
+	durationLookups    atomic.Int64
+	durationLookupTime atomic.Int64
+	// Scrobbles skipped by reason: first lookup of a track without duration, track already without duration, failed lookup
+	skippedDurationNotFound     atomic.Int64
+	skippedKnownUnknownDuration atomic.Int64
+	skippedDurationLookupError  atomic.Int64
+	futureScrobbles             atomic.Int64
+	notSurroundedSkips          atomic.Int64
+	lastOfTrackKept             atomic.Int64
+	lookupTimeouts              atomic.Int64
+	uncomparedScrobbles         atomic.Int64
+	corruptedCacheEntries       atomic.Int64
+	importedDeletionsNotFound   atomic.Int64
+	scrobbleDeleteFails         atomic.Int64
+	deletions                   atomic.Int64
+	reviewKeptScrobbles         atomic.Int64
+	sampleDeletions             atomic.Int64
+	sampleDryRunScrobbles       atomic.Int64
+	elapsedTime                 time.Duration
+}
+
+// skippedScrobbles returns the scrobbles skipped due to an unknown track duration, whatever the reason
+func (s *stats) skippedScrobbles() int64 {
+	return s.skippedDurationNotFound.Load() + s.skippedKnownUnknownDuration.Load() + s.skippedDurationLookupError.Load()
+}
+
+// cacheHitRate returns the percentage of track duration queries answered by the cache
+func (s *stats) cacheHitRate() float64 {
+	hits, misses := s.cacheHits.Load(), s.cacheMisses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses) * 100
+}
+
+// cacheTimeSaved estimates the lookup time avoided by cache hits from the average lookup time of the run
+func (s *stats) cacheTimeSaved() time.Duration {
+	lookups := s.durationLookups.Load()
+	if lookups == 0 {
+		return 0
+	}
+	return time.Duration(s.durationLookupTime.Load() / lookups * s.cacheHits.Load())
 }
 
 func (c *Config) checkConfig() error {
 	slog.Debug("Validating config")
 
+	// Exports are analyzed without logging in to Last.fm
+	if !c.analyzeOnly && c.LastFMUsername == "" {
+		return errors.New("lastfm-username must be set")
+	}
+
+	// Replayed pages are processed without logging in, other runs log in with the saved session cookie or the password
+	if !c.analyzeOnly && !c.ReplayPages && c.LastFMPassword == "" && !sessionCookieSaved(c.DataDir) {
+		return fmt.Errorf("lastfm-password must be set, no session cookie is saved in %s", c.DataDir)
+	}
+
 	if c.CacheType == "redis" && c.RedisURL == "" {
 		return errors.New("must set redis-url if cache-type is redis")
 	}
 
+	if c.ProcessingMode != ProcessingModeSequential && c.ProcessingMode != ProcessingModeParallel {
+		return fmt.Errorf("unknown processing-mode: %s (valid modes: %s, %s)", c.ProcessingMode, ProcessingModeSequential, ProcessingModeParallel)
+	}
+
+	if c.Workers < 1 {
+		return errors.New("workers must be at least 1")
+	}
+
 	if c.StartPage != 0 && (!c.From.IsZero() || !c.To.IsZero()) {
 		return errors.New(`start-page and "from" / "to" dates must not be set at the same time`)
 	}
@@ -86,29 +275,257 @@ func (c *Config) checkConfig() error {
 		return errors.New("complete-threshold must be between 0 and 100")
 	}
 
+	if c.FullPlayAt <= 0 || c.FullPlayAt > 100 {
+		return errors.New("full-play-at must be between 1 and 100")
+	}
+
+	if c.ThresholdEpsilon < 0 || c.ThresholdEpsilon >= 1 {
+		return errors.New("threshold-epsilon must be between 0 and 1")
+	}
+
 	if (c.TelegramBotToken != "" && c.TelegramChatID == "") || (c.TelegramBotToken == "" && c.TelegramChatID != "") {
 		return errors.New("telegram-bot-token and telegram-chat-id must both be set")
 	}
 
+	if c.TelegramMessageThreadID < 0 {
+		return errors.New("telegram-message-thread-id must not be negative")
+	}
+
+	if c.TelegramMessageThreadID != 0 && c.TelegramBotToken == "" {
+		return errors.New("telegram-message-thread-id requires telegram-bot-token and telegram-chat-id")
+	}
+
+	if c.DigestPeriod < 0 {
+		return errors.New("digest-period must not be negative")
+	}
+
+	if c.DigestPeriod > 0 && c.TelegramBotToken == "" {
+		return errors.New("digest-period requires telegram-bot-token and telegram-chat-id")
+	}
+
+	cadence, err := parseProgressCadence(c.ProgressNotifyEvery)
+	if err != nil {
+		return fmt.Errorf("invalid progress-notify-every: %w", err)
+	}
+	if cadence != (progressCadence{}) && c.TelegramBotToken == "" {
+		return errors.New("progress-notify-every requires telegram-bot-token and telegram-chat-id")
+	}
+	c.progressCadence = cadence
+
+	if c.StartPage != 0 && c.OnlyNewSinceLastRun {
+		return errors.New("start-page and only-new-since-last-run must not be set at the same time")
+	}
+
+	if c.Resume && (c.StartPage != 0 || c.OnlyNewSinceLastRun) {
+		return errors.New("resume is incompatible with start-page and only-new-since-last-run")
+	}
+
+	if c.IncompleteDeleteTarget != IncompleteDeleteTargetCurrent && c.IncompleteDeleteTarget != IncompleteDeleteTargetPrevious {
+		return fmt.Errorf("unknown incomplete-delete-target: %s", c.IncompleteDeleteTarget)
+	}
+
+	if c.SkipOnlyWhenSurrounded && (c.CompleteThreshold <= 0 || c.IncompleteDeleteTarget != IncompleteDeleteTargetCurrent) {
+		return errors.New("skip-only-when-surrounded requires complete-threshold and incomplete-delete-target current")
+	}
+
+	if c.SkipUncomparedDurations && c.CompleteThreshold > 0 {
+		// Incomplete detection compares every scrobble with the previous one, whatever its track
+		return errors.New("skip-uncompared-durations and complete-threshold must not be set at the same time")
+	}
+
+	if c.Keep != KeepLast && c.Keep != KeepHighestCompletion {
+		return fmt.Errorf("unknown keep strategy: %s", c.Keep)
+	}
+
+	if c.CountOnly && c.Review {
+		return errors.New("count-only and review must not be set at the same time")
+	}
+
+	if c.Review && (!c.BrowserHeadful || c.ReplayPages) {
+		return errors.New("review requires browser-headful and is incompatible with replay-pages")
+	}
+
+	if c.ReplayPages && c.SaveTrace {
+		return errors.New("replay-pages and save-trace must not be set at the same time")
+	}
+
+	if c.analyzeOnly && (c.Delete || c.Review || c.ReplayPages || c.OnlyNewSinceLastRun) {
+		return errors.New("analyze is incompatible with delete, review, replay-pages and only-new-since-last-run")
+	}
+
+	if c.ImportDeletions != "" && (c.analyzeOnly || c.scanOnly || c.warmCacheOnly || c.CountOnly || c.Review || c.ReplayPages || c.CachePages) {
+		return errors.New("import-deletions is incompatible with analyze, scan, warm-cache, count-only, review, replay-pages and cache-pages")
+	}
+
+	if c.ImportDeletions != "" && (c.StartPage != 0 || !c.From.IsZero() || !c.To.IsZero() || c.OnlyNewSinceLastRun || c.Resume) {
+		// The pages of the imported scrobbles are looked up from their timestamps
+		return errors.New("import-deletions is incompatible with start-page, from, to, only-new-since-last-run and resume")
+	}
+
+	if c.ReplayPages && c.Delete {
+		return errors.New("replay-pages and delete must not be set at the same time")
+	}
+
+	if c.CSVDialect != CSVDialectDefault && c.CSVDialect != CSVDialectLastFMImport {
+		return fmt.Errorf("unknown csv-dialect: %s", c.CSVDialect)
+	}
+
+	if c.ExportFormat != ExportFormatCSV && c.ExportFormat != ExportFormatJSON && c.ExportFormat != ExportFormatBoth {
+		return fmt.Errorf("unknown export-format: %s", c.ExportFormat)
+	}
+
+	if !validCSVDelimiter(c.CSVDelimiter) {
+		return fmt.Errorf("invalid csv-delimiter: %q", c.CSVDelimiter)
+	}
+
+	tmpl, err := parseOutputNameTemplate(c.OutputNameTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid output-name-template: %w", err)
+	}
+	c.outputNameTemplate = tmpl
+
+	if c.MaxDuplicateGap < 0 {
+		return errors.New("max-duplicate-gap must not be negative")
+	}
+
+	if c.PerScrobbleTimeout < 0 {
+		return errors.New("per-scrobble-timeout must not be negative")
+	}
+
+	if c.SessionGap < 0 {
+		return errors.New("session-gap must not be negative")
+	}
+
+	if c.MinReplayGap < 0 {
+		return errors.New("min-replay-gap must not be negative")
+	}
+
+	if c.MinReplayGap > 0 && c.MaxDuplicateGap > 0 && c.MinReplayGap > c.MaxDuplicateGap {
+		return errors.New("min-replay-gap must not be greater than max-duplicate-gap")
+	}
+
+	if c.BrowserAuthToken != "" && c.BrowserURL == "" {
+		return errors.New("browser-auth-token requires browser-url")
+	}
+
+	if len(c.BrowserHeaders) > 0 && c.BrowserURL == "" {
+		return errors.New("browser-header requires browser-url")
+	}
+
+	browserHeaders, err := parseHeaders(c.BrowserHeaders)
+	if err != nil {
+		return fmt.Errorf("invalid browser-header: %w", err)
+	}
+	c.browserHeaders = browserHeaders
+
+	if c.BrowserKeepAlive < 0 || (c.BrowserKeepAlive > 0 && c.BrowserKeepAlive < time.Second) {
+		return errors.New("browser-keepalive must be 0 or at least 1s")
+	}
+
+	if c.BrowserKeepAlive > 0 && c.BrowserURL == "" {
+		return errors.New("browser-keepalive requires browser-url, local browsers are not reclaimed when idle")
+	}
+
+	if c.CacheTTL < 0 {
+		return errors.New("cache-ttl must not be negative")
+	}
+
+	if c.SlowMo < 0 {
+		return errors.New("slow-mo must not be negative")
+	}
+
+	if c.PageDelay < 0 || c.PageDelayJitter < 0 {
+		return errors.New("page-delay and page-delay-jitter must not be negative")
+	}
+
+	if c.DeleteSample < 0 {
+		return errors.New("delete-sample must not be negative")
+	}
+
+	if c.DeleteSample > 0 && !c.Delete {
+		return errors.New("delete-sample requires delete to be set")
+	}
+
+	if c.MaxDeleteFailures < 0 {
+		return errors.New("max-consecutive-delete-failures must not be negative")
+	}
+
+	if c.AlertOnDeletions < 0 {
+		return errors.New("alert-on-deletions must not be negative")
+	}
+
+	if c.AlertOnFailureRate < 0 || c.AlertOnFailureRate > 1 {
+		return errors.New("alert-on-failure-rate must be between 0 and 1")
+	}
+
+	if c.DeletionWebhookURL != "" {
+		if _, err := url.ParseRequestURI(c.DeletionWebhookURL); err != nil {
+			return fmt.Errorf("invalid deletion-webhook-url: %w", err)
+		}
+	}
+
+	if c.WebhookURL != "" {
+		if _, err := url.ParseRequestURI(c.WebhookURL); err != nil {
+			return fmt.Errorf("invalid webhook-url: %w", err)
+		}
+	}
+
+	if len(c.WebhookHeaders) > 0 && c.WebhookURL == "" {
+		return errors.New("webhook-header requires webhook-url")
+	}
+
+	headers, err := parseHeaders(c.WebhookHeaders)
+	if err != nil {
+		return fmt.Errorf("invalid webhook-header: %w", err)
+	}
+	c.webhookHeaders = headers
+
 	return nil
 }
 
+// finalize derives the effective settings from the inputs once they are validated
+func (c *Config) finalize() {
+	if c.SlowMo > 0 && !c.BrowserHeadful {
+		slog.Debug("Ignoring slow-mo in headless mode")
+		c.SlowMo = 0
+	}
+
+	// Deletion is only effective in this single place, every check uses canDelete
+	c.canDelete = c.Delete && !c.CountOnly && !c.scanOnly && !c.warmCacheOnly && !c.analyzeOnly
+
+	if c.CountOnly {
+		// Counting must be fast and side effect free: no deletion and no network duration lookup
+		c.DisableMusicBrainz = true
+		c.DisableLastFMFallback = true
+	}
+}
+
 func (c *Config) close() {
-	c.allocCancel()
-	c.taskCancel()
+	if c.allocCancel != nil {
+		c.allocCancel()
+	}
+	if c.taskCancel != nil {
+		c.taskCancel()
+	}
 	c.cache.Close()
 }
 
-func (c *Config) handleInterrupts(ctx context.Context) {
+// handleInterrupts stops processing after the current page on the first interrupt, the run then finishes normally.
+// A second interrupt exits right away.
+func (c *Config) handleInterrupts(stop context.CancelFunc) {
 	sigInterrupt := make(chan os.Signal, 1)
 	signal.Notify(sigInterrupt, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-sigInterrupt
-		slog.Warn("Closing due to interrupt")
-		if err := finishRun(ctx, c); err != nil {
-			slog.Error("Failed to finish run", "error", err)
-		}
+		slog.Warn("Interrupted, stopping after the current page, interrupt again to exit immediately")
+		stop()
 
+		<-sigInterrupt
+		slog.Warn("Closing due to second interrupt")
+		// Deferred functions don't run on exit, a left over lock would make the next run wait for a dead process and the
+		// durations looked up since the last flush of the file cache would be lost
+		c.lock.release()
+		c.cache.Close()
 		os.Exit(1)
 	}()
 }
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestStatsConcurrentUpdates updates the statistics from several goroutines while they are read, like the workers of
+// the parallel processing mode and the progress updates do. Run with -race to catch unsynchronized counters.
+func TestStatsConcurrentUpdates(t *testing.T) {
+	const (
+		workers    = 8
+		increments = 1000
+	)
+	tests := []struct {
+		name    string
+		counter func(s *stats) *atomic.Int64
+	}{
+		{name: "cache hits", counter: func(s *stats) *atomic.Int64 { return &s.cacheHits }},
+		{name: "cache misses", counter: func(s *stats) *atomic.Int64 { return &s.cacheMisses }},
+		{name: "processed scrobbles", counter: func(s *stats) *atomic.Int64 { return &s.processedScrobbles }},
+		{name: "duration lookups", counter: func(s *stats) *atomic.Int64 { return &s.durationLookups }},
+		{name: "skipped unknown durations", counter: func(s *stats) *atomic.Int64 { return &s.skippedDurationNotFound }},
+		{name: "deletions", counter: func(s *stats) *atomic.Int64 { return &s.deletions }},
+		{name: "delete failures", counter: func(s *stats) *atomic.Int64 { return &s.scrobbleDeleteFails }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				s       stats
+				writers sync.WaitGroup
+				done    = make(chan struct{})
+				reader  sync.WaitGroup
+			)
+			reader.Go(func() {
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						_ = s.snapshot()
+						_ = s.cacheHitRate()
+						_ = s.skippedScrobbles()
+					}
+				}
+			})
+			for range workers {
+				writers.Go(func() {
+					for range increments {
+						tt.counter(&s).Add(1)
+						s.durationLookupTime.Add(int64(time.Millisecond))
+					}
+				})
+			}
+			writers.Wait()
+			close(done)
+			reader.Wait()
+
+			if got := tt.counter(&s).Load(); got != workers*increments {
+				t.Errorf("counter = %d, expected %d", got, workers*increments)
+			}
+		})
+	}
+}
+
+func TestStatsCacheSummary(t *testing.T) {
+	tests := []struct {
+		name              string
+		hits              int64
+		misses            int64
+		lookups           int64
+		lookupTime        time.Duration
+		expectedHitRate   float64
+		expectedTimeSaved time.Duration
+	}{
+		{name: "no queries"},
+		{name: "all hits", hits: 4, expectedHitRate: 100},
+		{name: "hits and lookups", hits: 3, misses: 1, lookups: 1, lookupTime: 2 * time.Second, expectedHitRate: 75, expectedTimeSaved: 6 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s stats
+			s.cacheHits.Store(tt.hits)
+			s.cacheMisses.Store(tt.misses)
+			s.durationLookups.Store(tt.lookups)
+			s.durationLookupTime.Store(int64(tt.lookupTime))
+
+			if got := s.cacheHitRate(); got != tt.expectedHitRate {
+				t.Errorf("cacheHitRate() = %v, expected %v", got, tt.expectedHitRate)
+			}
+			if got := s.cacheTimeSaved(); got != tt.expectedTimeSaved {
+				t.Errorf("cacheTimeSaved() = %s, expected %s", got, tt.expectedTimeSaved)
+			}
+		})
+	}
+}
+
+// testValidConfig returns a config that passes checkConfig, like the one of the default flag values
+func testValidConfig(t *testing.T) *Config {
+	t.Helper()
+	return &Config{
+		LastFMUsername:         "alice",
+		LastFMPassword:         "password",
+		DataDir:                t.TempDir(),
+		CacheType:              "file",
+		ProcessingMode:         ProcessingModeSequential,
+		Workers:                1,
+		DuplicateThreshold:     90,
+		FullPlayAt:             50,
+		ThresholdEpsilon:       0.001,
+		IncompleteDeleteTarget: IncompleteDeleteTargetCurrent,
+		Keep:                   KeepLast,
+		CSVDialect:             CSVDialectDefault,
+		ExportFormat:           ExportFormatCSV,
+		CSVDelimiter:           ",",
+	}
+}
+
+func TestCheckConfigHeaders(t *testing.T) {
+	tests := []struct {
+		name                   string
+		configure              func(c *Config)
+		expectedErr            bool
+		expectedBrowserHeaders http.Header
+		expectedWebhookHeaders http.Header
+	}{
+		{name: "no headers", configure: func(c *Config) {}, expectedBrowserHeaders: http.Header{}, expectedWebhookHeaders: http.Header{}},
+		{
+			name: "browser header",
+			configure: func(c *Config) {
+				c.BrowserURL = "ws://browser:3000"
+				c.BrowserHeaders = []string{"Authorization: Bearer token"}
+			},
+			expectedBrowserHeaders: http.Header{"Authorization": {"Bearer token"}},
+			expectedWebhookHeaders: http.Header{},
+		},
+		{name: "browser header without browser-url", configure: func(c *Config) { c.BrowserHeaders = []string{"Authorization: Bearer token"} }, expectedErr: true},
+		{
+			name: "invalid browser header",
+			configure: func(c *Config) {
+				c.BrowserURL = "ws://browser:3000"
+				c.BrowserHeaders = []string{"Authorization"}
+			},
+			expectedErr: true,
+		},
+		{
+			name: "webhook header",
+			configure: func(c *Config) {
+				c.WebhookURL = "https://example.com/hook"
+				c.WebhookHeaders = []string{"X-Api-Key: secret"}
+			},
+			expectedBrowserHeaders: http.Header{},
+			expectedWebhookHeaders: http.Header{"X-Api-Key": {"secret"}},
+		},
+		{name: "webhook header without webhook-url", configure: func(c *Config) { c.WebhookHeaders = []string{"X-Api-Key: secret"} }, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testValidConfig(t)
+			tt.configure(c)
+			err := c.checkConfig()
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("checkConfig() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if tt.expectedErr {
+				return
+			}
+			if !reflect.DeepEqual(c.browserHeaders, tt.expectedBrowserHeaders) {
+				t.Errorf("browserHeaders = %v, expected %v", c.browserHeaders, tt.expectedBrowserHeaders)
+			}
+			if !reflect.DeepEqual(c.webhookHeaders, tt.expectedWebhookHeaders) {
+				t.Errorf("webhookHeaders = %v, expected %v", c.webhookHeaders, tt.expectedWebhookHeaders)
+			}
+		})
+	}
+}
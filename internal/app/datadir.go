@@ -0,0 +1,42 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"syscall"
+)
+
+func isReadOnlyError(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// ensureDataDir creates the data directory if it does not exist yet, a read-only file system is reported by checkDataDirWritable
+func ensureDataDir(dataDir string) error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil && !isReadOnlyError(err) {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return nil
+}
+
+// checkDataDirWritable reports whether files can be created in the data directory
+func checkDataDirWritable(dataDir string) bool {
+	f, err := os.CreateTemp(dataDir, ".write-check-*")
+	if err != nil {
+		if isReadOnlyError(err) {
+			slog.Warn("⚠️ Data directory is read-only, results will be printed to stdout and the file cache, run lock and cookies will not be saved", "dataDir", dataDir, "error", err)
+			return false
+		}
+		// Let the actual writers report other errors, like a missing directory
+		return true
+	}
+
+	if err := f.Close(); err != nil {
+		slog.Debug("Failed to close write check file", "error", err)
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		slog.Debug("Failed to remove write check file", "error", err)
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+type exportedScrobble struct {
+	artist          string
+	track           string
+	timestamp       string
+	timestampString string
+	// Columns missing from exports of older versions
+	survivingTimestamp string
+	deleteXPath        string
+}
+
+// Columns compared between exports, exports from older versions may lack the other columns
+var exportedScrobbleColumns = []string{"Artist", "Track", "Timestamp", "TimestampString"}
+
+type exportDiff struct {
+	added     []exportedScrobble
+	removed   []exportedScrobble
+	unchanged []exportedScrobble
+}
+
+// readExportedScrobbles reads a deleted scrobbles CSV export, locating columns by header name
+func readExportedScrobbles(filename string) ([]exportedScrobble, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer helpers.CloseFile(f)
+
+	// Exports may start with a byte order mark and use another delimiter, see csv-bom and csv-delimiter
+	br := bufio.NewReader(f)
+	if bom, err := br.Peek(len(utf8BOM)); err == nil && string(bom) == utf8BOM {
+		if _, err := br.Discard(len(utf8BOM)); err != nil {
+			return nil, err
+		}
+	}
+	head, err := br.Peek(br.Buffered())
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	reader.Comma = exportDelimiter(head)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header of %s: %w", filename, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for _, name := range exportedScrobbleColumns {
+		i := slices.Index(header, name)
+		if i == -1 {
+			return nil, fmt.Errorf("column %s not found in %s", name, filename)
+		}
+		columns[name] = i
+	}
+
+	survivingTimestampColumn := slices.Index(header, "SurvivingTimestamp")
+	deleteXPathColumn := slices.Index(header, "DeleteXPath")
+
+	var scrobbles []exportedScrobble
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		if len(record) < len(header) {
+			return nil, fmt.Errorf("incomplete row in %s: %v", filename, record)
+		}
+		s := exportedScrobble{
+			artist:          record[columns["Artist"]],
+			track:           record[columns["Track"]],
+			timestamp:       record[columns["Timestamp"]],
+			timestampString: record[columns["TimestampString"]],
+		}
+		if survivingTimestampColumn != -1 {
+			s.survivingTimestamp = record[survivingTimestampColumn]
+		}
+		if deleteXPathColumn != -1 {
+			s.deleteXPath = record[deleteXPathColumn]
+		}
+		scrobbles = append(scrobbles, s)
+	}
+	return scrobbles, nil
+}
+
+// exportDelimiter guesses the delimiter of an export from the first separator found in its header, header names
+// never contain one
+func exportDelimiter(header []byte) rune {
+	header, _, _ = bytes.Cut(header, []byte("\n"))
+	if i := bytes.IndexAny(header, ",;\t|"); i != -1 {
+		return rune(header[i])
+	}
+	return ','
+}
+
+func diffExportedScrobbles(oldScrobbles, newScrobbles []exportedScrobble) exportDiff {
+	key := func(s exportedScrobble) string {
+		return s.artist + "\x00" + s.track + "\x00" + s.timestampString
+	}
+
+	oldKeys := make(map[string]bool, len(oldScrobbles))
+	for _, s := range oldScrobbles {
+		oldKeys[key(s)] = true
+	}
+	newKeys := make(map[string]bool, len(newScrobbles))
+	for _, s := range newScrobbles {
+		newKeys[key(s)] = true
+	}
+
+	var diff exportDiff
+	for _, s := range newScrobbles {
+		if oldKeys[key(s)] {
+			diff.unchanged = append(diff.unchanged, s)
+		} else {
+			diff.added = append(diff.added, s)
+		}
+	}
+	for _, s := range oldScrobbles {
+		if !newKeys[key(s)] {
+			diff.removed = append(diff.removed, s)
+		}
+	}
+	return diff
+}
+
+// DiffExports prints the scrobbles newly flagged, no longer flagged and flagged in both exports
+func DiffExports(oldFile, newFile string, w io.Writer) error {
+	oldScrobbles, err := readExportedScrobbles(oldFile)
+	if err != nil {
+		return fmt.Errorf("failed to read old export: %w", err)
+	}
+	newScrobbles, err := readExportedScrobbles(newFile)
+	if err != nil {
+		return fmt.Errorf("failed to read new export: %w", err)
+	}
+
+	diff := diffExportedScrobbles(oldScrobbles, newScrobbles)
+
+	sections := []struct {
+		title     string
+		prefix    string
+		scrobbles []exportedScrobble
+	}{
+		{"Newly flagged", "+", diff.added},
+		{"No longer flagged", "-", diff.removed},
+		{"Unchanged", " ", diff.unchanged},
+	}
+	for _, section := range sections {
+		if _, err := fmt.Fprintf(w, "%s: %d\n", section.title, len(section.scrobbles)); err != nil {
+			return err
+		}
+		for _, s := range section.scrobbles {
+			if _, err := fmt.Fprintf(w, "%s %s - %s (%s)\n", section.prefix, s.artist, s.track, s.timestamp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+)
+
+const (
+	digestFile       = "digest.json"
+	digestTopArtists = 5
+)
+
+// digest accumulates the results of the runs between two digest notifications
+type digest struct {
+	Since              time.Time      `json:"since"`
+	Runs               int            `json:"runs"`
+	AlertRuns          int            `json:"alertRuns"`
+	Deletions          int            `json:"deletions"`
+	DeleteFails        int64          `json:"deleteFails"`
+	ProcessedScrobbles int64          `json:"processedScrobbles"`
+	DeletionsByArtist  map[string]int `json:"deletionsByArtist"`
+}
+
+func readDigest(dataDir string) (digest, error) {
+	d := digest{DeletionsByArtist: make(map[string]int)}
+
+	b, err := os.ReadFile(path.Join(dataDir, digestFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return d, nil
+		}
+		return d, fmt.Errorf("failed to read digest: %w", err)
+	}
+	if err := json.Unmarshal(b, &d); err != nil {
+		return d, fmt.Errorf("failed to parse digest: %w", err)
+	}
+	if d.DeletionsByArtist == nil {
+		d.DeletionsByArtist = make(map[string]int)
+	}
+	return d, nil
+}
+
+func writeDigest(dataDir string, d digest) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest: %w", err)
+	}
+	if err := os.WriteFile(path.Join(dataDir, digestFile), b, 0666); err != nil {
+		return fmt.Errorf("failed to write digest: %w", err)
+	}
+	return nil
+}
+
+func addRunToDigest(c *Config, d *digest) {
+	if d.Runs == 0 {
+		d.Since = c.startTime
+	}
+	d.Runs++
+	d.Deletions += len(c.deletedScrobbles)
+	d.DeleteFails += c.runStats.scrobbleDeleteFails.Load()
+	d.ProcessedScrobbles += c.runStats.processedScrobbles.Load()
+	for _, s := range c.deletedScrobbles {
+		d.DeletionsByArtist[s.artist]++
+	}
+}
+
+func digestMessage(d digest) string {
+	lines := []string{
+		fmt.Sprintf("Digest of %d runs since %s", d.Runs, d.Since.Format(time.RFC1123)),
+		fmt.Sprintf("Runs notified with an alert: %d", d.AlertRuns),
+		fmt.Sprintf("Duplicated scrobbles: %d", d.Deletions),
+		fmt.Sprintf("Scrobbles processed: %d", d.ProcessedScrobbles),
+		fmt.Sprintf("Scrobbles not deleted due to error: %d", d.DeleteFails),
+	}
+
+	// Artists with the most duplicated scrobbles
+	artists := slices.SortedFunc(maps.Keys(d.DeletionsByArtist), func(a, b string) int {
+		return cmp.Or(cmp.Compare(d.DeletionsByArtist[b], d.DeletionsByArtist[a]), cmp.Compare(a, b))
+	})
+	if len(artists) > 0 {
+		lines = append(lines, "Top artists:")
+		for i, artist := range artists[:min(digestTopArtists, len(artists))] {
+			lines = append(lines, fmt.Sprintf("%d. %s: %d", i+1, artist, d.DeletionsByArtist[artist]))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// digestRun adds the run to the digest and sends the digest once the digest period has elapsed since its first run
+func digestRun(ctx context.Context, c *Config) error {
+	d, err := readDigest(c.DataDir)
+	if err != nil {
+		return err
+	}
+	addRunToDigest(c, &d)
+
+	if time.Since(d.Since) < c.DigestPeriod {
+		slog.Info("Run added to digest", "runs", d.Runs, "since", d.Since)
+		return writeDigest(c.DataDir, d)
+	}
+	return sendDigest(ctx, c, d)
+}
+
+// digestAlertRun adds a run notified right away because of its alerts to the digest, without sending the digest
+func digestAlertRun(c *Config) error {
+	d, err := readDigest(c.DataDir)
+	if err != nil {
+		return err
+	}
+	addRunToDigest(c, &d)
+	d.AlertRuns++
+	return writeDigest(c.DataDir, d)
+}
+
+func sendDigest(ctx context.Context, c *Config, d digest) error {
+	if err := sendTelegramMessage(ctx, c, digestMessage(d)); err != nil {
+		return fmt.Errorf("failed to send digest: %w", err)
+	}
+	slog.Info("Sent digest telegram message", "runs", d.Runs)
+
+	if err := os.Remove(path.Join(c.DataDir, digestFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to reset digest: %w", err)
+	}
+	return nil
+}
+
+// SendDigest sends the accumulated digest without waiting for the digest period to elapse
+func SendDigest(ctx context.Context, c *Config) error {
+	if c.TelegramBotToken == "" || c.TelegramChatID == "" {
+		return errors.New("telegram-bot-token and telegram-chat-id must be set to send the digest")
+	}
+
+	d, err := readDigest(c.DataDir)
+	if err != nil {
+		return err
+	}
+	if d.Runs == 0 {
+		slog.Info("No run in digest, nothing to send")
+		return nil
+	}
+
+	b, err := bot.New(c.TelegramBotToken)
+	if err != nil {
+		return fmt.Errorf("failed to init telegram bot: %w", err)
+	}
+	c.telegramBot = b
+
+	return sendDigest(ctx, c, d)
+}
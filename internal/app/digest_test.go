@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDigestRuns(t *testing.T) {
+	tests := []struct {
+		name              string
+		runs              int
+		alertRuns         int
+		expectedRuns      int
+		expectedAlertRuns int
+		expectedDeletions int
+	}{
+		{name: "runs within the period", runs: 3, expectedRuns: 3, expectedDeletions: 6},
+		{name: "alert runs counted", runs: 2, alertRuns: 1, expectedRuns: 3, expectedAlertRuns: 1, expectedDeletions: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Runs within the digest period are accumulated without being notified, no telegram bot is needed
+			c := &Config{DataDir: t.TempDir(), DigestPeriod: 168 * time.Hour, startTime: time.Now()}
+			for _, artist := range []string{"Artist", "Other"} {
+				previous, _ := testScrobblePair(0, 0)
+				previous.artist = artist
+				c.deletedScrobbles = append(c.deletedScrobbles, previous)
+			}
+			c.runStats.processedScrobbles.Store(10)
+
+			for range tt.runs {
+				if err := digestRun(context.Background(), c); err != nil {
+					t.Fatalf("digestRun() error = %v", err)
+				}
+			}
+			for range tt.alertRuns {
+				if err := digestAlertRun(c); err != nil {
+					t.Fatalf("digestAlertRun() error = %v", err)
+				}
+			}
+
+			d, err := readDigest(c.DataDir)
+			if err != nil {
+				t.Fatalf("readDigest() error = %v", err)
+			}
+			if d.Runs != tt.expectedRuns || d.AlertRuns != tt.expectedAlertRuns || d.Deletions != tt.expectedDeletions {
+				t.Errorf("digest = %d runs, %d alert runs and %d deletions, expected %d, %d and %d",
+					d.Runs, d.AlertRuns, d.Deletions, tt.expectedRuns, tt.expectedAlertRuns, tt.expectedDeletions)
+			}
+			if expected := tt.expectedRuns * 10; d.ProcessedScrobbles != int64(expected) || d.DeletionsByArtist["Artist"] != tt.expectedRuns {
+				t.Errorf("digest = %d processed and %v, expected %d processed and %d deletions of Artist",
+					d.ProcessedScrobbles, d.DeletionsByArtist, expected, tt.expectedRuns)
+			}
+		})
+	}
+}
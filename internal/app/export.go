@@ -0,0 +1,298 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+const (
+	CSVDialectDefault      = "default"
+	CSVDialectLastFMImport = "lastfm-import"
+)
+
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+	ExportFormatBoth = "both"
+)
+
+// utf8BOM tells spreadsheet applications the CSV export is UTF-8 instead of the encoding of their locale
+const utf8BOM = "\uFEFF"
+
+var scrobblesCSVHeader = []string{"Artist", "Track", "Timestamp", "TimestampString", "SurvivingTimestamp", "DeleteXPath"}
+
+// Columns accepted by Last.fm bulk scrobbling tools, to restore deleted scrobbles
+var lastFMImportCSVHeader = []string{"uts", "artist", "track", "album", "duration"}
+
+const DefaultOutputNameTemplate = "{{.Artifact}}-{{.StartTime}}"
+
+// outputNameData holds the variables available in the output name template
+type outputNameData struct {
+	Artifact  string
+	Username  string
+	StartTime string
+	From      string
+	To        string
+}
+
+func parseOutputNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Catch unknown variables before the run rather than when exporting its results
+	if err := tmpl.Execute(io.Discard, outputNameData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// outputFilename names an exported artifact with the output name template
+func outputFilename(c *Config, artifact, extension string) (string, error) {
+	data := outputNameData{
+		Artifact:  artifact,
+		Username:  c.LastFMUsername,
+		StartTime: c.startTime.Format("20060102-150405"),
+	}
+	if !c.From.IsZero() {
+		data.From = c.From.Format(LastFMQueryDayFormat)
+	}
+	if !c.To.IsZero() {
+		data.To = c.To.Format(LastFMQueryDayFormat)
+	}
+
+	var name strings.Builder
+	if err := c.outputNameTemplate.Execute(&name, data); err != nil {
+		return "", fmt.Errorf("failed to execute output name template: %w", err)
+	}
+	if name.Len() == 0 || strings.ContainsAny(name.String(), `/\`) {
+		return "", fmt.Errorf("invalid output file name: %q", name.String())
+	}
+	return name.String() + extension, nil
+}
+
+func exportScrobblesToCSV(c *Config, artifact string) {
+	filename, err := outputFilename(c, artifact, ".csv")
+	if err != nil {
+		slog.Warn("⚠️ Could not name deleted scrobble file, falling back to logging scrobbles as CSV", "error", err)
+		logScrobblesCSV(c, c.deletedScrobbles)
+		return
+	}
+
+	slices.SortFunc(c.deletedScrobbles, func(s1, s2 *scrobble) int {
+		return s1.timestamp.Compare(s2.timestamp)
+	})
+
+	if c.dataDirReadOnly {
+		logScrobblesCSV(c, c.deletedScrobbles)
+		return
+	}
+
+	file, err := os.Create(path.Join(c.DataDir, filename))
+	if err != nil {
+		slog.Warn("⚠️ Could not create deleted scrobble file, falling back to logging scrobbles as CSV", "file", filename, "error", err)
+		logScrobblesCSV(c, c.deletedScrobbles)
+		return
+	}
+	defer helpers.CloseFile(file)
+
+	if c.CSVBOM {
+		if _, err := file.WriteString(utf8BOM); err != nil {
+			slog.Error("Failed to write deleted scrobbles file", "file", file.Name(), "error", err)
+			return
+		}
+	}
+
+	if err := writeScrobblesCSV(c, file, c.deletedScrobbles); err != nil {
+		slog.Error("Failed to write deleted scrobbles file", "file", file.Name(), "error", err)
+		return
+	}
+
+	if c.canDelete {
+		slog.Info("Deleted scrobbles saved to file", "file", file.Name())
+	} else {
+		slog.Info("Would-be deleted scrobbles saved to file", "file", file.Name())
+	}
+
+	if metadataFile, err := writeExportMetadata(c, file.Name()); err != nil {
+		slog.Warn("Could not save export metadata", "file", file.Name(), "error", err)
+	} else {
+		slog.Debug("Export metadata saved to file", "file", metadataFile)
+	}
+}
+
+// exportedScrobbleJSON is a deleted scrobble of the JSON export
+type exportedScrobbleJSON struct {
+	Artist          string `json:"artist"`
+	Track           string `json:"track"`
+	Timestamp       string `json:"timestamp"`
+	TimestampString string `json:"timestampString"`
+	// Timestamp of the scrobble kept from the pair
+	SurvivingTimestamp string `json:"survivingTimestamp"`
+	// Percentage of the track duration played between the scrobble and the other scrobble of its pair, as calculated by the detection
+	CompletionPercentage float64 `json:"completionPercentage"`
+}
+
+func exportScrobblesToJSON(c *Config, artifact string) {
+	filename, err := outputFilename(c, artifact, ".json")
+	if err != nil {
+		slog.Warn("⚠️ Could not name deleted scrobble file, falling back to logging scrobbles as JSON", "error", err)
+		logScrobblesJSON(c.deletedScrobbles)
+		return
+	}
+
+	slices.SortFunc(c.deletedScrobbles, func(s1, s2 *scrobble) int {
+		return s1.timestamp.Compare(s2.timestamp)
+	})
+
+	if c.dataDirReadOnly {
+		logScrobblesJSON(c.deletedScrobbles)
+		return
+	}
+
+	file, err := os.Create(path.Join(c.DataDir, filename))
+	if err != nil {
+		slog.Warn("⚠️ Could not create deleted scrobble file, falling back to logging scrobbles as JSON", "file", filename, "error", err)
+		logScrobblesJSON(c.deletedScrobbles)
+		return
+	}
+	defer helpers.CloseFile(file)
+
+	if err := writeScrobblesJSON(file, c.deletedScrobbles); err != nil {
+		slog.Error("Failed to write deleted scrobbles file", "file", file.Name(), "error", err)
+		return
+	}
+
+	if c.canDelete {
+		slog.Info("Deleted scrobbles saved to file", "file", file.Name())
+	} else {
+		slog.Info("Would-be deleted scrobbles saved to file", "file", file.Name())
+	}
+
+	if metadataFile, err := writeExportMetadata(c, file.Name()); err != nil {
+		slog.Warn("Could not save export metadata", "file", file.Name(), "error", err)
+	} else {
+		slog.Debug("Export metadata saved to file", "file", metadataFile)
+	}
+}
+
+func logScrobblesJSON(scrobbles []*scrobble) {
+	fmt.Println("Scrobbles JSON:")
+	if err := writeScrobblesJSON(os.Stdout, scrobbles); err != nil {
+		slog.Error("Failed to log scrobbles as JSON", "error", err)
+	}
+}
+
+func writeScrobblesJSON(w io.Writer, scrobbles []*scrobble) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(scrobblesJSON(scrobbles))
+}
+
+func scrobblesJSON(scrobbles []*scrobble) []exportedScrobbleJSON {
+	records := make([]exportedScrobbleJSON, 0, len(scrobbles))
+	for _, s := range scrobbles {
+		records = append(records, exportedScrobbleJSON{
+			Artist:               s.artist,
+			Track:                s.track,
+			Timestamp:            s.timestamp.Format(time.RFC3339),
+			TimestampString:      s.timestampString,
+			SurvivingTimestamp:   s.survivingTimestamp.Format(time.RFC3339),
+			CompletionPercentage: math.Round(s.completion*100) / 100,
+		})
+	}
+	return records
+}
+
+func logScrobblesCSV(c *Config, scrobbles []*scrobble) {
+	fmt.Println("Scrobbles CSV:")
+	if err := writeScrobblesCSV(c, os.Stdout, scrobbles); err != nil {
+		slog.Error("Failed to log scrobbles as CSV", "error", err)
+	}
+}
+
+func writeScrobblesCSV(c *Config, w io.Writer, scrobbles []*scrobble) error {
+	writer := csv.NewWriter(w)
+	writer.Comma, _ = utf8.DecodeRuneInString(c.CSVDelimiter)
+
+	header, toRecord := scrobblesCSVHeader, scrobbleCSVRecord
+	if c.CSVDialect == CSVDialectLastFMImport {
+		header, toRecord = lastFMImportCSVHeader, scrobbleLastFMImportRecord
+	}
+
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, s := range scrobbles {
+		record := toRecord(s)
+		if c.CSVSanitize {
+			for i := range record {
+				record[i] = sanitizeCSVField(record[i])
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func scrobbleCSVRecord(s *scrobble) []string {
+	return []string{
+		s.artist,
+		s.track,
+		s.timestamp.Format(time.RFC3339),
+		s.timestampString,
+		s.survivingTimestamp.Format(time.RFC3339),
+		s.deleteXPath,
+	}
+}
+
+func scrobbleLastFMImportRecord(s *scrobble) []string {
+	return []string{
+		strconv.FormatInt(s.timestamp.Unix(), 10),
+		s.artist,
+		s.track,
+		s.album,
+		strconv.Itoa(int(s.trackDuration.Seconds())),
+	}
+}
+
+// sanitizeCSVField prevents spreadsheet applications from interpreting a field as a formula
+func sanitizeCSVField(field string) string {
+	if field != "" && strings.ContainsAny(field[:1], "=+-@\t\r") {
+		return "'" + field
+	}
+	return field
+}
+
+// unsanitizeCSVField restores a field of an export written with csv-sanitize
+func unsanitizeCSVField(field string) string {
+	if len(field) > 1 && field[0] == '\'' && strings.ContainsAny(field[1:2], "=+-@\t\r") {
+		return field[1:]
+	}
+	return field
+}
+
+// validCSVDelimiter accepts the single characters encoding/csv can use as a field delimiter
+func validCSVDelimiter(delimiter string) bool {
+	r, size := utf8.DecodeRuneInString(delimiter)
+	return size == len(delimiter) && r != utf8.RuneError && !strings.ContainsRune("\"\r\n", r)
+}
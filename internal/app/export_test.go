@@ -0,0 +1,252 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testExportedScrobble() *scrobble {
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &scrobble{
+		artist:             "Artist",
+		track:              "=Track",
+		album:              "Album",
+		timestamp:          timestamp,
+		timestampString:    "1704164645",
+		trackDuration:      4 * time.Minute,
+		survivingTimestamp: timestamp.Add(time.Minute),
+		deleteXPath:        `//input[@name='timestamp'][@value='1704164645']/ancestor::tr[last()]`,
+	}
+}
+
+func TestWriteScrobblesCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		c        *Config
+		expected string
+	}{
+		{
+			name: "default dialect",
+			c:    &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ","},
+			expected: "Artist,Track,Timestamp,TimestampString,SurvivingTimestamp,DeleteXPath\n" +
+				"Artist,=Track,2024-01-02T03:04:05Z,1704164645,2024-01-02T03:05:05Z,//input[@name='timestamp'][@value='1704164645']/ancestor::tr[last()]\n",
+		},
+		{
+			name: "sanitized",
+			c:    &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ",", CSVSanitize: true},
+			expected: "Artist,Track,Timestamp,TimestampString,SurvivingTimestamp,DeleteXPath\n" +
+				"Artist,'=Track,2024-01-02T03:04:05Z,1704164645,2024-01-02T03:05:05Z,//input[@name='timestamp'][@value='1704164645']/ancestor::tr[last()]\n",
+		},
+		{
+			name: "semicolon delimiter",
+			c:    &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ";"},
+			expected: "Artist;Track;Timestamp;TimestampString;SurvivingTimestamp;DeleteXPath\n" +
+				"Artist;=Track;2024-01-02T03:04:05Z;1704164645;2024-01-02T03:05:05Z;//input[@name='timestamp'][@value='1704164645']/ancestor::tr[last()]\n",
+		},
+		{
+			name:     "Last.fm import dialect",
+			c:        &Config{CSVDialect: CSVDialectLastFMImport, CSVDelimiter: ","},
+			expected: "uts,artist,track,album,duration\n1704164645,Artist,=Track,Album,240\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeScrobblesCSV(tt.c, &b, []*scrobble{testExportedScrobble()}); err != nil {
+				t.Fatalf("writeScrobblesCSV() error = %v", err)
+			}
+			if got := b.String(); got != tt.expected {
+				t.Errorf("writeScrobblesCSV() =\n%s\nexpected\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSanitizeCSVField(t *testing.T) {
+	tests := []struct {
+		field    string
+		expected string
+	}{
+		{field: "", expected: ""},
+		{field: "Track", expected: "Track"},
+		{field: "=SUM(A1)", expected: "'=SUM(A1)"},
+		{field: "+1", expected: "'+1"},
+		{field: "-1", expected: "'-1"},
+		{field: "@user", expected: "'@user"},
+		{field: "'quoted", expected: "'quoted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got := sanitizeCSVField(tt.field)
+			if got != tt.expected {
+				t.Errorf("sanitizeCSVField(%q) = %q, expected %q", tt.field, got, tt.expected)
+			}
+			if restored := unsanitizeCSVField(got); restored != tt.field {
+				t.Errorf("unsanitizeCSVField(%q) = %q, expected %q", got, restored, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidCSVDelimiter(t *testing.T) {
+	tests := []struct {
+		delimiter string
+		expected  bool
+	}{
+		{delimiter: ",", expected: true},
+		{delimiter: ";", expected: true},
+		{delimiter: "\t", expected: true},
+		{delimiter: "¦", expected: true},
+		{delimiter: "", expected: false},
+		{delimiter: ",;", expected: false},
+		{delimiter: `"`, expected: false},
+		{delimiter: "\n", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.delimiter, func(t *testing.T) {
+			if got := validCSVDelimiter(tt.delimiter); got != tt.expected {
+				t.Errorf("validCSVDelimiter(%q) = %v, expected %v", tt.delimiter, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCSVExportImportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *Config
+	}{
+		{name: "default", c: &Config{CSVDelimiter: ","}},
+		{name: "spreadsheet friendly", c: &Config{CSVDelimiter: ";", CSVBOM: true, CSVSanitize: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.c
+			c.DataDir = t.TempDir()
+			c.CSVDialect = CSVDialectDefault
+			tmpl, err := parseOutputNameTemplate(DefaultOutputNameTemplate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.outputNameTemplate = tmpl
+			exported := testExportedScrobble()
+			c.deletedScrobbles = []*scrobble{exported}
+
+			exportScrobblesToCSV(c, "deleted-scrobbles")
+			filename, err := outputFilename(c, "deleted-scrobbles", ".csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deletions, err := readImportedDeletions(path.Join(c.DataDir, filename))
+			if err != nil {
+				t.Fatalf("readImportedDeletions() error = %v", err)
+			}
+			if len(deletions) != 1 {
+				t.Fatalf("readImportedDeletions() read %d deletions, expected 1", len(deletions))
+			}
+			d := deletions[0]
+			if d.scrobble.artist != exported.artist || d.scrobble.track != exported.track || d.scrobble.timestampString != exported.timestampString {
+				t.Errorf("imported scrobble = %+v, expected %+v", d.scrobble, exported)
+			}
+			if !d.scrobble.timestamp.Equal(exported.timestamp) || !d.scrobble.survivingTimestamp.Equal(exported.survivingTimestamp) {
+				t.Errorf("imported timestamps = %v, %v, expected %v, %v", d.scrobble.timestamp, d.scrobble.survivingTimestamp, exported.timestamp, exported.survivingTimestamp)
+			}
+			if !d.deleteLast {
+				t.Error("imported deletion must delete the last scrobble of its timestamp like the exported XPath")
+			}
+		})
+	}
+}
+
+func TestScrobblesJSON(t *testing.T) {
+	s := testExportedScrobble()
+	s.completion = 100.0 / 3
+	unpaired := testExportedScrobble()
+	unpaired.survivingTimestamp = time.Time{}
+
+	var b strings.Builder
+	if err := writeScrobblesJSON(&b, []*scrobble{s, unpaired}); err != nil {
+		t.Fatalf("writeScrobblesJSON() error = %v", err)
+	}
+	var got []exportedScrobbleJSON
+	if err := json.Unmarshal([]byte(b.String()), &got); err != nil {
+		t.Fatalf("failed to parse JSON export: %v", err)
+	}
+
+	expected := []exportedScrobbleJSON{
+		// The completion is rounded to two decimals
+		{Artist: "Artist", Track: "=Track", Timestamp: "2024-01-02T03:04:05Z", TimestampString: "1704164645", SurvivingTimestamp: "2024-01-02T03:05:05Z", CompletionPercentage: 33.33},
+		{Artist: "Artist", Track: "=Track", Timestamp: "2024-01-02T03:04:05Z", TimestampString: "1704164645", SurvivingTimestamp: "0001-01-01T00:00:00Z"},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("writeScrobblesJSON() wrote %d scrobbles, expected %d", len(got), len(expected))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("scrobble %d = %+v, expected %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func TestOutputFilename(t *testing.T) {
+	startTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name        string
+		template    string
+		expected    string
+		expectedErr bool
+	}{
+		{name: "default", template: DefaultOutputNameTemplate, expected: "deleted-scrobbles-20240102-030405.csv"},
+		{name: "user and range", template: "{{.Username}}-{{.From}}-{{.Artifact}}", expected: "alice-2023-12-01-deleted-scrobbles.csv"},
+		{name: "path separator", template: "{{.Artifact}}/{{.Username}}", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseOutputNameTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Config{LastFMUsername: "alice", From: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), startTime: startTime, outputNameTemplate: tmpl}
+
+			got, err := outputFilename(c, "deleted-scrobbles", ".csv")
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("outputFilename() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if got != tt.expected {
+				t.Errorf("outputFilename() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseOutputNameTemplateUnknownVariable(t *testing.T) {
+	if _, err := parseOutputNameTemplate("{{.Unknown}}"); err == nil {
+		t.Error("parseOutputNameTemplate() must reject unknown variables")
+	}
+}
+
+func TestExportScrobblesToCSVWritesBOM(t *testing.T) {
+	tmpl, err := parseOutputNameTemplate(DefaultOutputNameTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{DataDir: t.TempDir(), CSVDialect: CSVDialectDefault, CSVDelimiter: ",", CSVBOM: true, outputNameTemplate: tmpl, deletedScrobbles: []*scrobble{testExportedScrobble()}}
+
+	exportScrobblesToCSV(c, "deleted-scrobbles")
+	filename, err := outputFilename(c, "deleted-scrobbles", ".csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path.Join(c.DataDir, filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), utf8BOM+"Artist,") {
+		t.Errorf("export starts with %q, expected a byte order mark before the header", string(b[:10]))
+	}
+}
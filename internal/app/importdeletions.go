@@ -0,0 +1,224 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const importedDeletionReason = "imported"
+
+// importedDeletion is a scrobble of a deleted scrobbles export to delete without detection
+type importedDeletion struct {
+	scrobble *scrobble
+	// Delete the last of the scrobbles sharing its timestamp, as chosen when it was detected
+	deleteLast bool
+	found      bool
+}
+
+// deletionWindow is a range of days whose library pages hold imported deletions
+type deletionWindow struct {
+	from      time.Time
+	to        time.Time
+	deletions []*importedDeletion
+}
+
+// readImportedDeletions reads the scrobbles of a deleted scrobbles CSV export, see import-deletions
+func readImportedDeletions(filename string) ([]*importedDeletion, error) {
+	exported, err := readExportedScrobbles(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	deletions := make([]*importedDeletion, 0, len(exported))
+	for _, e := range exported {
+		unix, err := strconv.ParseInt(e.timestampString, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp string %q in %s: %w", e.timestampString, filename, err)
+		}
+		s := &scrobble{
+			artist:          unsanitizeCSVField(e.artist),
+			track:           unsanitizeCSVField(e.track),
+			timestamp:       time.Unix(unix, 0),
+			timestampString: e.timestampString,
+			deleteXPath:     e.deleteXPath,
+		}
+		// Zero in exports of scrobbles without a surviving scrobble
+		if survivingTimestamp, err := time.Parse(time.RFC3339, e.survivingTimestamp); err == nil {
+			s.survivingTimestamp = survivingTimestamp
+		}
+		deletions = append(deletions, &importedDeletion{
+			scrobble:   s,
+			deleteLast: strings.HasSuffix(e.deleteXPath, "[last()]"),
+		})
+	}
+	return deletions, nil
+}
+
+// deletionWorklist groups imported deletions in windows of days from the oldest. The library filters days in the time
+// zone of the user, a day more on each side of a scrobble covers them all. Overlapping windows are merged.
+func deletionWorklist(deletions []*importedDeletion) []deletionWindow {
+	sorted := slices.Clone(deletions)
+	slices.SortStableFunc(sorted, func(d1, d2 *importedDeletion) int {
+		return d1.scrobble.timestamp.Compare(d2.scrobble.timestamp)
+	})
+
+	var windows []deletionWindow
+	for _, d := range sorted {
+		day := d.scrobble.timestamp.UTC().Truncate(24 * time.Hour)
+		from, to := day.AddDate(0, 0, -1), day.AddDate(0, 0, 1)
+		if last := len(windows) - 1; last >= 0 && !from.After(windows[last].to) {
+			windows[last].to = to
+			windows[last].deletions = append(windows[last].deletions, d)
+			continue
+		}
+		windows = append(windows, deletionWindow{from: from, to: to, deletions: []*importedDeletion{d}})
+	}
+	return windows
+}
+
+// importedDeletionKey identifies the scrobble of an imported deletion among the scrobbles of a library page
+func importedDeletionKey(s *scrobble) string {
+	return s.timestampString + "\x00" + s.artist + "\x00" + s.track
+}
+
+// runImportedDeletions deletes the scrobbles of the import-deletions export instead of detecting duplicates
+func runImportedDeletions(ctx context.Context, c *Config) error {
+	if err := login(c.taskCtx, c); err != nil {
+		return fmt.Errorf("failed to login to Last.fm: %w", err)
+	}
+
+	if err := deleteImportedScrobbles(c.taskCtx, c, c.importedDeletions); err != nil {
+		if errors.Is(err, ErrTooManyDeleteFailures) {
+			// Keep the record of the scrobbles deleted before the deletions started failing
+			if finishErr := finishRun(ctx, c); finishErr != nil {
+				slog.Error("Failed to finish run", "error", finishErr)
+			}
+		}
+		return fmt.Errorf("error when deleting imported scrobbles: %w", err)
+	}
+
+	if c.stopCtx.Err() != nil {
+		slog.Warn("Deletions stopped by interrupt")
+	} else {
+		slog.Info("Imported deletions complete!")
+		c.runCompleted = true
+	}
+
+	if err := finishRun(ctx, c); err != nil {
+		return fmt.Errorf("failed to finish run: %w", err)
+	}
+
+	slog.Info("Exiting")
+
+	return nil
+}
+
+// deleteImportedScrobbles looks each imported deletion up in the library pages of its window of days and deletes it
+func deleteImportedScrobbles(ctx context.Context, c *Config, deletions []*importedDeletion) error {
+	from, to := c.From, c.To
+	defer func() {
+		c.From, c.To = from, to
+	}()
+
+	for _, window := range deletionWorklist(deletions) {
+		if c.stopCtx.Err() != nil {
+			return nil
+		}
+		c.From, c.To = window.from, window.to
+		if err := deleteWindowScrobbles(ctx, c, window); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range deletions {
+		if !d.found {
+			c.runStats.importedDeletionsNotFound.Add(1)
+			slog.Warn("Scrobble to delete not found in the library", "artist", d.scrobble.artist, "track", d.scrobble.track, "timestamp", d.scrobble.timestamp)
+		}
+	}
+	return nil
+}
+
+func deleteWindowScrobbles(ctx context.Context, c *Config, window deletionWindow) error {
+	pending := make(map[string][]*importedDeletion, len(window.deletions))
+	for _, d := range window.deletions {
+		key := importedDeletionKey(d.scrobble)
+		pending[key] = append(pending[key], d)
+	}
+
+	totalPages, err := getStartPage(c)
+	if err != nil {
+		if errors.Is(err, ErrNoScrobbles) {
+			return nil
+		}
+		return fmt.Errorf("failed to get library pages from %s to %s: %w", window.from.Format(LastFMQueryDayFormat), window.to.Format(LastFMQueryDayFormat), err)
+	}
+	slog.Info("Looking up scrobbles to delete", "from", window.from.Format(LastFMQueryDayFormat), "to", window.to.Format(LastFMQueryDayFormat), "count", len(window.deletions), "pages", totalPages)
+
+	// From the oldest page, so that deletions never move the scrobbles of the pages left to look up
+	for page := totalPages; page >= 1 && len(pending) > 0; page-- {
+		if c.stopCtx.Err() != nil {
+			return nil
+		}
+		if page != totalPages {
+			if err := pauseBetweenPages(ctx, c); err != nil {
+				return err
+			}
+		}
+
+		scrobbles, err := fetchPage(ctx, c.taskCtx, c, page)
+		if err != nil {
+			return fmt.Errorf("failed to get scrobbles of page %d: %w", page, err)
+		}
+
+		for _, s := range scrobbles {
+			key := importedDeletionKey(&s)
+			candidates := pending[key]
+			if len(candidates) == 0 {
+				continue
+			}
+			if len(candidates) == 1 {
+				delete(pending, key)
+			} else {
+				pending[key] = candidates[1:]
+			}
+
+			deleteImportedScrobble(ctx, c, candidates[0], page)
+			if err := checkDeleteFailures(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func deleteImportedScrobble(ctx context.Context, c *Config, d *importedDeletion, page int) {
+	d.found = true
+	s := d.scrobble
+	s.page = page
+	c.deletedScrobbles = append(c.deletedScrobbles, s)
+	c.runStats.processedScrobbles.Add(1)
+	if !c.canDelete || c.DeleteSample > 0 && c.runStats.sampleDeletions.Load() >= int64(c.DeleteSample) {
+		slog.Info("Scrobble to delete found, not deleted", "artist", s.artist, "track", s.track, "timestamp", s.timestamp, "page", page)
+		if c.canDelete {
+			c.runStats.sampleDryRunScrobbles.Add(1)
+		}
+		return
+	}
+
+	if err := deleteScrobbleWithRetries(ctx, c, s, d.deleteLast, 3); err != nil {
+		slog.Warn("failed to delete scrobble", "error", err)
+		return
+	}
+	countDeletion(c)
+	slog.Info("Scrobble deleted", "reason", importedDeletionReason, "artist", s.artist, "track", s.track, "timestamp", s.timestamp)
+	if c.webhook != nil {
+		c.webhook.notify(s, importedDeletionReason)
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadImportedDeletions(t *testing.T) {
+	tests := []struct {
+		name              string
+		c                 *Config
+		expectedSurviving bool
+		expectedErr       bool
+	}{
+		{name: "default dialect", c: &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ","}, expectedSurviving: true},
+		// Sanitized fields are read back as they were in the library
+		{name: "sanitized", c: &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ",", CSVSanitize: true}, expectedSurviving: true},
+		{name: "semicolon delimiter", c: &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ";"}, expectedSurviving: true},
+		{name: "without surviving scrobble", c: &Config{CSVDialect: CSVDialectDefault, CSVDelimiter: ","}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testExportedScrobble()
+			if !tt.expectedSurviving {
+				s.survivingTimestamp = time.Time{}
+			}
+			var b strings.Builder
+			if err := writeScrobblesCSV(tt.c, &b, []*scrobble{s}); err != nil {
+				t.Fatalf("writeScrobblesCSV() error = %v", err)
+			}
+			filename := path.Join(t.TempDir(), "deleted-scrobbles.csv")
+			if err := os.WriteFile(filename, []byte(b.String()), 0644); err != nil {
+				t.Fatalf("failed to write export: %v", err)
+			}
+
+			got, err := readImportedDeletions(filename)
+			if err != nil {
+				t.Fatalf("readImportedDeletions() error = %v", err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("readImportedDeletions() returned %d deletions, expected 1", len(got))
+			}
+			d := got[0]
+			if d.scrobble.artist != s.artist || d.scrobble.track != s.track || !d.scrobble.timestamp.Equal(s.timestamp) || d.scrobble.timestampString != s.timestampString {
+				t.Errorf("scrobble = %s - %s at %s (%q), expected %s - %s at %s (%q)",
+					d.scrobble.artist, d.scrobble.track, d.scrobble.timestamp, d.scrobble.timestampString, s.artist, s.track, s.timestamp, s.timestampString)
+			}
+			// The XPath of the export targets the last of the scrobbles sharing the timestamp
+			if !d.deleteLast {
+				t.Errorf("deleteLast = false, expected true")
+			}
+			if !d.scrobble.survivingTimestamp.Equal(s.survivingTimestamp) {
+				t.Errorf("survivingTimestamp = %s, expected %s", d.scrobble.survivingTimestamp, s.survivingTimestamp)
+			}
+		})
+	}
+}
+
+func TestReadImportedDeletionsInvalidTimestamp(t *testing.T) {
+	filename := path.Join(t.TempDir(), "deleted-scrobbles.csv")
+	content := "Artist,Track,Timestamp,TimestampString,SurvivingTimestamp,DeleteXPath\nArtist,Song,2024-01-02T03:04:05Z,soon,,//input\n"
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write export: %v", err)
+	}
+	if _, err := readImportedDeletions(filename); err == nil {
+		t.Errorf("readImportedDeletions() error = nil, expected an error")
+	}
+}
+
+func TestDeletionWorklist(t *testing.T) {
+	day := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// Days of the imported deletions after the first day
+		days     []int
+		expected [][2]int
+	}{
+		{name: "single deletion", days: []int{0}, expected: [][2]int{{-1, 1}}},
+		{name: "same day merged", days: []int{0, 0}, expected: [][2]int{{-1, 1}}},
+		{name: "overlapping days merged", days: []int{2, 0}, expected: [][2]int{{-1, 3}}},
+		{name: "distant days apart", days: []int{5, 0}, expected: [][2]int{{-1, 1}, {4, 6}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deletions []*importedDeletion
+			for _, d := range tt.days {
+				deletions = append(deletions, &importedDeletion{scrobble: &scrobble{timestamp: day.AddDate(0, 0, d)}})
+			}
+
+			got := deletionWorklist(deletions)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("deletionWorklist() returned %d windows, expected %d", len(got), len(tt.expected))
+			}
+			firstDay := day.Truncate(24 * time.Hour)
+			deletionCount := 0
+			for i, w := range got {
+				from, to := firstDay.AddDate(0, 0, tt.expected[i][0]), firstDay.AddDate(0, 0, tt.expected[i][1])
+				if !w.from.Equal(from) || !w.to.Equal(to) {
+					t.Errorf("window %d = %s to %s, expected %s to %s", i, w.from, w.to, from, to)
+				}
+				deletionCount += len(w.deletions)
+			}
+			if deletionCount != len(tt.days) {
+				t.Errorf("windows hold %d deletions, expected %d", deletionCount, len(tt.days))
+			}
+		})
+	}
+}
@@ -1,11 +1,13 @@
 package app
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/url"
+	"os"
 	"path"
 	"strconv"
 	"strings"
@@ -15,6 +17,7 @@ import (
 	"github.com/chromedp/chromedp"
 	"github.com/cterence/scrobble-deduplicator/internal/cache"
 	"github.com/go-telegram/bot"
+	"github.com/gobwas/ws"
 	"github.com/michiwend/gomusicbrainz"
 	"github.com/redis/go-redis/v9"
 )
@@ -22,6 +25,116 @@ import (
 func initApp(ctx context.Context, c *Config) error {
 	c.startTime = time.Now()
 
+	if err := initCache(ctx, c); err != nil {
+		return err
+	}
+
+	if c.DisableMusicBrainz {
+		slog.Info("MusicBrainz disabled, using user track durations and cache only")
+	} else {
+		mb, err := newMusicBrainzClient(c)
+		if err != nil {
+			return err
+		}
+		c.mb = mb
+	}
+
+	if c.TelegramBotToken != "" {
+		b, err := bot.New(c.TelegramBotToken)
+		if err != nil {
+			return fmt.Errorf("failed to init telegram bot: %w", err)
+		}
+		c.telegramBot = b
+	}
+
+	if c.progressCadence != (progressCadence{}) {
+		c.progress = newProgressNotifier(c.progressCadence, c.startTime)
+	}
+
+	if c.DeletionWebhookURL != "" {
+		c.webhook = newDeletionWebhook(c.DeletionWebhookURL)
+	}
+
+	if c.Review {
+		c.reviewInput = bufio.NewReader(os.Stdin)
+		c.reviewOutput = os.Stdout
+	}
+
+	if c.ReplayPages {
+		slog.Info("Replaying cached pages, skipping browser start")
+		c.taskCtx = ctx
+		return nil
+	}
+
+	if c.analyzeOnly {
+		slog.Info("Analyzing scrobbles export, skipping browser start")
+		c.taskCtx = ctx
+		return nil
+	}
+
+	var (
+		allocCtx    context.Context
+		allocCancel context.CancelFunc
+	)
+	if c.BrowserURL != "" {
+		browserURL, err := remoteBrowserURL(c.BrowserURL, c.BrowserAuthToken)
+		if err != nil {
+			return err
+		}
+		// chromedp dials the browser websocket with the default dialer, the only way to send it headers
+		if len(c.browserHeaders) > 0 {
+			ws.DefaultDialer.Header = ws.HandshakeHeaderHTTP(c.browserHeaders)
+		}
+		allocCtx, allocCancel = chromedp.NewRemoteAllocator(ctx, browserURL, chromedp.NoModifyURL)
+	} else {
+		opts := append(chromedp.DefaultExecAllocatorOptions[:],
+			chromedp.Flag("headless", !c.BrowserHeadful),
+			chromedp.ModifyCmdFunc(detachBrowserProcess),
+		)
+		allocCtx, allocCancel = chromedp.NewExecAllocator(ctx, opts...)
+	}
+	c.allocCancel = allocCancel
+
+	taskCtx, taskCancel := chromedp.NewContext(
+		allocCtx,
+		chromedp.WithLogf(log.Printf),
+	)
+
+	slog.Info("Starting browser")
+	browserInitTrialCount := 0
+	// ensure that the browser process is started
+	_, err := backoff.Retry(ctx, func() (struct{}, error) {
+		err := chromedp.Run(taskCtx)
+		if err != nil {
+			browserInitTrialCount++
+			slog.Debug("failed to start browser", "error", err, "trial-count", browserInitTrialCount)
+		}
+		return struct{}{}, err
+	}, backoff.WithBackOff(backoff.NewConstantBackOff(3*time.Second)), backoff.WithMaxTries(10))
+	if err != nil {
+		return fmt.Errorf("failed to start browser: %w", err)
+	}
+
+	c.taskCtx = taskCtx
+	c.taskCancel = taskCancel
+
+	if c.BrowserKeepAlive > 0 {
+		startBrowserKeepAlive(c)
+	}
+
+	return nil
+}
+
+func newMusicBrainzClient(c *Config) (MusicBrainzClient, error) {
+	mb, err := gomusicbrainz.NewWS2Client("https://musicbrainz.org", "lastfm-scrobble-deduplicator", "1.0", "https://github.com/cterence")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MusicBrainz client: %w", err)
+	}
+	return newRateLimitedMusicBrainzClient(mb, c.DataDir, !c.dataDirReadOnly), nil
+}
+
+// initCache creates the MusicBrainz API queries cache of the configured type
+func initCache(ctx context.Context, c *Config) error {
 	switch c.CacheType {
 	case "redis":
 		slog.Info("Using Redis cache")
@@ -55,71 +168,56 @@ func initApp(ctx context.Context, c *Config) error {
 		if err != nil {
 			return fmt.Errorf("failed to connect to Redis: %w", err)
 		}
-		c.cache = cache.NewRedis(rdb)
+		c.cache = cache.NewRedis(rdb, trackDurationCacheKeyPrefix, pinnedCacheKeyPrefix)
 	case "file":
+		if c.dataDirReadOnly {
+			slog.Warn("Data directory is read-only, using in-memory cache instead of file cache")
+			c.cache = cache.NewInMemory()
+			break
+		}
 		slog.Info("Using file cache")
 		fileCache, err := cache.NewFile(path.Join(c.DataDir, cache.CacheFileName), cache.FileCacheFlushTicker)
 		if err != nil {
 			return fmt.Errorf("failed to create file cache: %w", err)
 		}
 		c.cache = fileCache
+	case "sqlite":
+		sqlitePath := c.SQLitePath
+		if sqlitePath == "" {
+			if c.dataDirReadOnly {
+				slog.Warn("Data directory is read-only, using in-memory cache instead of sqlite cache")
+				c.cache = cache.NewInMemory()
+				break
+			}
+			sqlitePath = path.Join(c.DataDir, cache.SQLiteFileName)
+		}
+		slog.Info("Using sqlite cache", "path", sqlitePath)
+		sqliteCache, err := cache.NewSQLite(sqlitePath)
+		if err != nil {
+			return fmt.Errorf("failed to create sqlite cache: %w", err)
+		}
+		c.cache = sqliteCache
 	case "inmemory":
 		slog.Info("Using in-memory cache")
 		c.cache = cache.NewInMemory()
 	default:
 		return fmt.Errorf("unsupported cache type: %s", c.CacheType)
 	}
+	return nil
+}
 
-	mb, err := gomusicbrainz.NewWS2Client("https://musicbrainz.org", "lastfm-scrobble-deduplicator", "1.0", "https://github.com/cterence")
-	if err != nil {
-		return fmt.Errorf("failed to create MusicBrainz client: %w", err)
-	}
-	c.mb = mb
-
-	var (
-		allocCtx    context.Context
-		allocCancel context.CancelFunc
-	)
-	if c.BrowserURL != "" {
-		allocCtx, allocCancel = chromedp.NewRemoteAllocator(ctx, c.BrowserURL, chromedp.NoModifyURL)
-	} else {
-		opts := append(chromedp.DefaultExecAllocatorOptions[:],
-			chromedp.Flag("headless", !c.BrowserHeadful),
-		)
-		allocCtx, allocCancel = chromedp.NewExecAllocator(ctx, opts...)
+// remoteBrowserURL adds the authentication token expected by browser services to the websocket URL
+func remoteBrowserURL(browserURL, token string) (string, error) {
+	if token == "" {
+		return browserURL, nil
 	}
-	c.allocCancel = allocCancel
-
-	taskCtx, taskCancel := chromedp.NewContext(
-		allocCtx,
-		chromedp.WithLogf(log.Printf),
-	)
 
-	slog.Info("Starting browser")
-	browserInitTrialCount := 0
-	// ensure that the browser process is started
-	_, err = backoff.Retry(ctx, func() (struct{}, error) {
-		err := chromedp.Run(taskCtx)
-		if err != nil {
-			browserInitTrialCount++
-			slog.Debug("failed to start browser", "error", err, "trial-count", browserInitTrialCount)
-		}
-		return struct{}{}, err
-	}, backoff.WithBackOff(backoff.NewConstantBackOff(3*time.Second)), backoff.WithMaxTries(10))
+	u, err := url.Parse(browserURL)
 	if err != nil {
-		return fmt.Errorf("failed to start browser: %w", err)
+		return "", fmt.Errorf("failed to parse browser URL: %w", err)
 	}
-
-	if c.TelegramBotToken != "" {
-		b, err := bot.New(c.TelegramBotToken)
-		if err != nil {
-			return fmt.Errorf("failed to init telegram bot: %w", err)
-		}
-		c.telegramBot = b
-	}
-
-	c.taskCtx = taskCtx
-	c.taskCancel = taskCancel
-
-	return nil
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
 }
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func touchBrowser(c *Config) {
+	c.browserActivity.Store(time.Now().UnixNano())
+}
+
+// startBrowserKeepAlive evaluates a no-op in the browser once no page was loaded for half of the keep-alive
+// interval, so that remote browser providers do not reclaim the session while track durations are looked up.
+// Checking at half of the interval sends the no-op before the session is idle for the whole interval.
+func startBrowserKeepAlive(c *Config) {
+	touchBrowser(c)
+	maxIdle := c.BrowserKeepAlive / 2
+
+	go func() {
+		ticker := time.NewTicker(maxIdle)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.taskCtx.Done():
+				return
+			case <-ticker.C:
+				idle := time.Since(time.Unix(0, c.browserActivity.Load()))
+				if idle < maxIdle {
+					continue
+				}
+				if err := keepBrowserAlive(c); err != nil {
+					slog.Warn("Failed to keep browser session alive", "idle", idle, "error", err)
+					continue
+				}
+				slog.Debug("Kept idle browser session alive", "idle", idle)
+			}
+		}
+	}()
+}
+
+func keepBrowserAlive(c *Config) error {
+	ctx, cancel := context.WithTimeout(c.taskCtx, browserOperationsTimeout)
+	defer cancel()
+
+	return chromedp.Run(ctx, chromedp.Evaluate("1", nil))
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	lastFMAPITimeout = 10 * time.Second
+	// Error code of the Last.fm API for an unknown track
+	lastFMAPITrackNotFound = 6
+)
+
+var lastFMAPIURL = "https://ws.audioscrobbler.com/2.0/"
+
+var lastFMAPIClient = &http.Client{Timeout: lastFMAPITimeout}
+
+// lastFMTrackInfo is the response of the track.getInfo method, the duration is a string of milliseconds
+type lastFMTrackInfo struct {
+	Track struct {
+		Duration string `json:"duration"`
+	} `json:"track"`
+	Error   int    `json:"error"`
+	Message string `json:"message"`
+}
+
+// getTrackDurationFromLastFMAPI reads the duration of a track from the Last.fm API, unlike scraping the track page
+// it needs no browser. An unknown track or a track without duration returns a zero duration
+func getTrackDurationFromLastFMAPI(ctx context.Context, c *Config, artist, track string) (time.Duration, error) {
+	query := url.Values{}
+	query.Set("method", "track.getInfo")
+	query.Set("api_key", c.LastFMAPIKey)
+	query.Set("artist", artist)
+	query.Set("track", track)
+	query.Set("autocorrect", "1")
+	query.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lastFMAPIURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create Last.fm API request: %w", err)
+	}
+
+	resp, err := lastFMAPIClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query Last.fm API: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error("Failed to close Last.fm API response body", "error", err)
+		}
+	}()
+
+	// Errors are reported in the body, with a 200 status or not
+	var info lastFMTrackInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return 0, fmt.Errorf("failed to decode Last.fm API response (status %d): %w", resp.StatusCode, err)
+	}
+	if info.Error == lastFMAPITrackNotFound {
+		return 0, nil
+	}
+	if info.Error != 0 {
+		return 0, fmt.Errorf("last.fm API error %d: %s", info.Error, info.Message)
+	}
+	if info.Track.Duration == "" {
+		return 0, nil
+	}
+
+	milliseconds, err := strconv.ParseInt(info.Track.Duration, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse Last.fm API track duration: %w", err)
+	}
+	duration := time.Duration(milliseconds) * time.Millisecond
+	slog.Debug("Got duration from Last.fm API", "artist", artist, "track", track, "duration", duration)
+
+	return duration, nil
+}
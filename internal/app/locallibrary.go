@@ -0,0 +1,164 @@
+package app
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+)
+
+var errNotFLAC = errors.New("not a FLAC file")
+
+// localTrack holds the tags of an audio file needed to resolve a scrobble's duration
+type localTrack struct {
+	artist   string
+	title    string
+	duration time.Duration
+}
+
+func localTrackKey(artist, title string) trackKey {
+	return trackKey{strings.ToLower(artist), strings.ToLower(title)}
+}
+
+// indexLocalLibrary reads the tags of the audio files under dir, only FLAC files are supported
+func indexLocalLibrary(dir string) (map[trackKey]time.Duration, error) {
+	index := make(map[trackKey]time.Duration)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(path), ".flac") {
+			return nil
+		}
+
+		t, err := readFLACTrack(path)
+		if err != nil {
+			slog.Debug("Skipping local library file", "file", path, "error", err)
+			return nil
+		}
+		if t.artist == "" || t.title == "" || t.duration <= 0 {
+			slog.Debug("Skipping local library file with missing tags", "file", path)
+			return nil
+		}
+		index[localTrackKey(t.artist, t.title)] = t.duration
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index local library: %w", err)
+	}
+
+	slog.Info("Indexed local library", "dir", dir, "tracks", len(index))
+	return index, nil
+}
+
+func readFLACTrack(path string) (localTrack, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return localTrack{}, err
+	}
+	defer helpers.CloseFile(f)
+
+	return parseFLACTrack(bufio.NewReader(f))
+}
+
+// parseFLACTrack reads the duration from the STREAMINFO block and the artist and title from the Vorbis comments
+func parseFLACTrack(r io.Reader) (localTrack, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return localTrack{}, errNotFLAC
+	}
+
+	var t localTrack
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return localTrack{}, fmt.Errorf("failed to read metadata block header: %w", err)
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return localTrack{}, fmt.Errorf("failed to read metadata block: %w", err)
+		}
+
+		switch blockType {
+		case flacBlockStreamInfo:
+			if length < 18 {
+				return localTrack{}, errors.New("invalid STREAMINFO block")
+			}
+			// 20 bits of sample rate, 3 bits of channels, 5 bits of bits per sample then 36 bits of total samples
+			bits := binary.BigEndian.Uint64(block[10:18])
+			sampleRate := bits >> 44
+			totalSamples := bits & (1<<36 - 1)
+			if sampleRate > 0 {
+				t.duration = time.Duration(totalSamples) * time.Second / time.Duration(sampleRate)
+			}
+		case flacBlockVorbisComment:
+			t.artist, t.title = parseVorbisComment(block)
+		}
+
+		if last {
+			return t, nil
+		}
+	}
+}
+
+func parseVorbisComment(block []byte) (artist, title string) {
+	readString := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(block))
+		if n > len(block)-4 {
+			return "", false
+		}
+		s := string(block[4 : 4+n])
+		block = block[4+n:]
+		return s, true
+	}
+
+	// Vendor string
+	if _, ok := readString(); !ok || len(block) < 4 {
+		return "", ""
+	}
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+
+	for range count {
+		comment, ok := readString()
+		if !ok {
+			break
+		}
+		key, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "ARTIST":
+			if artist == "" {
+				artist = value
+			}
+		case "TITLE":
+			if title == "" {
+				title = value
+			}
+		}
+	}
+	return artist, title
+}
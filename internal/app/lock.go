@@ -0,0 +1,105 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+const (
+	runLockFile         = "run.lock"
+	runLockPollInterval = time.Second
+	// A lock file is written right after being created, one without a PID is only stale once this time elapsed
+	runLockGracePeriod = time.Minute
+)
+
+var ErrAlreadyRunning = errors.New("another run is already in progress")
+
+type runLock struct {
+	path string
+	once sync.Once
+}
+
+// acquireRunLock creates the lock file in the data directory, waiting up to wait for
+// a concurrent run to finish. Locks left behind by a process that no longer exists are removed.
+func acquireRunLock(ctx context.Context, dataDir string, wait time.Duration) (*runLock, error) {
+	lockPath := path.Join(dataDir, runLockFile)
+	deadline := time.Now().Add(wait)
+
+	for {
+		err := createLockFile(lockPath)
+		if err == nil {
+			slog.Debug("Acquired run lock", "file", lockPath)
+			return &runLock{path: lockPath}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		if isStaleLock(lockPath) {
+			slog.Warn("Removing stale run lock", "file", lockPath)
+			if err := os.Remove(lockPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("failed to remove stale lock file: %w", err)
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w (lock file: %s)", ErrAlreadyRunning, lockPath)
+		}
+		slog.Info("Waiting for the other run to finish", "file", lockPath)
+		if err := helpers.SleepContext(ctx, runLockPollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func createLockFile(lockPath string) error {
+	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer helpers.CloseFile(f)
+
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	return err
+}
+
+// isStaleLock reports whether the process holding the lock is gone. On Windows, see processExists.
+func isStaleLock(lockPath string) bool {
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+	b, err := os.ReadFile(lockPath)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		// The lock may have just been created by another run that did not write its PID yet
+		return time.Since(info.ModTime()) > runLockGracePeriod
+	}
+	return !processExists(pid)
+}
+
+// release removes the lock file once, it is released both when the run ends and on a forced exit
+func (l *runLock) release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Failed to release run lock", "file", l.path, "error", err)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIsStaleLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		age      time.Duration
+		expected bool
+	}{
+		{name: "running process", content: strconv.Itoa(os.Getpid()) + "\n", expected: false},
+		{name: "exited process", content: strconv.Itoa(math.MaxInt32) + "\n", expected: true},
+		{name: "PID not written yet", content: "", expected: false},
+		{name: "PID never written", content: "", age: 2 * runLockGracePeriod, expected: true},
+		{name: "garbage within the grace period", content: "not a pid", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lockPath := path.Join(t.TempDir(), runLockFile)
+			if err := os.WriteFile(lockPath, []byte(tt.content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if tt.age > 0 {
+				modTime := time.Now().Add(-tt.age)
+				if err := os.Chtimes(lockPath, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := isStaleLock(lockPath); got != tt.expected {
+				t.Errorf("isStaleLock() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsStaleLockMissingFile(t *testing.T) {
+	if isStaleLock(path.Join(t.TempDir(), runLockFile)) {
+		t.Error("a missing lock file must not be stale")
+	}
+}
+
+func TestAcquireRunLock(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+
+	lock, err := acquireRunLock(ctx, dataDir, 0)
+	if err != nil {
+		t.Fatalf("acquireRunLock() error = %v", err)
+	}
+	if _, err := acquireRunLock(ctx, dataDir, 0); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("second acquireRunLock() error = %v, expected %v", err, ErrAlreadyRunning)
+	}
+
+	lock.release()
+	// The lock of another run acquired after a forced exit must survive a second release
+	other, err := acquireRunLock(ctx, dataDir, 0)
+	if err != nil {
+		t.Fatalf("acquireRunLock() after release error = %v", err)
+	}
+	lock.release()
+	if _, err := os.Stat(other.path); err != nil {
+		t.Errorf("lock file of the other run was removed: %v", err)
+	}
+	other.release()
+	if _, err := os.Stat(other.path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file still exists after release: %v", err)
+	}
+}
+
+func TestAcquireRunLockRemovesStaleLock(t *testing.T) {
+	dataDir := t.TempDir()
+	lockPath := path.Join(dataDir, runLockFile)
+	if err := os.WriteFile(lockPath, []byte(strconv.Itoa(math.MaxInt32)+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := acquireRunLock(context.Background(), dataDir, 0)
+	if err != nil {
+		t.Fatalf("acquireRunLock() error = %v", err)
+	}
+	defer lock.release()
+
+	b, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(b); got != strconv.Itoa(os.Getpid())+"\n" {
+		t.Errorf("lock file content = %q, expected the PID of the test", got)
+	}
+}
+
+func TestAcquireRunLockWaitsForRelease(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+
+	lock, err := acquireRunLock(ctx, dataDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(runLockPollInterval / 2)
+		lock.release()
+	}()
+
+	waiting, err := acquireRunLock(ctx, dataDir, 3*runLockPollInterval)
+	if err != nil {
+		t.Fatalf("acquireRunLock() with wait error = %v", err)
+	}
+	waiting.release()
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package app
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	processQueryLimitedInformation = 0x1000
+	// Exit code of a process that is still running
+	stillActive = 259
+)
+
+// processExists opens the process to read its exit code, a process that can't be opened for lack of rights exists
+func processExists(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer func() {
+		_ = syscall.CloseHandle(h)
+	}()
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -20,7 +21,29 @@ import (
 const lastFMLoginURL = "https://www.last.fm/login"
 const cookieFile = "lastfm-cookies.json"
 
+// login authenticates the browser with the saved session cookie or the credentials, then checks that the session
+// belongs to the target user
 func login(ctx context.Context, c *Config) error {
+	if err := startSession(ctx, c); err != nil {
+		return err
+	}
+	err := verifySessionUser(ctx, c)
+	// A saved session may have been revoked or belong to another account, the credentials log in as the target user
+	if err != nil && c.noLogin && c.LastFMPassword != "" && (errors.Is(err, ErrNotLoggedIn) || errors.Is(err, ErrSessionUserMismatch)) {
+		slog.Warn("Saved session cookie can't be used, logging in with the credentials", "error", err)
+		if err := chromedp.Run(ctx, network.ClearBrowserCookies()); err != nil {
+			return fmt.Errorf("failed to clear cookies: %w", err)
+		}
+		c.noLogin = false
+		if err := loginWithCredentials(ctx, c); err != nil {
+			return err
+		}
+		return verifySessionUser(ctx, c)
+	}
+	return err
+}
+
+func startSession(ctx context.Context, c *Config) error {
 	err := loadCookies(ctx, path.Join(c.DataDir, cookieFile))
 	if err == nil {
 		slog.Info("Loaded session cookie, skipping login")
@@ -30,17 +53,26 @@ func login(ctx context.Context, c *Config) error {
 		return fmt.Errorf("failed to load cookies: %w", err)
 	}
 	c.noLogin = false
+	if c.LastFMPassword == "" {
+		return fmt.Errorf("lastfm-password must be set to log in again: %w", err)
+	}
+	return loginWithCredentials(ctx, c)
+}
 
+// loginWithCredentials logs in on the Last.fm login page and saves the session cookie for the next runs
+func loginWithCredentials(ctx context.Context, c *Config) error {
 	slog.Info("Navigating to Last.fm login page", "url", lastFMLoginURL)
 
 	timeoutCtx, cancel := context.WithTimeout(ctx, browserOperationsTimeout)
 	defer cancel()
 
-	err = chromedp.Run(timeoutCtx,
+	err := chromedp.Run(timeoutCtx,
+		slowMo(c),
 		chromedp.Navigate(lastFMLoginURL),
 		chromedp.ActionFunc(clickConsentBanner),
 		chromedp.SendKeys(`id_username_or_email`, strings.ToLower(c.LastFMUsername), chromedp.ByID),
 		chromedp.SendKeys(`id_password`, c.LastFMPassword, chromedp.ByID),
+		slowMo(c),
 		chromedp.Click(`//div[@class='form-submit']/button[@class='btn-primary']`, chromedp.BySearch),
 		chromedp.WaitVisible(`//h1[@class='header-title']/a`, chromedp.BySearch),
 	)
@@ -49,7 +81,9 @@ func login(ctx context.Context, c *Config) error {
 	}
 
 	// Save cookies for reuse
-	if err := saveCookies(timeoutCtx, cookieFile, c.DataDir); err != nil {
+	if c.dataDirReadOnly {
+		slog.Debug("Data directory is read-only, not saving cookies")
+	} else if err := saveCookies(timeoutCtx, cookieFile, c.DataDir); err != nil {
 		slog.Warn("Could not save cookies", "err", err)
 	} else {
 		slog.Info("Saved login cookies to " + cookieFile)
@@ -59,6 +93,61 @@ func login(ctx context.Context, c *Config) error {
 	return nil
 }
 
+var (
+	ErrSessionUserMismatch = errors.New("the Last.fm session belongs to another user")
+	ErrNotLoggedIn         = errors.New("not logged in to Last.fm")
+)
+
+// Profile link of the logged in user in the header of every Last.fm page
+const sessionUserLinksJS = `[...document.querySelectorAll('.masthead a[href^="/user/"]')].map((e) => e.getAttribute('href'))`
+
+// verifySessionUser checks that the browser is logged in as the target user, a session cookie saved for another
+// account would otherwise delete scrobbles of the wrong library or fail to delete any
+func verifySessionUser(ctx context.Context, c *Config) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, browserOperationsTimeout)
+	defer cancel()
+
+	var links []string
+	err := chromedp.Run(timeoutCtx,
+		chromedp.Navigate("https://www.last.fm/"),
+		chromedp.WaitReady(`.masthead`, chromedp.ByQuery),
+		chromedp.Evaluate(sessionUserLinksJS, &links),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to get Last.fm session user: %w", err)
+	}
+
+	return checkSessionUser(c, links)
+}
+
+// checkSessionUser compares the user of the header profile links with the target user, Last.fm usernames are case-insensitive
+func checkSessionUser(c *Config, links []string) error {
+	sessionUser := sessionUsername(links)
+	if sessionUser == "" {
+		return fmt.Errorf("%w, set lastfm-password or delete %s to log in again", ErrNotLoggedIn, path.Join(c.DataDir, cookieFile))
+	}
+	if !strings.EqualFold(sessionUser, c.LastFMUsername) {
+		return fmt.Errorf("%w: logged in as %s instead of %s, set lastfm-password or delete %s to log in again", ErrSessionUserMismatch, sessionUser, c.LastFMUsername, path.Join(c.DataDir, cookieFile))
+	}
+	slog.Debug("Verified Last.fm session user", "username", sessionUser)
+	return nil
+}
+
+// sessionUsername returns the user of the first /user/<name> profile link
+func sessionUsername(links []string) string {
+	for _, link := range links {
+		rest, found := strings.CutPrefix(link, "/user/")
+		if !found {
+			continue
+		}
+		name, _, _ := strings.Cut(rest, "/")
+		if name, err := url.PathUnescape(name); err == nil && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
 func getCookies(ctx context.Context) ([]*network.Cookie, error) {
 	var cookies []*network.Cookie
 	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
@@ -88,6 +177,12 @@ func saveCookies(ctx context.Context, filename string, dataDir string) error {
 	return json.NewEncoder(f).Encode(cookies)
 }
 
+// sessionCookieSaved reports whether a previous login saved its session cookie, it may have expired since
+func sessionCookieSaved(dataDir string) bool {
+	_, err := os.Stat(path.Join(dataDir, cookieFile))
+	return err == nil
+}
+
 var ErrSessionCookieExpired = errors.New("cookie expired")
 var ErrNoCookieFile = errors.New("no cookie file")
 
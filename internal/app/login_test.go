@@ -0,0 +1,55 @@
+package app
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSessionUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		links    []string
+		expected string
+	}{
+		{name: "no links", expected: ""},
+		{name: "profile link", links: []string{"/user/alice"}, expected: "alice"},
+		{name: "profile subpage", links: []string{"/user/alice/library"}, expected: "alice"},
+		{name: "escaped name", links: []string{"/user/al%20ice"}, expected: "al ice"},
+		{name: "first profile link", links: []string{"/music", "/user/alice", "/user/bob"}, expected: "alice"},
+		{name: "empty name skipped", links: []string{"/user/", "/user/alice"}, expected: "alice"},
+		{name: "invalid escape skipped", links: []string{"/user/%zz", "/user/alice"}, expected: "alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionUsername(tt.links); got != tt.expected {
+				t.Errorf("sessionUsername(%q) = %q, expected %q", tt.links, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCheckSessionUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		links         []string
+		expectedErr   bool
+		expectedIsErr error
+	}{
+		{name: "target user", links: []string{"/user/alice"}},
+		{name: "usernames are case-insensitive", links: []string{"/user/Alice"}},
+		{name: "logged out", links: nil, expectedErr: true, expectedIsErr: ErrNotLoggedIn},
+		{name: "other user", links: []string{"/user/bob"}, expectedErr: true, expectedIsErr: ErrSessionUserMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{DataDir: t.TempDir(), LastFMUsername: "alice"}
+			err := checkSessionUser(c, tt.links)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("checkSessionUser() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if tt.expectedIsErr != nil && !errors.Is(err, tt.expectedIsErr) {
+				t.Errorf("checkSessionUser() error = %v, expected %v", err, tt.expectedIsErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/michiwend/gomusicbrainz"
+)
+
+const (
+	mbidMapFile          = "mbid-map.yaml"
+	pinnedCacheKeyPrefix = "mbid:"
+)
+
+// mbidByTrackByArtist maps the tracks of an artist to the MusicBrainz recording pinned for them
+type mbidByTrackByArtist map[string]map[string]string
+
+// getMBIDMap reads the recordings pinned to tracks, which are used instead of searching MusicBrainz
+func getMBIDMap(dataDir string) (mbidByTrackByArtist, error) {
+	b, err := os.ReadFile(path.Join(dataDir, mbidMapFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read MBID map file: %w", err)
+	}
+
+	var mbidMap mbidByTrackByArtist
+	if err := yaml.Unmarshal(b, &mbidMap); err != nil {
+		return nil, fmt.Errorf("failed to parse MBID map file: %w", err)
+	}
+	return mbidMap, nil
+}
+
+func writeMBIDMap(dataDir string, mbidMap mbidByTrackByArtist) error {
+	b, err := yaml.Marshal(mbidMap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal MBID map: %w", err)
+	}
+	if err := os.WriteFile(path.Join(dataDir, mbidMapFile), b, 0644); err != nil {
+		return fmt.Errorf("failed to write MBID map file: %w", err)
+	}
+	return nil
+}
+
+func pinnedMBID(c *Config, artist, track string) (gomusicbrainz.MBID, bool) {
+	mbid := c.mbidMap[artist][track]
+	return gomusicbrainz.MBID(mbid), mbid != ""
+}
+
+// pinnedTrackDurationCacheKey is the cache key of a pinned recording's duration, it differs from the search
+// query key so that a duration cached from a fuzzy search is never used for a pinned track
+func pinnedTrackDurationCacheKey(mbid gomusicbrainz.MBID) string {
+	return pinnedCacheKeyPrefix + string(mbid)
+}
+
+func getPinnedRecordingDuration(c *Config, mbid gomusicbrainz.MBID) (time.Duration, error) {
+	recording, err := c.mb.LookupRecording(mbid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lookup MusicBrainz recording: %w", err)
+	}
+	return getRecordingDuration(c, recording)
+}
+
+// PinTrack records the MusicBrainz recording of a track in the MBID map and caches its duration
+func PinTrack(ctx context.Context, c *Config, artist, track, mbid string) error {
+	if c.DisableMusicBrainz {
+		return errors.New("pinning a track requires MusicBrainz to resolve its duration")
+	}
+
+	if err := ensureDataDir(c.DataDir); err != nil {
+		return err
+	}
+
+	mb, err := newMusicBrainzClient(c)
+	if err != nil {
+		return err
+	}
+	c.mb = mb
+
+	duration, err := getPinnedRecordingDuration(c, gomusicbrainz.MBID(mbid))
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return fmt.Errorf("MusicBrainz recording %s has no length", mbid)
+	}
+
+	mbidMap, err := getMBIDMap(c.DataDir)
+	if err != nil {
+		return err
+	}
+	if mbidMap == nil {
+		mbidMap = make(mbidByTrackByArtist)
+	}
+	if mbidMap[artist] == nil {
+		mbidMap[artist] = make(map[string]string)
+	}
+	mbidMap[artist][track] = mbid
+	if err := writeMBIDMap(c.DataDir, mbidMap); err != nil {
+		return err
+	}
+	slog.Info("Pinned MusicBrainz recording", "artist", artist, "track", track, "mbid", mbid, "duration", duration)
+
+	// The inmemory cache is lost at exit, the next run looks the recording up again
+	if c.CacheType == "inmemory" {
+		return nil
+	}
+	if err := initCache(ctx, c); err != nil {
+		return err
+	}
+	defer c.cache.Close()
+	cacheTrackDuration(ctx, c, pinnedTrackDurationCacheKey(gomusicbrainz.MBID(mbid)), artist, track, duration)
+	return nil
+}
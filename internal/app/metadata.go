@@ -0,0 +1,98 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const exportMetadataExtension = ".meta.json"
+
+// exportMetadata describes the run that produced an export, only the settings affecting detection are recorded so
+// that no credential ends up next to the export
+type exportMetadata struct {
+	File        string    `json:"file"`
+	Version     string    `json:"version"`
+	StartedAt   time.Time `json:"startedAt"`
+	ElapsedTime string    `json:"elapsedTime"`
+	Username    string    `json:"username"`
+	From        string    `json:"from,omitempty"`
+	To          string    `json:"to,omitempty"`
+	Delete      bool      `json:"delete"`
+
+	DuplicateThreshold     int    `json:"duplicateThreshold"`
+	CompleteThreshold      int    `json:"completeThreshold"`
+	FullPlayAt             int    `json:"fullPlayAt"`
+	IncompleteDeleteTarget string `json:"incompleteDeleteTarget"`
+	Keep                   string `json:"keep"`
+	IncludeEqualTimestamps bool   `json:"includeEqualTimestamps"`
+	MaxDuplicateGap        string `json:"maxDuplicateGap"`
+	MinReplayGap           string `json:"minReplayGap"`
+	SessionGap             string `json:"sessionGap"`
+	CSVDialect             string `json:"csvDialect"`
+
+	Stats exportMetadataStats `json:"stats"`
+}
+
+type exportMetadataStats struct {
+	ProcessedScrobbles    int64 `json:"processedScrobbles"`
+	DetectedScrobbles     int   `json:"detectedScrobbles"`
+	CacheHits             int64 `json:"cacheHits"`
+	CacheMisses           int64 `json:"cacheMisses"`
+	UnknownTrackDurations int64 `json:"unknownTrackDurations"`
+	SkippedScrobbles      int64 `json:"skippedScrobbles"`
+	DeleteFails           int64 `json:"deleteFails"`
+}
+
+func newExportMetadata(c *Config, file string) exportMetadata {
+	m := exportMetadata{
+		File:                   file,
+		Version:                c.Version,
+		StartedAt:              c.startTime,
+		ElapsedTime:            c.runStats.elapsedTime.Truncate(time.Millisecond).String(),
+		Username:               c.LastFMUsername,
+		Delete:                 c.canDelete,
+		DuplicateThreshold:     c.DuplicateThreshold,
+		CompleteThreshold:      c.CompleteThreshold,
+		FullPlayAt:             c.FullPlayAt,
+		IncompleteDeleteTarget: c.IncompleteDeleteTarget,
+		Keep:                   c.Keep,
+		IncludeEqualTimestamps: c.IncludeEqualTimestamps,
+		MaxDuplicateGap:        c.MaxDuplicateGap.String(),
+		MinReplayGap:           c.MinReplayGap.String(),
+		SessionGap:             c.SessionGap.String(),
+		CSVDialect:             c.CSVDialect,
+		Stats: exportMetadataStats{
+			ProcessedScrobbles:    c.runStats.processedScrobbles.Load(),
+			DetectedScrobbles:     len(c.deletedScrobbles),
+			CacheHits:             c.runStats.cacheHits.Load(),
+			CacheMisses:           c.runStats.cacheMisses.Load(),
+			UnknownTrackDurations: c.runStats.unknownTrackDurationsCount.Load(),
+			SkippedScrobbles:      c.runStats.skippedScrobbles(),
+			DeleteFails:           c.runStats.scrobbleDeleteFails.Load(),
+		},
+	}
+	if !c.From.IsZero() {
+		m.From = c.From.Format(LastFMQueryDayFormat)
+	}
+	if !c.To.IsZero() {
+		m.To = c.To.Format(LastFMQueryDayFormat)
+	}
+	return m
+}
+
+// writeExportMetadata writes the metadata of an export next to it, as <export file>.meta.json
+func writeExportMetadata(c *Config, exportPath string) (string, error) {
+	b, err := json.MarshalIndent(newExportMetadata(c, path.Base(exportPath)), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal export metadata: %w", err)
+	}
+
+	metadataPath := exportPath + exportMetadataExtension
+	if err := os.WriteFile(metadataPath, append(b, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write export metadata: %w", err)
+	}
+	return metadataPath, nil
+}
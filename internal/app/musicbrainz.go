@@ -0,0 +1,274 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+	"github.com/goccy/go-yaml"
+	"github.com/michiwend/gomusicbrainz"
+)
+
+// MusicBrainzClient is the subset of the MusicBrainz API used to resolve track durations
+type MusicBrainzClient interface {
+	SearchRecording(searchTerm string, limit, offset int) (*gomusicbrainz.RecordingSearchResponse, error)
+	LookupRecording(id gomusicbrainz.MBID, inc ...string) (*gomusicbrainz.Recording, error)
+	SearchRelease(searchTerm string, limit, offset int) (*gomusicbrainz.ReleaseSearchResponse, error)
+	LookupRelease(id gomusicbrainz.MBID, inc ...string) (*gomusicbrainz.Release, error)
+}
+
+var _ MusicBrainzClient = (*gomusicbrainz.WS2Client)(nil)
+
+const (
+	artistAliasesFile = "artist-aliases.yaml"
+	// Number of releases looked up for a track length when a recording has none
+	maxReleaseLookups = 3
+)
+
+// getArtistAliases reads the mapping of Last.fm artist names to their MusicBrainz names
+func getArtistAliases(dataDir string) (map[string]string, error) {
+	b, err := os.ReadFile(path.Join(dataDir, artistAliasesFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read artist aliases file: %w", err)
+	}
+
+	var aliases map[string]string
+	if err := yaml.Unmarshal(b, &aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse artist aliases file: %w", err)
+	}
+	slog.Info("Loaded artist aliases", "count", len(aliases))
+	return aliases, nil
+}
+
+// musicBrainzArtist returns the name of a Last.fm artist on MusicBrainz, its alias when it has one
+func musicBrainzArtist(c *Config, artist string) string {
+	if alias, found := c.artistAliases[artist]; found {
+		return alias
+	}
+	return artist
+}
+
+type trackKey struct {
+	artist string
+	track  string
+}
+
+// musicBrainzLookup is the result of the MusicBrainz lookup of a track duration
+type musicBrainzLookup struct {
+	duration time.Duration
+	// The recording was chosen with the play gaps of the current page, the gaps of another page may choose another one
+	fromPlayGaps bool
+	err          error
+}
+
+// lookupMusicBrainzTrackDuration abandons the MusicBrainz lookup of a track once ctx is done. The MusicBrainz client
+// takes no context, its request completes in the background and its result is dropped.
+func lookupMusicBrainzTrackDuration(ctx context.Context, c *Config, artist, track string) (musicBrainzLookup, error) {
+	// Read before the lookup goroutine starts, the gaps of the next page replace them
+	gaps := c.pageTrackGaps[trackKey{artist, track}]
+	results := make(chan musicBrainzLookup, 1)
+	go func() {
+		duration, fromPlayGaps, err := getTrackDurationFromMusicBrainz(c, artist, track, gaps)
+		results <- musicBrainzLookup{duration: duration, fromPlayGaps: fromPlayGaps, err: err}
+	}()
+
+	select {
+	case r := <-results:
+		return r, r.err
+	case <-ctx.Done():
+		return musicBrainzLookup{}, backoff.Permanent(ctx.Err())
+	}
+}
+
+// getTrackDurationFromMusicBrainz searches the recording of a track, gaps are its play gaps on the current page used
+// to choose between recordings. fromPlayGaps reports whether the gaps changed the chosen recording.
+func getTrackDurationFromMusicBrainz(c *Config, artist, track string, gaps []time.Duration) (duration time.Duration, fromPlayGaps bool, err error) {
+	if mbid, found := pinnedMBID(c, artist, track); found {
+		slog.Debug("Using pinned MusicBrainz recording", "artist", artist, "track", track, "mbid", mbid)
+		duration, err := getPinnedRecordingDuration(c, mbid)
+		return duration, false, err
+	}
+
+	queryArtist := musicBrainzArtist(c, artist)
+	if queryArtist != artist {
+		slog.Info("Using artist alias for MusicBrainz query", "artist", artist, "alias", queryArtist)
+	}
+
+	searchArtist, searchTrack := normalizeForSearch(queryArtist, track)
+	query := fmt.Sprintf(`artist:"%s" AND recording:"%s"`, searchArtist, searchTrack)
+	resp, err := c.mb.SearchRecording(query, -1, -1)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to search MusicBrainz: %w", err)
+	}
+
+	if len(resp.Recordings) == 0 {
+		// Not found, don't return an error and skip setting cache
+		return 0, false, nil
+	}
+
+	recording := resp.Recordings[0]
+	if len(resp.Recordings) > 1 {
+		slog.Debug("Multiple MusicBrainz recordings found", "query", query, "count", len(resp.Recordings))
+		for i, rec := range resp.Recordings {
+			slog.Debug("Recording", "index", i, "artist", rec.ArtistCredit.NameCredits, "track", rec.Title, "duration", rec.Length, "score", resp.Scores[rec])
+		}
+		recording, fromPlayGaps = selectRecording(bestScoredRecordings(exactTitleRecordings(resp.Recordings, track), resp.Scores), gaps)
+	}
+	if !isExactTitle(recording.Title, track) {
+		slog.Info("Using MusicBrainz recording with a different title, its duration may be of another version", "artist", artist, "track", track, "recording", recording.Title)
+	}
+	duration, err = getRecordingDuration(c, recording)
+	return duration, fromPlayGaps, err
+}
+
+// getRecordingDuration returns the length of a recording, or of its track on a release when the recording has none
+func getRecordingDuration(c *Config, recording *gomusicbrainz.Recording) (time.Duration, error) {
+	length := recording.Length
+	if length == 0 {
+		var err error
+		length, err = getReleaseTrackLength(c, recording.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	duration := time.Duration(length) * time.Millisecond
+	return duration, nil
+}
+
+// getReleaseTrackLength returns the length of a recording's track on one of its releases,
+// or 0 if none of the looked up releases has a length for it
+func getReleaseTrackLength(c *Config, recordingID gomusicbrainz.MBID) (int, error) {
+	resp, err := c.mb.SearchRelease(fmt.Sprintf(`rid:"%s"`, recordingID), maxReleaseLookups, -1)
+	if err != nil {
+		return 0, fmt.Errorf("failed to search MusicBrainz releases: %w", err)
+	}
+
+	for _, rel := range resp.Releases {
+		release, err := c.mb.LookupRelease(rel.ID, "recordings")
+		if err != nil {
+			return 0, fmt.Errorf("failed to lookup MusicBrainz release: %w", err)
+		}
+		for _, medium := range release.Mediums {
+			for _, t := range medium.Tracks {
+				if t.Recording.ID == recordingID && t.Length > 0 {
+					slog.Debug("Using release track length for recording without length", "recording", recordingID, "release", release.Title, "length", t.Length)
+					return t.Length, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// getTrackGaps returns, for each track of a page, the time elapsed between each of its scrobbles
+// and the next scrobble of a different track, which is how long the track was actually played
+func getTrackGaps(scrobbles []scrobble) map[trackKey][]time.Duration {
+	gaps := make(map[trackKey][]time.Duration)
+	for i := 0; i < len(scrobbles)-1; i++ {
+		current, next := scrobbles[i], scrobbles[i+1]
+		if current.artist == next.artist && current.track == next.track {
+			continue
+		}
+		key := trackKey{current.artist, current.track}
+		gaps[key] = append(gaps[key], next.timestamp.Sub(current.timestamp))
+	}
+	return gaps
+}
+
+// exactTitleRecordings returns the recordings titled like the track, ignoring the featured artists and remaster suffixes,
+// or all of them when none is, so that a search matching "Song (Radio Edit)" for "Song" is only used as a last resort
+func exactTitleRecordings(recordings []*gomusicbrainz.Recording, track string) []*gomusicbrainz.Recording {
+	exact := slices.DeleteFunc(slices.Clone(recordings), func(rec *gomusicbrainz.Recording) bool {
+		return !isExactTitle(rec.Title, track)
+	})
+	if len(exact) == 0 {
+		return recordings
+	}
+	return exact
+}
+
+func isExactTitle(title, track string) bool {
+	return strings.EqualFold(normalizeName(title), normalizeName(track))
+}
+
+// bestScoredRecordings returns the recordings with a length that have the highest search score,
+// or all of them when none has a length so that the length is looked up on the releases of the first one
+func bestScoredRecordings(recordings []*gomusicbrainz.Recording, scores gomusicbrainz.ScoreMap) []*gomusicbrainz.Recording {
+	var (
+		best      []*gomusicbrainz.Recording
+		bestScore int
+	)
+	for _, rec := range recordings {
+		if rec.Length <= 0 {
+			continue
+		}
+		score := scores[rec]
+		switch {
+		case len(best) == 0 || score > bestScore:
+			best, bestScore = []*gomusicbrainz.Recording{rec}, score
+		case score == bestScore:
+			best = append(best, rec)
+		}
+	}
+	if len(best) == 0 {
+		return recordings
+	}
+	return best
+}
+
+// selectRecording picks the recording whose length is the closest to the median observed play gap,
+// falling back to the recording of median length when there is no gap to compare to, which avoids live or extended versions.
+// fromPlayGaps reports whether the gaps picked another recording than the median length one.
+func selectRecording(recordings []*gomusicbrainz.Recording, gaps []time.Duration) (selected *gomusicbrainz.Recording, fromPlayGaps bool) {
+	medianLength := medianLengthRecording(recordings)
+	if len(gaps) == 0 {
+		return medianLength, false
+	}
+
+	sortedGaps := slices.Clone(gaps)
+	slices.Sort(sortedGaps)
+	medianGap := sortedGaps[len(sortedGaps)/2]
+
+	var selectedDiff time.Duration
+	for _, rec := range recordings {
+		if rec.Length <= 0 {
+			continue
+		}
+		diff := (time.Duration(rec.Length)*time.Millisecond - medianGap).Abs()
+		if selected == nil || diff < selectedDiff {
+			selected, selectedDiff = rec, diff
+		}
+	}
+	if selected == nil {
+		return recordings[0], false
+	}
+
+	slog.Debug("Selected MusicBrainz recording using observed play gaps", "track", selected.Title, "duration", selected.Length, "medianGap", medianGap)
+	return selected, selected != medianLength
+}
+
+// medianLengthRecording returns the recording of median length, or the first recording when none has a length
+func medianLengthRecording(recordings []*gomusicbrainz.Recording) *gomusicbrainz.Recording {
+	withLength := slices.DeleteFunc(slices.Clone(recordings), func(rec *gomusicbrainz.Recording) bool {
+		return rec.Length <= 0
+	})
+	if len(withLength) == 0 {
+		return recordings[0]
+	}
+	slices.SortStableFunc(withLength, func(a, b *gomusicbrainz.Recording) int {
+		return cmp.Compare(a.Length, b.Length)
+	})
+	return withLength[len(withLength)/2]
+}
@@ -0,0 +1,283 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/michiwend/gomusicbrainz"
+)
+
+var errMusicBrainzNotFound = errors.New("not found")
+
+// fakeMusicBrainzClient answers searches with fixed recordings and records the queries
+type fakeMusicBrainzClient struct {
+	recordings []*gomusicbrainz.Recording
+	scores     gomusicbrainz.ScoreMap
+	releases   []*gomusicbrainz.Release
+	queries    []string
+}
+
+var _ MusicBrainzClient = (*fakeMusicBrainzClient)(nil)
+
+func (f *fakeMusicBrainzClient) SearchRecording(searchTerm string, _, _ int) (*gomusicbrainz.RecordingSearchResponse, error) {
+	f.queries = append(f.queries, searchTerm)
+	return &gomusicbrainz.RecordingSearchResponse{Recordings: f.recordings, Scores: f.scores}, nil
+}
+
+func (f *fakeMusicBrainzClient) LookupRecording(id gomusicbrainz.MBID, _ ...string) (*gomusicbrainz.Recording, error) {
+	for _, rec := range f.recordings {
+		if rec.ID == id {
+			return rec, nil
+		}
+	}
+	return nil, errMusicBrainzNotFound
+}
+
+func (f *fakeMusicBrainzClient) SearchRelease(searchTerm string, _, _ int) (*gomusicbrainz.ReleaseSearchResponse, error) {
+	f.queries = append(f.queries, searchTerm)
+	return &gomusicbrainz.ReleaseSearchResponse{Releases: f.releases}, nil
+}
+
+func (f *fakeMusicBrainzClient) LookupRelease(id gomusicbrainz.MBID, _ ...string) (*gomusicbrainz.Release, error) {
+	for _, rel := range f.releases {
+		if rel.ID == id {
+			return rel, nil
+		}
+	}
+	return nil, errMusicBrainzNotFound
+}
+
+func testRecording(id, title string, length time.Duration) *gomusicbrainz.Recording {
+	return &gomusicbrainz.Recording{ID: gomusicbrainz.MBID(id), Title: title, Length: int(length.Milliseconds())}
+}
+
+func recordingIDs(recordings []*gomusicbrainz.Recording) string {
+	ids := make([]string, 0, len(recordings))
+	for _, rec := range recordings {
+		ids = append(ids, string(rec.ID))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestSelectRecording(t *testing.T) {
+	studio := testRecording("studio", "Song", 3*time.Minute)
+	edit := testRecording("edit", "Song", 2*time.Minute+30*time.Second)
+	live := testRecording("live", "Song", 6*time.Minute)
+	noLength := testRecording("no-length", "Song", 0)
+	tests := []struct {
+		name                 string
+		recordings           []*gomusicbrainz.Recording
+		gaps                 []time.Duration
+		expected             string
+		expectedFromPlayGaps bool
+	}{
+		{name: "median length without gaps", recordings: []*gomusicbrainz.Recording{live, edit, studio}, expected: "studio"},
+		{name: "median length ignores recordings without length", recordings: []*gomusicbrainz.Recording{noLength, live, studio}, expected: "live"},
+		{name: "closest to the median gap", recordings: []*gomusicbrainz.Recording{studio, live}, gaps: []time.Duration{6 * time.Minute, 5 * time.Minute, 20 * time.Minute}, expected: "live"},
+		{name: "gaps of a short edit", recordings: []*gomusicbrainz.Recording{live, studio, edit}, gaps: []time.Duration{2*time.Minute + 35*time.Second}, expected: "edit", expectedFromPlayGaps: true},
+		{name: "gaps agreeing with the median length", recordings: []*gomusicbrainz.Recording{live, studio, edit}, gaps: []time.Duration{3 * time.Minute}, expected: "studio"},
+		{name: "first recording when none has a length", recordings: []*gomusicbrainz.Recording{noLength, testRecording("other", "Song", 0)}, gaps: []time.Duration{time.Minute}, expected: "no-length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fromPlayGaps := selectRecording(tt.recordings, tt.gaps)
+			if string(got.ID) != tt.expected {
+				t.Errorf("selectRecording() = %s, expected %s", got.ID, tt.expected)
+			}
+			if fromPlayGaps != tt.expectedFromPlayGaps {
+				t.Errorf("selectRecording() fromPlayGaps = %v, expected %v", fromPlayGaps, tt.expectedFromPlayGaps)
+			}
+		})
+	}
+}
+
+func TestBestScoredRecordings(t *testing.T) {
+	best := testRecording("best", "Song", 3*time.Minute)
+	tie := testRecording("tie", "Song", 4*time.Minute)
+	worse := testRecording("worse", "Song", 3*time.Minute)
+	bestNoLength := testRecording("best-no-length", "Song", 0)
+	tests := []struct {
+		name       string
+		recordings []*gomusicbrainz.Recording
+		scores     gomusicbrainz.ScoreMap
+		expected   string
+	}{
+		{name: "highest score", recordings: []*gomusicbrainz.Recording{worse, best}, scores: gomusicbrainz.ScoreMap{worse: 80, best: 100}, expected: "best"},
+		{name: "ties", recordings: []*gomusicbrainz.Recording{best, worse, tie}, scores: gomusicbrainz.ScoreMap{best: 100, worse: 80, tie: 100}, expected: "best,tie"},
+		{name: "recordings without length are skipped", recordings: []*gomusicbrainz.Recording{bestNoLength, worse}, scores: gomusicbrainz.ScoreMap{bestNoLength: 100, worse: 80}, expected: "worse"},
+		{name: "all when none has a length", recordings: []*gomusicbrainz.Recording{bestNoLength}, scores: gomusicbrainz.ScoreMap{bestNoLength: 100}, expected: "best-no-length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recordingIDs(bestScoredRecordings(tt.recordings, tt.scores)); got != tt.expected {
+				t.Errorf("bestScoredRecordings() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsExactTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		track    string
+		expected bool
+	}{
+		{title: "Song", track: "Song", expected: true},
+		{title: "song", track: "Song", expected: true},
+		{title: "Song (feat. Other)", track: "Song", expected: true},
+		{title: "Song", track: "Song - 2011 Remaster", expected: true},
+		{title: "Song (Remastered 2011)", track: "Song", expected: true},
+		{title: "Don’t Stop", track: "Don't Stop", expected: true},
+		{title: "Song (Radio Edit)", track: "Song", expected: false},
+		{title: "Song Remix", track: "Song", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title+"/"+tt.track, func(t *testing.T) {
+			if got := isExactTitle(tt.title, tt.track); got != tt.expected {
+				t.Errorf("isExactTitle(%q, %q) = %v, expected %v", tt.title, tt.track, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExactTitleRecordings(t *testing.T) {
+	edit := testRecording("edit", "Song (Radio Edit)", 3*time.Minute)
+	remaster := testRecording("remaster", "Song (2011 Remaster)", 4*time.Minute)
+	tests := []struct {
+		name       string
+		recordings []*gomusicbrainz.Recording
+		expected   string
+	}{
+		{name: "exact titles only", recordings: []*gomusicbrainz.Recording{edit, remaster}, expected: "remaster"},
+		{name: "all when none is exact", recordings: []*gomusicbrainz.Recording{edit}, expected: "edit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recordingIDs(exactTitleRecordings(tt.recordings, "Song")); got != tt.expected {
+				t.Errorf("exactTitleRecordings() = %s, expected %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeForSearch(t *testing.T) {
+	tests := []struct {
+		artist         string
+		track          string
+		expectedArtist string
+		expectedTrack  string
+	}{
+		{artist: "Artist", track: "Song", expectedArtist: "Artist", expectedTrack: "Song"},
+		{artist: "Artist feat. Other", track: "Song (feat. Other)", expectedArtist: "Artist", expectedTrack: "Song"},
+		{artist: "Artist", track: "Song - Remastered 2011", expectedArtist: "Artist", expectedTrack: "Song"},
+		{artist: "AC/DC", track: "T.N.T.", expectedArtist: `AC\/DC`, expectedTrack: "T.N.T."},
+		{artist: "Artist", track: `Song "Live"`, expectedArtist: "Artist", expectedTrack: `Song \"Live\"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.artist+"/"+tt.track, func(t *testing.T) {
+			artist, track := normalizeForSearch(tt.artist, tt.track)
+			if artist != tt.expectedArtist || track != tt.expectedTrack {
+				t.Errorf("normalizeForSearch() = %q, %q, expected %q, %q", artist, track, tt.expectedArtist, tt.expectedTrack)
+			}
+		})
+	}
+}
+
+func TestGetTrackDurationFromMusicBrainz(t *testing.T) {
+	studio := testRecording("studio", "Song", 3*time.Minute)
+	live := testRecording("live", "Song", 6*time.Minute)
+	edit := testRecording("edit", "Song (Radio Edit)", 2*time.Minute)
+	noLength := testRecording("no-length", "Song", 0)
+	tests := []struct {
+		name          string
+		mb            *fakeMusicBrainzClient
+		artistAliases map[string]string
+		mbidMap       mbidByTrackByArtist
+		gaps          []time.Duration
+		expected      time.Duration
+		expectedQuery string
+	}{
+		{
+			name:          "not found",
+			mb:            &fakeMusicBrainzClient{},
+			expectedQuery: `artist:"Artist" AND recording:"Song"`,
+		},
+		{
+			name:     "single recording",
+			mb:       &fakeMusicBrainzClient{recordings: []*gomusicbrainz.Recording{edit}},
+			expected: 2 * time.Minute,
+		},
+		{
+			name:     "exact title preferred over a higher scored edit",
+			mb:       &fakeMusicBrainzClient{recordings: []*gomusicbrainz.Recording{edit, studio}, scores: gomusicbrainz.ScoreMap{edit: 100, studio: 90}},
+			expected: 3 * time.Minute,
+		},
+		{
+			name:     "play gaps choose between equally scored recordings",
+			mb:       &fakeMusicBrainzClient{recordings: []*gomusicbrainz.Recording{studio, live}, scores: gomusicbrainz.ScoreMap{studio: 100, live: 100}},
+			gaps:     []time.Duration{6 * time.Minute},
+			expected: 6 * time.Minute,
+		},
+		{
+			name: "release track length of a recording without length",
+			mb: &fakeMusicBrainzClient{
+				recordings: []*gomusicbrainz.Recording{noLength},
+				releases: []*gomusicbrainz.Release{{ID: "release", Mediums: []*gomusicbrainz.Medium{{Tracks: []*gomusicbrainz.Track{
+					{Length: 100000, Recording: *testRecording("other", "Other", 0)},
+					{Length: 200000, Recording: *noLength},
+				}}}}},
+			},
+			expected: 200 * time.Second,
+		},
+		{
+			name:          "artist alias",
+			mb:            &fakeMusicBrainzClient{recordings: []*gomusicbrainz.Recording{studio}},
+			artistAliases: map[string]string{"Artist": "Alias"},
+			expected:      3 * time.Minute,
+			expectedQuery: `artist:"Alias" AND recording:"Song"`,
+		},
+		{
+			name:     "pinned recording",
+			mb:       &fakeMusicBrainzClient{recordings: []*gomusicbrainz.Recording{studio, live}},
+			mbidMap:  mbidByTrackByArtist{"Artist": {"Song": "live"}},
+			expected: 6 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{mb: tt.mb, artistAliases: tt.artistAliases, mbidMap: tt.mbidMap}
+			got, _, err := getTrackDurationFromMusicBrainz(c, "Artist", "Song", tt.gaps)
+			if err != nil {
+				t.Fatalf("getTrackDurationFromMusicBrainz() error = %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("getTrackDurationFromMusicBrainz() = %s, expected %s", got, tt.expected)
+			}
+			if tt.expectedQuery != "" && (len(tt.mb.queries) == 0 || tt.mb.queries[0] != tt.expectedQuery) {
+				t.Errorf("MusicBrainz queries = %q, expected %q first", tt.mb.queries, tt.expectedQuery)
+			}
+		})
+	}
+}
+
+func TestMusicBrainzArtistCacheKey(t *testing.T) {
+	c := &Config{artistAliases: map[string]string{"Beatles": "The Beatles"}}
+	tests := []struct {
+		artist      string
+		expectedKey string
+	}{
+		{artist: "Beatles", expectedKey: trackDurationCacheKey("The Beatles", "Help!")},
+		{artist: "The Beatles", expectedKey: trackDurationCacheKey("The Beatles", "Help!")},
+		{artist: "Other", expectedKey: trackDurationCacheKey("Other", "Help!")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.artist, func(t *testing.T) {
+			// An aliased artist shares the cached duration of the MusicBrainz query it is searched with
+			if got := trackDurationCacheKey(musicBrainzArtist(c, tt.artist), "Help!"); got != tt.expectedKey {
+				t.Errorf("cache key of %s = %s, expected %s", tt.artist, got, tt.expectedKey)
+			}
+		})
+	}
+}
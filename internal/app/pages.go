@@ -0,0 +1,146 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+const (
+	pagesDir = "pages"
+	// Suffix of the pages directory of a run, until it completes and replaces the pages of the previous run
+	partialPagesSuffix = ".partial"
+)
+
+var ErrPageNotCached = errors.New("page not cached, run again with cache-pages over the same user and date range")
+
+// pageRowsDir returns the directory of the cached pages of the user and date range of the run, whose pages are
+// numbered differently
+func pageRowsDir(c *Config) string {
+	from, to := "start", "end"
+	if !c.From.IsZero() {
+		from = c.From.Format(LastFMQueryDayFormat)
+	}
+	if !c.To.IsZero() {
+		to = c.To.Format(LastFMQueryDayFormat)
+	}
+	return path.Join(c.DataDir, pagesDir, c.LastFMUsername, from+"_"+to)
+}
+
+// cachingPages reports whether the library pages loaded by the run are cached
+func cachingPages(c *Config) bool {
+	return c.CachePages && !c.ReplayPages && !c.dataDirReadOnly
+}
+
+func pageRowsFile(dir string, page int) string {
+	return path.Join(dir, fmt.Sprintf("page-%d.json", page))
+}
+
+// savePageRows stores the raw scrobble rows HTML of a library page so it can be replayed later. Pages are saved
+// apart from the pages of previous runs until the run completes, see finishPageRows.
+func savePageRows(c *Config, page int, rows []string) error {
+	dir := pageRowsDir(c) + partialPagesSuffix
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create pages directory: %w", err)
+	}
+
+	f, err := os.Create(pageRowsFile(dir, page))
+	if err != nil {
+		return fmt.Errorf("failed to create page file: %w", err)
+	}
+	defer helpers.CloseFile(f)
+
+	return json.NewEncoder(f).Encode(rows)
+}
+
+// clearPartialPageRows removes the pages of a previous run that did not complete, which would mix with the pages of
+// the run
+func clearPartialPageRows(c *Config) error {
+	if err := os.RemoveAll(pageRowsDir(c) + partialPagesSuffix); err != nil {
+		return fmt.Errorf("failed to clear pages of an incomplete run: %w", err)
+	}
+	return nil
+}
+
+// finishPageRows replaces the cached pages of the user and date range with the pages of a completed run, the pages
+// of an incomplete run are cleared
+func finishPageRows(c *Config) error {
+	if !c.runCompleted {
+		return clearPartialPageRows(c)
+	}
+
+	dir := pageRowsDir(c)
+	if _, err := os.Stat(dir + partialPagesSuffix); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to clear previously cached pages: %w", err)
+	}
+	if err := os.Rename(dir+partialPagesSuffix, dir); err != nil {
+		return fmt.Errorf("failed to save cached pages: %w", err)
+	}
+	slog.Info("Cached pages saved", "dir", dir)
+	return nil
+}
+
+func loadPageRows(c *Config, page int) ([]string, error) {
+	f, err := os.Open(pageRowsFile(pageRowsDir(c), page))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("page %d: %w", page, ErrPageNotCached)
+		}
+		return nil, fmt.Errorf("failed to open page file: %w", err)
+	}
+	defer helpers.CloseFile(f)
+
+	var rows []string
+	if err := json.NewDecoder(f).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("failed to decode page file: %w", err)
+	}
+	return rows, nil
+}
+
+// getReplayStartPage returns the oldest cached page of the user and date range, or the configured start page if set
+func getReplayStartPage(c *Config) (int, error) {
+	entries, err := os.ReadDir(pageRowsDir(c))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, ErrNoScrobbles
+		}
+		return 0, fmt.Errorf("failed to read pages directory: %w", err)
+	}
+
+	lastPage := 0
+	for _, entry := range entries {
+		name, found := strings.CutPrefix(entry.Name(), "page-")
+		if !found {
+			continue
+		}
+		page, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		lastPage = max(lastPage, page)
+	}
+
+	if lastPage == 0 {
+		return 0, ErrNoScrobbles
+	}
+
+	if c.StartPage != 0 {
+		if c.StartPage > lastPage {
+			return 0, fmt.Errorf("start page %d exceeds cached pages %d", c.StartPage, lastPage)
+		}
+		lastPage = c.StartPage
+	}
+
+	slog.Info("Replaying cached pages", "startPage", lastPage)
+	return lastPage, nil
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPageRowsDir(t *testing.T) {
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		c        *Config
+		expected string
+	}{
+		{name: "whole library", c: &Config{DataDir: "data", LastFMUsername: "alice"}, expected: "data/pages/alice/start_end"},
+		{name: "from", c: &Config{DataDir: "data", LastFMUsername: "alice", From: from}, expected: "data/pages/alice/2024-01-02_end"},
+		{name: "to", c: &Config{DataDir: "data", LastFMUsername: "alice", To: to}, expected: "data/pages/alice/start_2024-03-04"},
+		{name: "date range", c: &Config{DataDir: "data", LastFMUsername: "alice", From: from, To: to}, expected: "data/pages/alice/2024-01-02_2024-03-04"},
+		{name: "other user", c: &Config{DataDir: "data", LastFMUsername: "bob", From: from, To: to}, expected: "data/pages/bob/2024-01-02_2024-03-04"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageRowsDir(tt.c); got != tt.expected {
+				t.Errorf("pageRowsDir() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPageRowsReplay(t *testing.T) {
+	tests := []struct {
+		name         string
+		runCompleted bool
+		// Pages of the previous completed run
+		previousPages   []int
+		expectedPages   []int
+		expectedMissing int
+	}{
+		{name: "completed run", runCompleted: true, expectedPages: []int{1, 2, 3}},
+		{name: "completed run replaces the previous pages", runCompleted: true, previousPages: []int{4}, expectedPages: []int{1, 2, 3}, expectedMissing: 4},
+		{name: "incomplete run keeps the previous pages", previousPages: []int{4}, expectedPages: []int{4}, expectedMissing: 1},
+		{name: "incomplete run without previous pages", expectedMissing: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{DataDir: t.TempDir(), LastFMUsername: "alice"}
+			if len(tt.previousPages) > 0 {
+				for _, page := range tt.previousPages {
+					if err := savePageRows(c, page, []string{"previous"}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				c.runCompleted = true
+				if err := finishPageRows(c); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c.runCompleted = tt.runCompleted
+			for page := 1; page <= 3; page++ {
+				if err := savePageRows(c, page, []string{"current"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := finishPageRows(c); err != nil {
+				t.Fatalf("finishPageRows() error = %v", err)
+			}
+
+			for _, page := range tt.expectedPages {
+				if _, err := loadPageRows(c, page); err != nil {
+					t.Errorf("loadPageRows(%d) error = %v", page, err)
+				}
+			}
+			if tt.expectedMissing > 0 {
+				if _, err := loadPageRows(c, tt.expectedMissing); !errors.Is(err, ErrPageNotCached) {
+					t.Errorf("loadPageRows(%d) error = %v, expected %v", tt.expectedMissing, err, ErrPageNotCached)
+				}
+			}
+
+			startPage, err := getReplayStartPage(c)
+			if len(tt.expectedPages) == 0 {
+				if !errors.Is(err, ErrNoScrobbles) {
+					t.Errorf("getReplayStartPage() error = %v, expected %v", err, ErrNoScrobbles)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getReplayStartPage() error = %v", err)
+			}
+			if expected := slices.Max(tt.expectedPages); startPage != expected {
+				t.Errorf("getReplayStartPage() = %d, expected %d", startPage, expected)
+			}
+		})
+	}
+}
+
+func TestLoadPageRowsRoundTrip(t *testing.T) {
+	c := &Config{DataDir: t.TempDir(), LastFMUsername: "alice"}
+	rows := []string{`<tr class="chartlist-row">first</tr>`, `<tr class="chartlist-row">second</tr>`}
+	if err := savePageRows(c, 7, rows); err != nil {
+		t.Fatal(err)
+	}
+	c.runCompleted = true
+	if err := finishPageRows(c); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadPageRows(c, 7)
+	if err != nil {
+		t.Fatalf("loadPageRows() error = %v", err)
+	}
+	if !slices.Equal(got, rows) {
+		t.Errorf("loadPageRows() = %v, expected %v", got, rows)
+	}
+
+	// Another date range has its own pages
+	c.From = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := loadPageRows(c, 7); !errors.Is(err, ErrPageNotCached) {
+		t.Errorf("loadPageRows() of another date range error = %v, expected %v", err, ErrPageNotCached)
+	}
+}
+
+func TestGetReplayStartPage(t *testing.T) {
+	tests := []struct {
+		name        string
+		startPage   int
+		expected    int
+		expectedErr bool
+	}{
+		{name: "oldest cached page", expected: 3},
+		{name: "configured start page", startPage: 2, expected: 2},
+		{name: "start page beyond the cached pages", startPage: 4, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{DataDir: t.TempDir(), LastFMUsername: "alice"}
+			for page := 1; page <= 3; page++ {
+				if err := savePageRows(c, page, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.runCompleted = true
+			if err := finishPageRows(c); err != nil {
+				t.Fatal(err)
+			}
+
+			c.StartPage = tt.startPage
+			got, err := getReplayStartPage(c)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("getReplayStartPage() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if got != tt.expected {
+				t.Errorf("getReplayStartPage() = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+// parallelReadAhead is the number of loaded pages a worker keeps waiting to be processed, it bounds the scrobbles
+// held in memory whatever the number of pages of the run
+const parallelReadAhead = 2
+
+type pageResult struct {
+	scrobbles []scrobble
+	err       error
+}
+
+// parallelPageFetcher splits the pages between workers loading them ahead in their own browser tab.
+// Pages are still returned in order, so duplicates across the pages of two workers are detected.
+// The returned function stops the workers.
+func parallelPageFetcher(ctx context.Context, c *Config, startPage int, endPage int) (pageFetcher, func()) {
+	workersCtx, cancel := context.WithCancel(ctx)
+	delayer := &pageDelayer{}
+
+	chunks := helpers.SplitRange(endPage, startPage, c.Workers)
+	// A worker loads its pages in the order they are processed, each of them has its own queue of loaded pages
+	results := make([]chan pageResult, len(chunks))
+	var wg sync.WaitGroup
+	for i, pages := range chunks {
+		results[i] = make(chan pageResult, parallelReadAhead)
+		wg.Go(func() {
+			tabCtx := c.taskCtx
+			if !c.ReplayPages {
+				var tabCancel context.CancelFunc
+				tabCtx, tabCancel = chromedp.NewContext(c.taskCtx)
+				defer tabCancel()
+			}
+
+			slog.Debug("Starting page worker", "fromPage", pages[1], "toPage", pages[0])
+			for page := pages[1]; page >= pages[0]; page-- {
+				var result pageResult
+				if result.err = delayer.wait(workersCtx, c); result.err == nil {
+					result.scrobbles, result.err = fetchPage(workersCtx, tabCtx, c, page)
+				}
+				select {
+				case results[i] <- result:
+				case <-workersCtx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	getPage := func(page int) ([]scrobble, error) {
+		worker := slices.IndexFunc(chunks, func(pages [2]int) bool {
+			return page >= pages[0] && page <= pages[1]
+		})
+		select {
+		case result := <-results[worker]:
+			return result.scrobbles, result.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+	return getPage, stop
+}
+
+// pageDelayer spaces the page loads of all the workers by the page delay, so that the load on Last.fm doesn't grow
+// with the number of workers
+type pageDelayer struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait reserves the next page load slot and waits for it, the first page is loaded right away
+func (d *pageDelayer) wait(ctx context.Context, c *Config) error {
+	d.mu.Lock()
+	slot := d.next
+	now := time.Now()
+	if slot.Before(now) {
+		slot = now
+	}
+	d.next = slot.Add(pageDelay(c))
+	d.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	slog.Debug("Pausing between pages", "delay", delay)
+	return helpers.SleepContext(ctx, delay)
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageDelayer(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		loads    int
+		expected time.Duration
+	}{
+		{name: "no delay", loads: 4},
+		{name: "single load is not delayed", delay: time.Hour, loads: 1},
+		// Three of the four loads wait for the one before
+		{name: "loads of all the workers are spaced", delay: 20 * time.Millisecond, loads: 4, expected: 60 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{PageDelay: tt.delay}
+			d := &pageDelayer{}
+
+			start := time.Now()
+			var wg sync.WaitGroup
+			for range tt.loads {
+				wg.Go(func() {
+					if err := d.wait(context.Background(), c); err != nil {
+						t.Errorf("wait() error = %v", err)
+					}
+				})
+			}
+			wg.Wait()
+
+			if elapsed := time.Since(start); elapsed < tt.expected || elapsed > tt.expected+time.Second {
+				t.Errorf("loads took %s, expected %s", elapsed, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPageDelayerCancelled(t *testing.T) {
+	c := &Config{PageDelay: time.Hour}
+	d := &pageDelayer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.wait(ctx, c); err != nil {
+		t.Fatalf("first wait() error = %v, expected no wait", err)
+	}
+	if err := d.wait(ctx, c); err == nil {
+		t.Errorf("second wait() error = nil, expected the cancellation")
+	}
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// progressNotifyMinInterval keeps page based progress updates from spamming runs going through pages quickly
+const progressNotifyMinInterval = 5 * time.Minute
+
+// progressCadence is the cadence of the progress updates of progress-notify-every, a time interval or a number of pages
+type progressCadence struct {
+	interval time.Duration
+	pages    int
+}
+
+// progressNotifier tracks the last progress update of the run
+type progressNotifier struct {
+	cadence   progressCadence
+	lastSent  time.Time
+	lastPages int
+}
+
+// parseProgressCadence reads a duration (ex: 30m) or a number of pages (ex: 20), an empty value disables the updates
+func parseProgressCadence(value string) (progressCadence, error) {
+	if value == "" {
+		return progressCadence{}, nil
+	}
+	if pages, err := strconv.Atoi(value); err == nil {
+		if pages <= 0 {
+			return progressCadence{}, fmt.Errorf("number of pages must be positive: %d", pages)
+		}
+		return progressCadence{pages: pages}, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return progressCadence{}, fmt.Errorf("not a duration nor a number of pages: %q", value)
+	}
+	if interval <= 0 {
+		return progressCadence{}, fmt.Errorf("interval must be positive: %s", interval)
+	}
+	return progressCadence{interval: interval}, nil
+}
+
+func newProgressNotifier(cadence progressCadence, start time.Time) *progressNotifier {
+	return &progressNotifier{
+		cadence:  cadence,
+		lastSent: start,
+	}
+}
+
+// due reports whether a progress update is due after pagesDone pages. Interval updates are due once the interval
+// elapsed, page updates once the pages were processed and the minimum interval elapsed.
+func (p *progressNotifier) due(pagesDone int, now time.Time) bool {
+	elapsed := now.Sub(p.lastSent)
+	if p.cadence.interval > 0 {
+		return elapsed >= p.cadence.interval
+	}
+	return pagesDone-p.lastPages >= p.cadence.pages && elapsed >= progressNotifyMinInterval
+}
+
+// notifyProgress sends a progress update when one is due after a page, the last page is left to the run summary
+func notifyProgress(ctx context.Context, c *Config, pagesDone, totalPages int) {
+	p := c.progress
+	if p == nil || pagesDone >= totalPages {
+		return
+	}
+	now := time.Now()
+	if !p.due(pagesDone, now) {
+		return
+	}
+	p.lastSent = now
+	p.lastPages = pagesDone
+
+	elapsed := now.Sub(c.startTime)
+	eta := time.Duration(float64(elapsed) / float64(pagesDone) * float64(totalPages-pagesDone))
+	deletions := "Duplicated scrobbles not deleted"
+	if c.canDelete {
+		deletions = "Duplicated scrobbles deleted"
+	}
+	message := fmt.Sprintf("Run of %s in progress\nPages processed: %d/%d\n%s: %d\nElapsed time: %s\nEstimated time left: %s",
+		c.startTime.Format(time.RFC1123), pagesDone, totalPages, deletions, len(c.deletedScrobbles),
+		elapsed.Truncate(time.Second), eta.Truncate(time.Second))
+
+	slog.Debug("Sending progress update", "pagesDone", pagesDone, "totalPages", totalPages)
+	if err := sendTelegramMessage(ctx, c, message); err != nil {
+		slog.Warn("Failed to send progress update", "error", err)
+	}
+}
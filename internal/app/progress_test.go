@@ -0,0 +1,58 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProgressCadence(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expected    progressCadence
+		expectedErr bool
+	}{
+		{name: "disabled", value: ""},
+		{name: "interval", value: "30m", expected: progressCadence{interval: 30 * time.Minute}},
+		{name: "pages", value: "20", expected: progressCadence{pages: 20}},
+		{name: "zero pages", value: "0", expectedErr: true},
+		{name: "negative interval", value: "-1m", expectedErr: true},
+		{name: "invalid", value: "often", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProgressCadence(tt.value)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("parseProgressCadence(%q) error = %v, expected error %v", tt.value, err, tt.expectedErr)
+			}
+			if got != tt.expected {
+				t.Errorf("parseProgressCadence(%q) = %+v, expected %+v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProgressNotifierDue(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		cadence   progressCadence
+		pagesDone int
+		elapsed   time.Duration
+		expected  bool
+	}{
+		{name: "interval not elapsed", cadence: progressCadence{interval: 30 * time.Minute}, pagesDone: 100, elapsed: 29 * time.Minute, expected: false},
+		{name: "interval elapsed", cadence: progressCadence{interval: 30 * time.Minute}, pagesDone: 1, elapsed: 30 * time.Minute, expected: true},
+		{name: "pages not processed", cadence: progressCadence{pages: 20}, pagesDone: 19, elapsed: time.Hour, expected: false},
+		{name: "pages processed", cadence: progressCadence{pages: 20}, pagesDone: 20, elapsed: progressNotifyMinInterval, expected: true},
+		{name: "pages processed before the min interval", cadence: progressCadence{pages: 20}, pagesDone: 40, elapsed: time.Minute, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProgressNotifier(tt.cadence, start)
+			if got := p.due(tt.pagesDone, start.Add(tt.elapsed)); got != tt.expected {
+				t.Errorf("due(%d, +%s) = %v, expected %v", tt.pagesDone, tt.elapsed, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michiwend/gomusicbrainz"
+)
+
+const (
+	musicBrainzRateLimitFile = "musicbrainz-last-request"
+	// MusicBrainz allows an average of one request per second per IP
+	musicBrainzRequestInterval = time.Second
+)
+
+// rateLimiter spaces requests by a minimum interval, the last request time is persisted
+// so that successive processes share the same budget
+type rateLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	file        string
+	lastRequest time.Time
+}
+
+// newRateLimiter loads the last request time from file, an empty file path disables persistence
+func newRateLimiter(interval time.Duration, file string) *rateLimiter {
+	r := &rateLimiter{
+		interval: interval,
+		file:     file,
+	}
+	if file == "" {
+		return r
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Failed to read rate limit state, starting with a fresh budget", "error", err)
+		}
+		return r
+	}
+
+	nanos, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		slog.Warn("Failed to parse rate limit state, starting with a fresh budget", "error", err)
+		return r
+	}
+
+	// A state from the future comes from a clock change, it would throttle for too long
+	lastRequest := time.Unix(0, nanos)
+	if lastRequest.After(time.Now()) {
+		slog.Debug("Ignoring stale rate limit state", "lastRequest", lastRequest)
+		return r
+	}
+	r.lastRequest = lastRequest
+	return r
+}
+
+func (r *rateLimiter) wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if d := time.Until(r.lastRequest.Add(r.interval)); d > 0 {
+		time.Sleep(min(d, r.interval))
+	}
+	r.lastRequest = time.Now()
+
+	if r.file == "" {
+		return
+	}
+	if err := os.WriteFile(r.file, []byte(strconv.FormatInt(r.lastRequest.UnixNano(), 10)+"\n"), 0666); err != nil {
+		slog.Debug("Failed to save rate limit state", "error", err)
+	}
+}
+
+// rateLimitedMusicBrainzClient waits for the rate limiter before each MusicBrainz request
+type rateLimitedMusicBrainzClient struct {
+	client  MusicBrainzClient
+	limiter *rateLimiter
+}
+
+func newRateLimitedMusicBrainzClient(client MusicBrainzClient, dataDir string, persist bool) *rateLimitedMusicBrainzClient {
+	var file string
+	if persist {
+		file = path.Join(dataDir, musicBrainzRateLimitFile)
+	}
+	return &rateLimitedMusicBrainzClient{
+		client:  client,
+		limiter: newRateLimiter(musicBrainzRequestInterval, file),
+	}
+}
+
+func (m *rateLimitedMusicBrainzClient) SearchRecording(searchTerm string, limit, offset int) (*gomusicbrainz.RecordingSearchResponse, error) {
+	m.limiter.wait()
+	return m.client.SearchRecording(searchTerm, limit, offset)
+}
+
+func (m *rateLimitedMusicBrainzClient) LookupRecording(id gomusicbrainz.MBID, inc ...string) (*gomusicbrainz.Recording, error) {
+	m.limiter.wait()
+	return m.client.LookupRecording(id, inc...)
+}
+
+func (m *rateLimitedMusicBrainzClient) SearchRelease(searchTerm string, limit, offset int) (*gomusicbrainz.ReleaseSearchResponse, error) {
+	m.limiter.wait()
+	return m.client.SearchRelease(searchTerm, limit, offset)
+}
+
+func (m *rateLimitedMusicBrainzClient) LookupRelease(id gomusicbrainz.MBID, inc ...string) (*gomusicbrainz.Release, error) {
+	m.limiter.wait()
+	return m.client.LookupRelease(id, inc...)
+}
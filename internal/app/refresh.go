@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+	"github.com/michiwend/gomusicbrainz"
+)
+
+// refreshDurationTolerance ignores the rounding differences between recordings of a track
+const refreshDurationTolerance = 2 * time.Second
+
+type refreshDurationsStats struct {
+	checked   int
+	changed   int
+	recent    int
+	unnamed   int
+	notFound  int
+	failed    int
+	malformed int
+}
+
+// RefreshDurations looks the cached durations older than olderThan up again in MusicBrainz, whose data and
+// matching improve over time, and replaces those that changed. The changes are reported to w.
+func RefreshDurations(ctx context.Context, c *Config, olderThan time.Duration, w io.Writer) error {
+	if c.CacheType == "inmemory" {
+		return errors.New("the inmemory cache is not persisted between runs, there is nothing to refresh")
+	}
+	if c.DisableMusicBrainz {
+		return errors.New("refreshing durations requires MusicBrainz")
+	}
+
+	if err := ensureDataDir(c.DataDir); err != nil {
+		return err
+	}
+
+	var err error
+	c.artistAliases, err = getArtistAliases(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get artist aliases: %w", err)
+	}
+	c.mbidMap, err = getMBIDMap(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get MBID map: %w", err)
+	}
+
+	if c.mb == nil {
+		if c.mb, err = newMusicBrainzClient(c); err != nil {
+			return err
+		}
+	}
+
+	if err := initCache(ctx, c); err != nil {
+		return err
+	}
+	defer c.cache.Close()
+
+	entries, err := c.cache.Entries(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list cache entries: %w", err)
+	}
+
+	var stats refreshDurationsStats
+	cutoff := time.Now().Add(-olderThan)
+	for key, value := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry, err := decodeTrackDurationCacheEntry(value)
+		if err != nil {
+			slog.Warn("Ignoring malformed cache entry", "key", key, "error", err)
+			stats.malformed++
+			continue
+		}
+		// Entries cached before tracks were named in the cache can't be searched again
+		if entry.Artist == "" || entry.Track == "" {
+			stats.unnamed++
+			continue
+		}
+		if entry.CachedAt.After(cutoff) {
+			stats.recent++
+			continue
+		}
+		cached, err := time.ParseDuration(entry.Duration)
+		if err != nil {
+			slog.Warn("Ignoring malformed cache entry", "key", key, "error", err)
+			stats.malformed++
+			continue
+		}
+
+		stats.checked++
+		duration, err := backoff.Retry(ctx, func() (time.Duration, error) {
+			return refreshedTrackDuration(c, key, entry.Artist, entry.Track)
+		}, backoff.WithBackOff(backoff.NewExponentialBackOff()), backoff.WithMaxTries(10))
+		if err != nil {
+			slog.Warn("Failed to refresh track duration", "artist", entry.Artist, "track", entry.Track, "error", err)
+			stats.failed++
+			continue
+		}
+		// A track MusicBrainz no longer knows keeps its duration, it may come from the Last.fm fallback
+		if duration <= 0 {
+			stats.notFound++
+			continue
+		}
+
+		if (duration - cached).Abs() > refreshDurationTolerance {
+			stats.changed++
+			fmt.Fprintf(w, "%s - %s: %s -> %s\n", entry.Artist, entry.Track, cached, duration)
+			slog.Info("Refreshed track duration", "artist", entry.Artist, "track", entry.Track, "cached", cached, "duration", duration)
+		} else {
+			duration = cached
+		}
+		// Unchanged durations are cached again so that they are not checked before olderThan elapsed
+		cacheTrackDuration(ctx, c, key, entry.Artist, entry.Track, duration)
+	}
+
+	fmt.Fprintf(w, "Durations checked: %d\n", stats.checked)
+	fmt.Fprintf(w, "Durations changed: %d\n", stats.changed)
+	fmt.Fprintf(w, "Durations not found anymore: %d\n", stats.notFound)
+	fmt.Fprintf(w, "Durations failed to refresh: %d\n", stats.failed)
+	fmt.Fprintf(w, "Durations cached recently: %d\n", stats.recent)
+	fmt.Fprintf(w, "Durations of unnamed tracks: %d\n", stats.unnamed)
+	fmt.Fprintf(w, "Malformed cache entries: %d\n", stats.malformed)
+	return nil
+}
+
+// refreshedTrackDuration looks a cached duration up the way it was cached, pinned recordings by MBID
+func refreshedTrackDuration(c *Config, key, artist, track string) (time.Duration, error) {
+	if mbid, found := strings.CutPrefix(key, pinnedCacheKeyPrefix); found {
+		return getPinnedRecordingDuration(c, gomusicbrainz.MBID(mbid))
+	}
+	duration, _, err := getTrackDurationFromMusicBrainz(c, artist, track, nil)
+	return duration, err
+}
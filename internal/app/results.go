@@ -0,0 +1,116 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	_ "modernc.org/sqlite"
+)
+
+const resultsDBSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at INTEGER NOT NULL,
+	elapsed_ms INTEGER NOT NULL,
+	username TEXT NOT NULL,
+	can_delete INTEGER NOT NULL,
+	processed_scrobbles INTEGER NOT NULL,
+	deleted_scrobbles INTEGER NOT NULL,
+	cache_hits INTEGER NOT NULL,
+	cache_misses INTEGER NOT NULL,
+	unknown_track_durations INTEGER NOT NULL,
+	skipped_unknown_duration INTEGER NOT NULL,
+	delete_fails INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS deletions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	artist TEXT NOT NULL,
+	track TEXT NOT NULL,
+	timestamp INTEGER NOT NULL,
+	url TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deletions_run_id ON deletions(run_id);
+`
+
+func openResultsDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results database: %w", err)
+	}
+
+	if _, err := db.Exec(resultsDBSchema); err != nil {
+		if errClose := db.Close(); errClose != nil {
+			slog.Error(errClose.Error())
+		}
+		return nil, fmt.Errorf("failed to create results database schema: %w", err)
+	}
+	return db, nil
+}
+
+// saveRunResults appends the run statistics and its deleted scrobbles to the results database
+func saveRunResults(c *Config) error {
+	db, err := openResultsDB(c.ResultsDB)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			slog.Error("Failed to close results database", "error", err)
+		}
+	}()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.Exec(`INSERT INTO runs (started_at, elapsed_ms, username, can_delete, processed_scrobbles, deleted_scrobbles, cache_hits, cache_misses, unknown_track_durations, skipped_unknown_duration, delete_fails) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		c.startTime.Unix(),
+		c.runStats.elapsedTime.Milliseconds(),
+		c.LastFMUsername,
+		c.canDelete,
+		c.runStats.processedScrobbles.Load(),
+		len(c.deletedScrobbles),
+		c.runStats.cacheHits.Load(),
+		c.runStats.cacheMisses.Load(),
+		c.runStats.unknownTrackDurationsCount.Load(),
+		c.runStats.skippedScrobbles(),
+		c.runStats.scrobbleDeleteFails.Load(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert run: %w", err)
+	}
+
+	runID, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get run id: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO deletions (run_id, artist, track, timestamp, url) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare deletion insert: %w", err)
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+
+	for _, s := range c.deletedScrobbles {
+		if _, err := stmt.Exec(runID, s.artist, s.track, s.timestamp.Unix(), s.url); err != nil {
+			return fmt.Errorf("failed to insert deletion: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit results: %w", err)
+	}
+
+	slog.Info("Run results saved to database", "file", c.ResultsDB, "runID", runID)
+	return nil
+}
@@ -0,0 +1,70 @@
+package app
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveRunResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		runs      int
+		deletions int
+	}{
+		{name: "run without deletions", runs: 1},
+		{name: "run with deletions", runs: 1, deletions: 3},
+		{name: "runs are appended", runs: 2, deletions: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{
+				ResultsDB:      filepath.Join(t.TempDir(), "results.db"),
+				LastFMUsername: "alice",
+				startTime:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				canDelete:      true,
+			}
+			c.runStats.processedScrobbles.Store(10)
+			c.runStats.elapsedTime = 1500 * time.Millisecond
+			for range tt.deletions {
+				previous, _ := testScrobblePair(0, 0)
+				c.deletedScrobbles = append(c.deletedScrobbles, previous)
+			}
+
+			for range tt.runs {
+				if err := saveRunResults(c); err != nil {
+					t.Fatalf("saveRunResults() error = %v", err)
+				}
+			}
+
+			db, err := openResultsDB(c.ResultsDB)
+			if err != nil {
+				t.Fatalf("openResultsDB() error = %v", err)
+			}
+			defer db.Close()
+
+			var (
+				runs      int
+				username  string
+				elapsedMS int64
+				processed int64
+				deleted   int
+			)
+			if err := db.QueryRow(`SELECT COUNT(*), MAX(username), MAX(elapsed_ms), MAX(processed_scrobbles), MAX(deleted_scrobbles) FROM runs`).Scan(&runs, &username, &elapsedMS, &processed, &deleted); err != nil {
+				t.Fatalf("failed to query runs: %v", err)
+			}
+			if runs != tt.runs || username != "alice" || elapsedMS != 1500 || processed != 10 || deleted != tt.deletions {
+				t.Errorf("runs = %d of %s in %dms with %d processed and %d deleted, expected %d of alice in 1500ms with 10 processed and %d deleted",
+					runs, username, elapsedMS, processed, deleted, tt.runs, tt.deletions)
+			}
+
+			var deletions int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM deletions JOIN runs ON runs.id = deletions.run_id`).Scan(&deletions); err != nil {
+				t.Fatalf("failed to query deletions: %v", err)
+			}
+			if expected := tt.runs * tt.deletions; deletions != expected {
+				t.Errorf("deletions = %d, expected %d", deletions, expected)
+			}
+		})
+	}
+}
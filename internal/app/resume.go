@@ -0,0 +1,66 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const lastProcessedTimestampFile = "last-processed-timestamp"
+
+func readLastProcessedTimestamp(dataDir string) (time.Time, error) {
+	b, err := os.ReadFile(path.Join(dataDir, lastProcessedTimestampFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to read last processed timestamp: %w", err)
+	}
+
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse last processed timestamp: %w", err)
+	}
+	return time.Unix(timestamp, 0), nil
+}
+
+func writeLastProcessedTimestamp(dataDir string, timestamp time.Time) error {
+	err := os.WriteFile(path.Join(dataDir, lastProcessedTimestampFile), []byte(strconv.FormatInt(timestamp.Unix(), 10)+"\n"), 0666)
+	if err != nil {
+		return fmt.Errorf("failed to write last processed timestamp: %w", err)
+	}
+	slog.Info("Saved last processed scrobble timestamp", "timestamp", timestamp)
+	return nil
+}
+
+// initResume loads the newest scrobble timestamp processed by a previous run and narrows
+// the library query to the day it belongs to, scrobbles up to it are then skipped
+func initResume(c *Config) error {
+	lastProcessed, err := readLastProcessedTimestamp(c.DataDir)
+	if err != nil {
+		return err
+	}
+	if lastProcessed.IsZero() {
+		slog.Info("No previous run found, processing the whole selected period")
+		return nil
+	}
+
+	c.resumeAfter = lastProcessed
+	resumeDay := time.Date(lastProcessed.Year(), lastProcessed.Month(), lastProcessed.Day(), 0, 0, 0, 0, time.Local)
+	if c.From.IsZero() || c.From.Before(resumeDay) {
+		c.From = resumeDay
+	}
+	slog.Info("Resuming after last processed scrobble", "timestamp", lastProcessed)
+
+	return nil
+}
+
+// isAlreadyProcessed reports whether a scrobble was processed by a previous run
+func isAlreadyProcessed(c *Config, s *scrobble) bool {
+	return !c.resumeAfter.IsZero() && !s.timestamp.After(c.resumeAfter)
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+type reviewDecision int
+
+const (
+	reviewDecisionKeep reviewDecision = iota
+	reviewDecisionDelete
+	reviewDecisionSkip
+)
+
+func parseReviewDecision(input string) (reviewDecision, bool) {
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "k", "keep":
+		return reviewDecisionKeep, true
+	case "d", "delete":
+		return reviewDecisionDelete, true
+	case "s", "skip":
+		return reviewDecisionSkip, true
+	default:
+		return 0, false
+	}
+}
+
+// askReviewDecision prompts until a valid decision is read from the input
+func askReviewDecision(input *bufio.Reader, output io.Writer, s *scrobble, reason string) (reviewDecision, error) {
+	for {
+		_, _ = fmt.Fprintf(output, "%s scrobble: %s - %s (%s)\n[k]eep, [d]elete or [s]kip? ", reason, s.artist, s.track, s.timestamp.Format("2006-01-02 15:04:05"))
+
+		line, err := input.ReadString('\n')
+		if decision, ok := parseReviewDecision(line); ok {
+			return decision, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		_, _ = fmt.Fprintln(output, "Invalid answer")
+	}
+}
+
+// reviewScrobble shows the scrobble in the browser and asks the user what to do with it.
+// Skipped scrobbles are reported like in a dry run, kept ones are not reported at all.
+func reviewScrobble(c *Config, s *scrobble, deleteCurrentScrobble bool, reason string) (reviewDecision, error) {
+	if err := highlightScrobble(c, s, deleteCurrentScrobble); err != nil {
+		_, _ = fmt.Fprintf(c.reviewOutput, "Could not highlight scrobble in the browser: %s\n", err)
+	}
+	return askReviewDecision(c.reviewInput, c.reviewOutput, s, reason)
+}
+
+func highlightScrobble(c *Config, s *scrobble, last bool) error {
+	timeoutCtx, cancel := context.WithTimeout(c.taskCtx, browserOperationsTimeout)
+	defer cancel()
+
+	if s.page != 0 && s.page != c.loadedPage {
+		if err := loadLibraryPage(timeoutCtx, c, s.page); err != nil {
+			return fmt.Errorf("failed to navigate to the page of the scrobble: %w", err)
+		}
+	}
+
+	index := "0"
+	if last {
+		index = "inputs.length - 1"
+	}
+
+	var found bool
+	err := chromedp.Run(timeoutCtx, chromedp.Evaluate(fmt.Sprintf(`(() => {
+		const inputs = [...document.querySelectorAll("input[name='timestamp'][value='%s']")];
+		const row = inputs[%s]?.closest('.chartlist-row');
+		if (!row) return false;
+		row.scrollIntoView({block: 'center'});
+		row.style.outline = '3px solid red';
+		return true;
+	})()`, s.timestampString, index), &found))
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no row found for timestamp %s", s.timestampString)
+	}
+	return nil
+}
@@ -12,25 +12,82 @@ func Run(ctx context.Context, c *Config) error {
 	if err != nil {
 		return fmt.Errorf("invalid config: %w", err)
 	}
+	c.finalize()
 
-	if c.CanDelete {
+	if c.canDelete {
 		slog.Info("⚠️ Scrobble deletion enabled")
 	} else {
 		slog.Info("Scrobble deletion disabled")
 	}
 
+	if err := ensureDataDir(c.DataDir); err != nil {
+		return err
+	}
+	c.dataDirReadOnly = !checkDataDirWritable(c.DataDir)
+
+	if !c.dataDirReadOnly {
+		lock, err := acquireRunLock(ctx, c.DataDir, c.LockWait)
+		if err != nil {
+			return fmt.Errorf("failed to acquire run lock: %w", err)
+		}
+		defer lock.release()
+		c.lock = lock
+	}
+
+	if c.OnlyNewSinceLastRun {
+		if err := initResume(c); err != nil {
+			return fmt.Errorf("failed to resume from last run: %w", err)
+		}
+	}
+
+	if checkpointEnabled(c) {
+		if err := initCheckpoint(c); err != nil {
+			return fmt.Errorf("failed to resume from checkpoint: %w", err)
+		}
+	}
+
+	if cachingPages(c) {
+		if err := clearPartialPageRows(c); err != nil {
+			return err
+		}
+	}
+
+	if c.ImportDeletions != "" {
+		c.importedDeletions, err = readImportedDeletions(c.ImportDeletions)
+		if err != nil {
+			return fmt.Errorf("failed to read deletions to import: %w", err)
+		}
+		slog.Info("Loaded scrobbles to delete", "count", len(c.importedDeletions))
+	}
+
 	err = initApp(ctx, c)
 	if err != nil {
 		return fmt.Errorf("failed to init app: %w", err)
 	}
-	c.handleInterrupts(ctx)
+	stopCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	c.stopCtx = stopCtx
+	c.handleInterrupts(stop)
 
-	err = login(c.taskCtx, c)
-	if err != nil {
-		return fmt.Errorf("failed to login to Last.fm: %w", err)
+	if c.ImportDeletions != "" {
+		return runImportedDeletions(ctx, c)
 	}
 
-	startPage, err := getStartPage(c)
+	var startPage int
+	switch {
+	case c.analyzeOnly:
+		// The export is processed as a single page
+		startPage = 1
+	case c.ReplayPages:
+		startPage, err = getReplayStartPage(c)
+	default:
+		err = login(c.taskCtx, c)
+		if err != nil {
+			return fmt.Errorf("failed to login to Last.fm: %w", err)
+		}
+
+		startPage, err = getStartPage(c)
+	}
 	if err != nil {
 		if errors.Is(err, ErrNoScrobbles) {
 			slog.Info(ErrNoScrobbles.Error())
@@ -38,24 +95,66 @@ func Run(ctx context.Context, c *Config) error {
 		}
 		return fmt.Errorf("failed to get starting page: %w", err)
 	}
+	c.totalPages = startPage
+	startPage = resumedStartPage(c, startPage)
 
 	userTrackDurations, err := getUserTrackDurations(c.DataDir)
 	if err != nil {
 		return fmt.Errorf("failed to get user track durations: %w", err)
 	}
 	c.unknownTrackDurations = make(durationByTrackByArtist, 0)
+	c.flushedUnknownTracks = make(map[uint64]struct{})
+	c.processedByTrack = make(map[trackKey]int)
+	c.detectedByTrack = make(map[trackKey]int)
 
-	switch c.ProcessingMode {
-	case "sequential":
-		endPage := 1
-		if err := processScrobblesFromStartToEndPage(c.taskCtx, c, startPage, endPage, userTrackDurations); err != nil {
-			return fmt.Errorf("error when processing scrobbles: %w", err)
+	c.artistAliases, err = getArtistAliases(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get artist aliases: %w", err)
+	}
+
+	c.mbidMap, err = getMBIDMap(c.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to get MBID map: %w", err)
+	}
+
+	if c.LocalLibrary != "" {
+		c.localLibrary, err = indexLocalLibrary(c.LocalLibrary)
+		if err != nil {
+			return err
 		}
+	}
+
+	endPage := 1
+	var getPage pageFetcher
+	switch {
+	case c.analyzeOnly:
+		getPage = analyzedScrobblesFetcher(c)
+	case c.ProcessingMode == ProcessingModeSequential:
+		getPage = sequentialPageFetcher(c.taskCtx, c, startPage)
+	case c.ProcessingMode == ProcessingModeParallel:
+		var stopWorkers func()
+		getPage, stopWorkers = parallelPageFetcher(c.taskCtx, c, startPage, endPage)
+		defer stopWorkers()
 	default:
 		return fmt.Errorf("unknown processing mode: %s", c.ProcessingMode)
 	}
 
-	slog.Info("Processing complete!")
+	if err := processScrobblesFromStartToEndPage(c.taskCtx, c, startPage, endPage, userTrackDurations, getPage); err != nil {
+		if errors.Is(err, ErrTooManyDeleteFailures) {
+			// Keep the record of the scrobbles deleted before the deletions started failing
+			if finishErr := finishRun(ctx, c); finishErr != nil {
+				slog.Error("Failed to finish run", "error", finishErr)
+			}
+		}
+		return fmt.Errorf("error when processing scrobbles: %w", err)
+	}
+
+	if stopCtx.Err() != nil {
+		slog.Warn("Processing stopped by interrupt")
+	} else {
+		slog.Info("Processing complete!")
+		c.runCompleted = true
+	}
 
 	if err := finishRun(ctx, c); err != nil {
 		return fmt.Errorf("failed to finish run: %w", err)
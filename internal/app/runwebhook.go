@@ -0,0 +1,149 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v5"
+)
+
+const runWebhookTimeout = 10 * time.Second
+
+// runStatsJSON is a snapshot of the run statistics
+type runStatsJSON struct {
+	CacheHits                  int64 `json:"cacheHits"`
+	CacheMisses                int64 `json:"cacheMisses"`
+	ProcessedScrobbles         int64 `json:"processedScrobbles"`
+	UnknownTrackDurationsCount int64 `json:"unknownTrackDurationsCount"`
+	DurationLookups            int64 `json:"durationLookups"`
+	// Total time of the duration lookups in milliseconds
+	DurationLookupTime          int64 `json:"durationLookupTime"`
+	SkippedDurationNotFound     int64 `json:"skippedDurationNotFound"`
+	SkippedKnownUnknownDuration int64 `json:"skippedKnownUnknownDuration"`
+	SkippedDurationLookupError  int64 `json:"skippedDurationLookupError"`
+	FutureScrobbles             int64 `json:"futureScrobbles"`
+	NotSurroundedSkips          int64 `json:"notSurroundedSkips"`
+	LastOfTrackKept             int64 `json:"lastOfTrackKept"`
+	LookupTimeouts              int64 `json:"lookupTimeouts"`
+	UncomparedScrobbles         int64 `json:"uncomparedScrobbles"`
+	CorruptedCacheEntries       int64 `json:"corruptedCacheEntries"`
+	ImportedDeletionsNotFound   int64 `json:"importedDeletionsNotFound"`
+	ScrobbleDeleteFails         int64 `json:"scrobbleDeleteFails"`
+	Deletions                   int64 `json:"deletions"`
+	ReviewKeptScrobbles         int64 `json:"reviewKeptScrobbles"`
+	SampleDeletions             int64 `json:"sampleDeletions"`
+	SampleDryRunScrobbles       int64 `json:"sampleDryRunScrobbles"`
+}
+
+func (s *stats) snapshot() runStatsJSON {
+	return runStatsJSON{
+		CacheHits:                   s.cacheHits.Load(),
+		CacheMisses:                 s.cacheMisses.Load(),
+		ProcessedScrobbles:          s.processedScrobbles.Load(),
+		UnknownTrackDurationsCount:  s.unknownTrackDurationsCount.Load(),
+		DurationLookups:             s.durationLookups.Load(),
+		DurationLookupTime:          time.Duration(s.durationLookupTime.Load()).Milliseconds(),
+		SkippedDurationNotFound:     s.skippedDurationNotFound.Load(),
+		SkippedKnownUnknownDuration: s.skippedKnownUnknownDuration.Load(),
+		SkippedDurationLookupError:  s.skippedDurationLookupError.Load(),
+		FutureScrobbles:             s.futureScrobbles.Load(),
+		NotSurroundedSkips:          s.notSurroundedSkips.Load(),
+		LastOfTrackKept:             s.lastOfTrackKept.Load(),
+		LookupTimeouts:              s.lookupTimeouts.Load(),
+		UncomparedScrobbles:         s.uncomparedScrobbles.Load(),
+		CorruptedCacheEntries:       s.corruptedCacheEntries.Load(),
+		ImportedDeletionsNotFound:   s.importedDeletionsNotFound.Load(),
+		ScrobbleDeleteFails:         s.scrobbleDeleteFails.Load(),
+		Deletions:                   s.deletions.Load(),
+		ReviewKeptScrobbles:         s.reviewKeptScrobbles.Load(),
+		SampleDeletions:             s.sampleDeletions.Load(),
+		SampleDryRunScrobbles:       s.sampleDryRunScrobbles.Load(),
+	}
+}
+
+// runWebhookPayload is posted to webhook-url at the end of a run, deleted scrobbles are the would-be deleted ones of
+// a dry run
+type runWebhookPayload struct {
+	Username         string                 `json:"username"`
+	StartTime        time.Time              `json:"startTime"`
+	ElapsedTime      string                 `json:"elapsedTime"`
+	Delete           bool                   `json:"delete"`
+	Completed        bool                   `json:"completed"`
+	Stats            runStatsJSON           `json:"stats"`
+	DeletedCount     int                    `json:"deletedCount"`
+	DeletedScrobbles []exportedScrobbleJSON `json:"deletedScrobbles"`
+}
+
+func newRunWebhookPayload(c *Config) runWebhookPayload {
+	return runWebhookPayload{
+		Username:         c.LastFMUsername,
+		StartTime:        c.startTime,
+		ElapsedTime:      c.runStats.elapsedTime.Truncate(time.Millisecond).String(),
+		Delete:           c.canDelete,
+		Completed:        c.runCompleted,
+		Stats:            c.runStats.snapshot(),
+		DeletedCount:     len(c.deletedScrobbles),
+		DeletedScrobbles: scrobblesJSON(c.deletedScrobbles),
+	}
+}
+
+// parseHeaders reads headers in the "Name: value" form of webhook-header and browser-header
+func parseHeaders(headers []string) (http.Header, error) {
+	parsed := make(http.Header, len(headers))
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("header must be in the Name: value form: %q", header)
+		}
+		parsed.Add(name, strings.TrimSpace(value))
+	}
+	return parsed, nil
+}
+
+// postRunWebhook posts the statistics and the deleted scrobbles of the run to webhook-url
+func postRunWebhook(ctx context.Context, c *Config) error {
+	body, err := json.Marshal(newRunWebhookPayload(c))
+	if err != nil {
+		return fmt.Errorf("failed to marshal run webhook payload: %w", err)
+	}
+
+	client := &http.Client{Timeout: runWebhookTimeout}
+	_, err = backoff.Retry(ctx, func() (struct{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return struct{}{}, backoff.Permanent(fmt.Errorf("failed to create request: %w", err))
+		}
+		for name, values := range c.webhookHeaders {
+			req.Header[name] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return struct{}{}, err
+		}
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				slog.Error(err.Error())
+			}
+		}()
+
+		if resp.StatusCode >= 300 {
+			err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			// Client errors, like a wrong authentication header, fail the same way when retried
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return struct{}{}, backoff.Permanent(err)
+			}
+			return struct{}{}, err
+		}
+		return struct{}{}, nil
+	}, backoff.WithMaxTries(3))
+	return err
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestRunStatsJSONFields checks that every counter of the run statistics is sent to the run webhook
+func TestRunStatsJSONFields(t *testing.T) {
+	counterType := reflect.TypeFor[atomic.Int64]()
+	statsType := reflect.TypeFor[stats]()
+	jsonType := reflect.TypeFor[runStatsJSON]()
+
+	var counters int
+	for field := range statsType.Fields() {
+		if field.Type != counterType {
+			continue
+		}
+		counters++
+		name := strings.ToUpper(field.Name[:1]) + field.Name[1:]
+		if _, ok := jsonType.FieldByName(name); !ok {
+			t.Errorf("runStatsJSON has no %s field for stats.%s", name, field.Name)
+		}
+	}
+	if counters != jsonType.NumField() {
+		t.Errorf("runStatsJSON has %d fields, expected %d", jsonType.NumField(), counters)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name        string
+		headers     []string
+		expected    http.Header
+		expectedErr bool
+	}{
+		{name: "none", expected: http.Header{}},
+		{name: "single", headers: []string{"Authorization: Bearer token"}, expected: http.Header{"Authorization": {"Bearer token"}}},
+		{name: "canonical name", headers: []string{"x-api-key:secret"}, expected: http.Header{"X-Api-Key": {"secret"}}},
+		{name: "repeated", headers: []string{"X-Tag: a", "X-Tag: b"}, expected: http.Header{"X-Tag": {"a", "b"}}},
+		{name: "value with colon", headers: []string{"X-Url: http://host:80"}, expected: http.Header{"X-Url": {"http://host:80"}}},
+		{name: "no colon", headers: []string{"Authorization"}, expectedErr: true},
+		{name: "empty name", headers: []string{": value"}, expectedErr: true},
+		{name: "name with space", headers: []string{"X Tag: value"}, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaders(tt.headers)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("parseHeaders(%q) error = %v, expected error %v", tt.headers, err, tt.expectedErr)
+			}
+			if !tt.expectedErr && !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseHeaders(%q) = %v, expected %v", tt.headers, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPostRunWebhook(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		expectedErr      bool
+		expectedRequests int64
+	}{
+		{name: "accepted", status: http.StatusNoContent, expectedRequests: 1},
+		// Client errors are not retried
+		{name: "rejected", status: http.StatusUnauthorized, expectedErr: true, expectedRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				requests atomic.Int64
+				payload  runWebhookPayload
+				header   http.Header
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				header = r.Header.Clone()
+				if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+					t.Errorf("failed to decode payload: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			previous, _ := testScrobblePair(0, 0)
+			c := &Config{
+				LastFMUsername:   "alice",
+				WebhookURL:       server.URL,
+				webhookHeaders:   http.Header{"Authorization": {"Bearer token"}},
+				deletedScrobbles: []*scrobble{previous},
+			}
+			c.runStats.deletions.Store(1)
+
+			err := postRunWebhook(context.Background(), c)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("postRunWebhook() error = %v, expected error %v", err, tt.expectedErr)
+			}
+			if got := requests.Load(); got != tt.expectedRequests {
+				t.Errorf("requests = %d, expected %d", got, tt.expectedRequests)
+			}
+			if got := header.Get("Authorization"); got != "Bearer token" {
+				t.Errorf("Authorization header = %q, expected %q", got, "Bearer token")
+			}
+			if got := header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type header = %q, expected %q", got, "application/json")
+			}
+			if payload.Username != "alice" || payload.DeletedCount != 1 || payload.Stats.Deletions != 1 {
+				t.Errorf("payload = %+v, expected alice with 1 deleted scrobble", payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+)
+
+const scanTopTracks = 10
+
+type scanStats struct {
+	scrobbles     int
+	playCounts    map[trackKey]int
+	unknownTracks map[trackKey]struct{}
+}
+
+// Scan walks the library pages and resolves track durations to report library statistics,
+// without detecting or deleting any scrobble
+func Scan(ctx context.Context, c *Config, w io.Writer) error {
+	c.scanOnly = true
+	c.scanOutput = w
+	c.scan = scanStats{
+		playCounts:    make(map[trackKey]int),
+		unknownTracks: make(map[trackKey]struct{}),
+	}
+	return Run(ctx, c)
+}
+
+func scanScrobble(ctx context.Context, c *Config, s *scrobble, userTrackDurations durationByTrackByArtist) {
+	c.scan.scrobbles++
+	key := trackKey{s.artist, s.track}
+	c.scan.playCounts[key]++
+	if c.scan.playCounts[key] > 1 {
+		return
+	}
+
+	if err := getTrackDuration(ctx, c, userTrackDurations, s); err != nil {
+		slog.Debug("Unknown track duration", "artist", s.artist, "track", s.track, "error", err)
+		c.scan.unknownTracks[key] = struct{}{}
+	}
+}
+
+func printScanReport(w io.Writer, stats scanStats) {
+	fmt.Fprintf(w, "Scrobbles: %d\n", stats.scrobbles)
+	fmt.Fprintf(w, "Unique tracks: %d\n", len(stats.playCounts))
+	fmt.Fprintf(w, "Tracks with unknown duration: %d\n", len(stats.unknownTracks))
+
+	tracks := make([]trackKey, 0, len(stats.playCounts))
+	for key := range stats.playCounts {
+		tracks = append(tracks, key)
+	}
+	slices.SortFunc(tracks, func(a, b trackKey) int {
+		return cmp.Or(
+			cmp.Compare(stats.playCounts[b], stats.playCounts[a]),
+			cmp.Compare(a.artist, b.artist),
+			cmp.Compare(a.track, b.track),
+		)
+	})
+
+	fmt.Fprintln(w, "Most scrobbled tracks:")
+	for i, key := range tracks[:min(scanTopTracks, len(tracks))] {
+		fmt.Fprintf(w, "%d. %s - %s: %d\n", i+1, key.artist, key.track, stats.playCounts[key])
+	}
+}
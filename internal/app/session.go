@@ -0,0 +1,20 @@
+package app
+
+import (
+	"log/slog"
+	"time"
+)
+
+// startsNewSession reports whether a listening break longer than the session gap separates two successive scrobbles,
+// the scrobble before the break is then not compared with the one after it
+func startsNewSession(c *Config, previousScrobble *scrobble, currentScrobble *scrobble) bool {
+	if c.SessionGap <= 0 {
+		return false
+	}
+	gap := currentScrobble.timestamp.Sub(previousScrobble.timestamp)
+	if gap <= c.SessionGap {
+		return false
+	}
+	slog.Debug("New listening session", "artist", currentScrobble.artist, "track", currentScrobble.track, "timestamp", currentScrobble.timestamp, "gap", gap.Truncate(time.Second))
+	return true
+}
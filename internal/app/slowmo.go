@@ -0,0 +1,18 @@
+package app
+
+import (
+	"context"
+
+	"github.com/chromedp/chromedp"
+	"github.com/cterence/scrobble-deduplicator/internal/helpers"
+)
+
+// slowMo pauses before a browser action so that it can be followed in a headful browser
+func slowMo(c *Config) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if c.SlowMo <= 0 {
+			return nil
+		}
+		return helpers.SleepContext(ctx, c.SlowMo)
+	})
+}
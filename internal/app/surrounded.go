@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+)
+
+// pendingSkip is an incomplete scrobble waiting for the following scrobble to know whether it is surrounded by complete plays
+type pendingSkip struct {
+	skipped  *scrobble
+	previous *scrobble
+}
+
+// deferSkip holds an incomplete scrobble until the next one is processed, it is kept right away when the previous
+// scrobble is not a complete play of another track
+func deferSkip(c *Config, previousScrobble *scrobble, currentScrobble *scrobble) {
+	if !previousScrobble.complete || isSameTrack(previousScrobble, currentScrobble) {
+		keepNotSurroundedSkip(c, currentScrobble)
+		return
+	}
+	c.pendingSkip = &pendingSkip{skipped: currentScrobble, previous: previousScrobble}
+}
+
+// resolvePendingSkip handles the pending incomplete scrobble as incomplete if the next scrobble is a complete play of another track.
+// nextScrobble is nil when no scrobble follows the pending one.
+func resolvePendingSkip(ctx context.Context, c *Config, nextScrobble *scrobble) {
+	pending := c.pendingSkip
+	if pending == nil {
+		return
+	}
+	c.pendingSkip = nil
+
+	if nextScrobble == nil || !nextScrobble.complete || isSameTrack(pending.skipped, nextScrobble) {
+		keepNotSurroundedSkip(c, pending.skipped)
+		return
+	}
+	if _, err := handleDetectedScrobble(ctx, c, pending.skipped, pending.previous, true, "incomplete"); err != nil {
+		slog.Warn("failed to delete scrobble", "error", err)
+	}
+}
+
+func keepNotSurroundedSkip(c *Config, s *scrobble) {
+	slog.Info("Keeping incomplete scrobble not surrounded by complete plays of other tracks", "artist", s.artist, "track", s.track, "timestamp", s.timestamp)
+	c.runStats.notSurroundedSkips.Add(1)
+}
+
+func isSameTrack(a *scrobble, b *scrobble) bool {
+	return a.artist == b.artist && a.track == b.track
+}
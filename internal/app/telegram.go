@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const telegramMaxMessageLength = 4096
+
+func sendTelegramMessage(ctx context.Context, c *Config, message string) error {
+	for _, chunk := range splitTelegramMessage(message, telegramMaxMessageLength) {
+		params := &bot.SendMessageParams{
+			ParseMode:       models.ParseModeMarkdown,
+			ChatID:          c.TelegramChatID,
+			MessageThreadID: c.TelegramMessageThreadID,
+			Text:            bot.EscapeMarkdown(chunk),
+		}
+		_, err := c.telegramBot.SendMessage(ctx, params)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitTelegramMessage splits a message on line boundaries into chunks that stay under
+// the length limit once escaped, lines too long to fit in a chunk are split themselves
+func splitTelegramMessage(message string, limit int) []string {
+	escapedLength := func(s string) int {
+		return utf8.RuneCountInString(bot.EscapeMarkdown(s))
+	}
+
+	if escapedLength(message) <= limit {
+		return []string{message}
+	}
+
+	var (
+		chunks  []string
+		current strings.Builder
+	)
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, line := range strings.Split(message, "\n") {
+		for _, part := range splitLongLine(line, limit, escapedLength) {
+			candidate := part
+			if current.Len() > 0 {
+				candidate = current.String() + "\n" + part
+			}
+			if escapedLength(candidate) > limit {
+				flush()
+				candidate = part
+			}
+			current.Reset()
+			current.WriteString(candidate)
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+func splitLongLine(line string, limit int, escapedLength func(string) int) []string {
+	var parts []string
+	for escapedLength(line) > limit {
+		runes := []rune(line)
+		// Escaping at most doubles the length of a string
+		end := limit / 2
+		for end < len(runes) && escapedLength(string(runes[:end+1])) <= limit {
+			end++
+		}
+		parts = append(parts, string(runes[:end]))
+		line = string(runes[end:])
+	}
+	return append(parts, line)
+}
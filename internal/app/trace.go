@@ -0,0 +1,62 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+const tracesDir = "traces"
+
+const redactedValue = "REDACTED"
+
+// Forms of the library rows embed the CSRF token tied to the session
+var csrfTokenRegexp = regexp.MustCompile(`(name=["']csrfmiddlewaretoken["'][^>]*value=["'])[^"']*`)
+
+func pageTraceFile(c *Config, page int) string {
+	return path.Join(c.DataDir, tracesDir, c.startTime.Format("20060102-150405"), fmt.Sprintf("page-%d.html", page))
+}
+
+// savePageTrace stores the HTML of the loaded library page so that selector breakage can be reproduced offline
+func savePageTrace(ctx context.Context, c *Config, page int) error {
+	var html string
+	if err := chromedp.Run(ctx, chromedp.OuterHTML("html", &html, chromedp.ByQuery)); err != nil {
+		return fmt.Errorf("failed to get page HTML: %w", err)
+	}
+
+	cookies, err := getCookies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get cookies: %w", err)
+	}
+	cookieValues := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		cookieValues = append(cookieValues, cookie.Value)
+	}
+
+	filename := pageTraceFile(c, page)
+	if err := os.MkdirAll(path.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create traces directory: %w", err)
+	}
+	if err := os.WriteFile(filename, []byte(redactTrace(html, cookieValues)), 0644); err != nil {
+		return fmt.Errorf("failed to write page trace: %w", err)
+	}
+	return nil
+}
+
+// redactTrace removes the cookie values and CSRF tokens from a page so that the trace can be shared
+func redactTrace(html string, cookieValues []string) string {
+	html = csrfTokenRegexp.ReplaceAllString(html, "${1}"+redactedValue)
+	for _, value := range cookieValues {
+		// Short values like consent flags would redact unrelated text
+		if len(value) < 8 {
+			continue
+		}
+		html = strings.ReplaceAll(html, value, redactedValue)
+	}
+	return html
+}
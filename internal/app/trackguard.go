@@ -0,0 +1,24 @@
+package app
+
+import "log/slog"
+
+// countProcessedScrobble counts the scrobbles of each track seen by the run, in order to keep one of them
+func countProcessedScrobble(c *Config, s *scrobble) {
+	c.processedByTrack[trackKey{s.artist, s.track}]++
+}
+
+// isLastRemainingScrobble reports whether deleting a scrobble would leave no scrobble of its track among the seen ones
+func isLastRemainingScrobble(c *Config, s *scrobble) bool {
+	key := trackKey{s.artist, s.track}
+	return c.processedByTrack[key]-c.detectedByTrack[key] <= 1
+}
+
+// keepLastRemainingScrobble reports whether the scrobble must be kept as the last one of its track, when the guard is enabled
+func keepLastRemainingScrobble(c *Config, s *scrobble, reason string) bool {
+	if !c.KeepOnePerTrack || !isLastRemainingScrobble(c, s) {
+		return false
+	}
+	slog.Warn("Keeping the last remaining scrobble of a track", "reason", reason, "artist", s.artist, "track", s.track, "timestamp", s.timestamp)
+	c.runStats.lastOfTrackKept.Add(1)
+	return true
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+type warmCacheStats struct {
+	tracks   map[trackKey]struct{}
+	resolved int
+	unknown  int
+}
+
+// WarmCache walks the library pages and resolves the duration of every track into the cache,
+// without detecting or deleting any scrobble, so that a later run does not wait for MusicBrainz
+func WarmCache(ctx context.Context, c *Config, w io.Writer) error {
+	if c.CacheType == "inmemory" {
+		return errors.New("the inmemory cache is not persisted between runs, use the file or redis cache type to warm it")
+	}
+
+	c.warmCacheOnly = true
+	c.warmCacheOutput = w
+	c.warmCache = warmCacheStats{
+		tracks: make(map[trackKey]struct{}),
+	}
+	return Run(ctx, c)
+}
+
+func warmCacheScrobble(ctx context.Context, c *Config, s *scrobble, userTrackDurations durationByTrackByArtist) {
+	key := trackKey{s.artist, s.track}
+	if _, found := c.warmCache.tracks[key]; found {
+		return
+	}
+	c.warmCache.tracks[key] = struct{}{}
+
+	if err := getTrackDuration(ctx, c, userTrackDurations, s); err != nil {
+		slog.Debug("Unknown track duration", "artist", s.artist, "track", s.track, "error", err)
+		c.warmCache.unknown++
+		return
+	}
+	c.warmCache.resolved++
+}
+
+func printWarmCacheReport(w io.Writer, c *Config) {
+	fmt.Fprintf(w, "Unique tracks: %d\n", len(c.warmCache.tracks))
+	fmt.Fprintf(w, "Tracks with a known duration: %d\n", c.warmCache.resolved)
+	fmt.Fprintf(w, "Tracks with unknown duration: %d\n", c.warmCache.unknown)
+	fmt.Fprintf(w, "Durations already cached: %d\n", c.runStats.cacheHits.Load())
+	fmt.Fprintf(w, "Durations looked up: %d\n", c.runStats.cacheMisses.Load())
+}
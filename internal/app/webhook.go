@@ -0,0 +1,131 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	deletionWebhookWorkers   = 4
+	deletionWebhookQueueSize = 100
+	deletionWebhookTimeout   = 10 * time.Second
+)
+
+type deletionEvent struct {
+	Artist    string    `json:"artist"`
+	Track     string    `json:"track"`
+	Timestamp time.Time `json:"timestamp"`
+	URL       string    `json:"url"`
+	Reason    string    `json:"reason"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// deletionWebhook posts deletion events to a URL from a fixed pool of workers,
+// dropping events instead of blocking when the queue is full
+type deletionWebhook struct {
+	url     string
+	client  *http.Client
+	events  chan deletionEvent
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+func newDeletionWebhook(url string) *deletionWebhook {
+	w := &deletionWebhook{
+		url:    url,
+		client: &http.Client{Timeout: deletionWebhookTimeout},
+		events: make(chan deletionEvent, deletionWebhookQueueSize),
+	}
+
+	for range deletionWebhookWorkers {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for event := range w.events {
+				if err := w.post(event); err != nil {
+					slog.Warn("Failed to send deletion webhook event", "error", err)
+				}
+			}
+		}()
+	}
+
+	return w
+}
+
+func (w *deletionWebhook) notify(s *scrobble, reason string) {
+	event := deletionEvent{
+		Artist:    s.artist,
+		Track:     s.track,
+		Timestamp: s.timestamp,
+		URL:       s.url,
+		Reason:    reason,
+		DeletedAt: time.Now(),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		w.dropped++
+		return
+	}
+
+	select {
+	case w.events <- event:
+	default:
+		w.dropped++
+		slog.Debug("Deletion webhook queue full, dropping event", "artist", s.artist, "track", s.track)
+	}
+}
+
+func (w *deletionWebhook) post(event deletionEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deletionWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// close stops accepting events and waits for queued events to be sent
+func (w *deletionWebhook) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.events)
+	w.mu.Unlock()
+
+	w.wg.Wait()
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeletionWebhook(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []string
+	}{
+		{name: "no events"},
+		{name: "single event", tracks: []string{"Song"}},
+		{name: "events sent by several workers", tracks: []string{"A", "B", "C", "D", "E", "F"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				events []deletionEvent
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var event deletionEvent
+				if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+					t.Errorf("failed to decode event: %v", err)
+				}
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			w := newDeletionWebhook(server.URL)
+			timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			for _, track := range tt.tracks {
+				w.notify(&scrobble{artist: "Artist", track: track, timestamp: timestamp}, "duplicate")
+			}
+			// Closing waits for the queued events to be sent
+			w.close()
+
+			if len(events) != len(tt.tracks) {
+				t.Fatalf("received %d events, expected %d", len(events), len(tt.tracks))
+			}
+			sort.Slice(events, func(i, j int) bool { return events[i].Track < events[j].Track })
+			for i, event := range events {
+				if event.Artist != "Artist" || event.Track != tt.tracks[i] || !event.Timestamp.Equal(timestamp) || event.Reason != "duplicate" {
+					t.Errorf("event = %+v, expected Artist - %s duplicate at %s", event, tt.tracks[i], timestamp)
+				}
+			}
+		})
+	}
+}
+
+func TestDeletionWebhookAfterClose(t *testing.T) {
+	w := newDeletionWebhook("http://127.0.0.1:0")
+	w.close()
+	// Closing twice and notifying a closed webhook must not panic
+	w.close()
+	w.notify(&scrobble{artist: "Artist", track: "Song"}, "duplicate")
+
+	if w.dropped != 1 {
+		t.Errorf("dropped = %d, expected 1", w.dropped)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,50 +17,92 @@ import (
 )
 
 type Cache interface {
+	// Get returns ErrCacheMiss when the key is not cached
 	Get(ctx context.Context, key string) (string, error)
 	Set(ctx context.Context, key string, value string) error
+	// SetWithTTL caches a value that expires after ttl, a ttl of 0 never expires
+	SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error
+	// Delete removes a key, deleting a key that is not cached is not an error
 	Delete(ctx context.Context, key string) error
+	// Entries returns the values of all the keys that are not expired
+	Entries(ctx context.Context) (map[string]string, error)
 	Close()
 }
 
+// entry is a cached value with its expiry, a zero expiry never expires
+type entry struct {
+	value     string
+	expiresAt time.Time
+}
+
+func newEntry(value string, ttl time.Duration) entry {
+	e := entry{value: value}
+	if ttl > 0 {
+		e.expiresAt = time.Now().Add(ttl)
+	}
+	return e
+}
+
+func (e entry) expired() bool {
+	return !e.expiresAt.IsZero() && time.Now().After(e.expiresAt)
+}
+
+func liveEntries(data map[string]entry) map[string]string {
+	entries := make(map[string]string, len(data))
+	for key, e := range data {
+		if !e.expired() {
+			entries[key] = e.value
+		}
+	}
+	return entries
+}
+
 type InMemory struct {
-	cache map[string]string
+	cache map[string]entry
 }
 
 type Redis struct {
 	client *redis.Client
+	// Prefixes of the keys of the cache, the database may be shared with other applications
+	keyPrefixes []string
 }
 
 type File struct {
 	mu   sync.Mutex
 	file *os.File
 	path string
-	data map[string]string
+	data map[string]entry
 
 	flushCh  chan struct{}
 	stopCh   chan struct{}
 	interval time.Duration
 	wg       sync.WaitGroup
+	// Error of the last periodic flush, nil once a flush succeeds
+	flushErr error
 }
 
 var ErrCacheMiss = errors.New("cache miss")
 
 func NewInMemory() Cache {
 	return &InMemory{
-		cache: make(map[string]string),
+		cache: make(map[string]entry),
 	}
 }
 
 func (c *InMemory) Get(_ context.Context, key string) (string, error) {
-	value, exists := c.cache[key]
-	if !exists {
+	e, exists := c.cache[key]
+	if !exists || e.expired() {
 		return "", ErrCacheMiss
 	}
-	return value, nil
+	return e.value, nil
+}
+
+func (c *InMemory) Set(ctx context.Context, key string, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
 }
 
-func (c *InMemory) Set(_ context.Context, key string, value string) error {
-	c.cache[key] = value
+func (c *InMemory) SetWithTTL(_ context.Context, key string, value string, ttl time.Duration) error {
+	c.cache[key] = newEntry(value, ttl)
 	return nil
 }
 
@@ -68,11 +111,17 @@ func (c *InMemory) Delete(_ context.Context, key string) error {
 	return nil
 }
 
+func (c *InMemory) Entries(_ context.Context) (map[string]string, error) {
+	return liveEntries(c.cache), nil
+}
+
 func (c *InMemory) Close() {}
 
-func NewRedis(redisClient *redis.Client) Cache {
+// NewRedis creates a cache whose Entries are the keys starting with one of keyPrefixes, or all the keys without prefixes
+func NewRedis(redisClient *redis.Client, keyPrefixes ...string) Cache {
 	return &Redis{
-		client: redisClient,
+		client:      redisClient,
+		keyPrefixes: keyPrefixes,
 	}
 }
 
@@ -88,7 +137,11 @@ func (c *Redis) Get(ctx context.Context, key string) (string, error) {
 }
 
 func (c *Redis) Set(ctx context.Context, key string, value string) error {
-	return c.client.Set(ctx, key, value, 0).Err()
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *Redis) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
 }
 
 func (c *Redis) Close() {
@@ -101,6 +154,36 @@ func (c *Redis) Delete(ctx context.Context, key string) error {
 	return c.client.Del(ctx, key).Err()
 }
 
+func (c *Redis) Entries(ctx context.Context) (map[string]string, error) {
+	patterns := []string{"*"}
+	if len(c.keyPrefixes) > 0 {
+		patterns = make([]string, 0, len(c.keyPrefixes))
+		for _, prefix := range c.keyPrefixes {
+			patterns = append(patterns, prefix+"*")
+		}
+	}
+
+	entries := make(map[string]string)
+	for _, pattern := range patterns {
+		iter := c.client.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			value, err := c.client.Get(ctx, iter.Val()).Result()
+			if err != nil {
+				// The key expired or was deleted since it was scanned
+				if err == redis.Nil {
+					continue
+				}
+				return nil, err
+			}
+			entries[iter.Val()] = value
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
 const CacheFileName = "cache.db"
 
 func NewFile(path string, flushInterval time.Duration) (Cache, error) {
@@ -112,7 +195,7 @@ func NewFile(path string, flushInterval time.Duration) (Cache, error) {
 	cache := &File{
 		file:     f,
 		path:     path,
-		data:     make(map[string]string),
+		data:     make(map[string]entry),
 		flushCh:  make(chan struct{}, 1),
 		stopCh:   make(chan struct{}),
 		interval: flushInterval,
@@ -130,42 +213,130 @@ func NewFile(path string, flushInterval time.Duration) (Cache, error) {
 }
 
 func (c *File) load() error {
-	c.data = make(map[string]string)
-
-	f, err := os.Open(c.path)
+	data, stats, err := readFile(c.path)
 	if err != nil {
 		return err
 	}
+	if stats.MalformedLines > 0 {
+		slog.Warn("Ignored malformed lines in cache file", "file", c.path, "count", stats.MalformedLines)
+	}
+	c.data = data
+	return nil
+}
+
+// FileStats describes the content of a cache file before compaction
+type FileStats struct {
+	TotalLines     int
+	UniqueKeys     int
+	DuplicateKeys  int
+	MalformedLines int
+}
+
+// readFile parses the key=value lines of a cache file, a value with an expiry is followed by a tab and its Unix time
+func readFile(path string) (map[string]entry, FileStats, error) {
+	var stats FileStats
+	data := make(map[string]entry)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, stats, err
+	}
 	defer helpers.CloseFile(f)
 
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		line := scanner.Text()
+		stats.TotalLines++
 		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			c.data[parts[0]] = parts[1]
+		if len(parts) != 2 || parts[0] == "" {
+			stats.MalformedLines++
+			continue
+		}
+		e, ok := parseEntry(parts[1])
+		if !ok {
+			stats.MalformedLines++
+			continue
 		}
+		if _, found := data[parts[0]]; found {
+			stats.DuplicateKeys++
+		}
+		data[parts[0]] = e
+	}
+	stats.UniqueKeys = len(data)
+
+	return data, stats, scanner.Err()
+}
+
+func parseEntry(s string) (entry, bool) {
+	value, expiresAt, hasExpiry := strings.Cut(s, "\t")
+	if !hasExpiry {
+		return entry{value: value}, true
+	}
+	unix, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil {
+		return entry{}, false
+	}
+	return entry{value: value, expiresAt: time.Unix(unix, 0)}, true
+}
+
+func formatEntry(e entry) string {
+	if e.expiresAt.IsZero() {
+		return e.value
 	}
-	return scanner.Err()
+	return fmt.Sprintf("%s\t%d", e.value, e.expiresAt.Unix())
+}
+
+// CompactFile rewrites a cache file with only the latest value of each key,
+// dropping malformed lines, and optionally keeps a copy of the original file
+func CompactFile(path string, backup bool) (FileStats, error) {
+	_, stats, err := readFile(path)
+	if err != nil {
+		return stats, fmt.Errorf("failed to read cache file: %w", err)
+	}
+
+	if backup {
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return stats, fmt.Errorf("failed to read cache file for backup: %w", err)
+		}
+		backupPath := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102-150405"))
+		if err := os.WriteFile(backupPath, original, 0666); err != nil {
+			return stats, fmt.Errorf("failed to write cache file backup: %w", err)
+		}
+		slog.Info("Backed up cache file", "file", backupPath)
+	}
+
+	cache, err := NewFile(path, FileCacheFlushTicker)
+	if err != nil {
+		return stats, fmt.Errorf("failed to open file cache: %w", err)
+	}
+	// Closing the cache flushes it, which rewrites the file with its deduplicated content
+	cache.Close()
+
+	return stats, nil
 }
 
 func (c *File) Get(ctx context.Context, key string) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	val, ok := c.data[key]
-	if !ok {
+	e, ok := c.data[key]
+	if !ok || e.expired() {
 		return "", ErrCacheMiss
 	}
-	return val, nil
+	return e.value, nil
 }
 
 func (c *File) Set(ctx context.Context, key string, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *File) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	// update in-memory map
-	c.data[key] = value
+	c.data[key] = newEntry(value, ttl)
 
 	return nil
 }
@@ -181,10 +352,29 @@ func (c *File) Close() {
 }
 
 func (c *File) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The key is removed from the file by the next flush
 	delete(c.data, key)
 	return nil
 }
 
+func (c *File) Entries(_ context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return liveEntries(c.data), nil
+}
+
+// LastFlushError returns the error of the last periodic flush, cached values are not persisted while it fails
+func (c *File) LastFlushError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.flushErr
+}
+
 const FileCacheFlushTicker = 30 * time.Second
 
 // Flush compacts the append-only log by rewriting only latest values
@@ -198,8 +388,12 @@ func (c *File) Flush() error {
 		return err
 	}
 
-	for k, v := range c.data {
-		if _, err := fmt.Fprintf(tmpFile, "%s=%s\n", k, v); err != nil {
+	for k, e := range c.data {
+		// Expired values would be misses anyway
+		if e.expired() {
+			continue
+		}
+		if _, err := fmt.Fprintf(tmpFile, "%s=%s\n", k, formatEntry(e)); err != nil {
 			errClose := tmpFile.Close()
 			if errClose != nil {
 				return errClose
@@ -250,9 +444,13 @@ func (c *File) startFlusher() {
 		for {
 			select {
 			case <-ticker.C:
-				if err := c.Flush(); err != nil {
+				err := c.Flush()
+				if err != nil {
 					slog.Error("periodic flush failed", "error", err)
 				}
+				c.mu.Lock()
+				c.flushErr = err
+				c.mu.Unlock()
 			case <-c.stopCh:
 				return
 			}
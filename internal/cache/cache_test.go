@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backends opens each cache type that does not need a server, in a temporary directory
+var backends = []struct {
+	name string
+	open func(t *testing.T, dir string) Cache
+}{
+	{name: "in-memory", open: func(t *testing.T, _ string) Cache { return NewInMemory() }},
+	{name: "file", open: func(t *testing.T, dir string) Cache {
+		c, err := NewFile(filepath.Join(dir, CacheFileName), FileCacheFlushTicker)
+		if err != nil {
+			t.Fatalf("NewFile() error = %v", err)
+		}
+		return c
+	}},
+	{name: "sqlite", open: func(t *testing.T, dir string) Cache {
+		c, err := NewSQLite(filepath.Join(dir, SQLiteFileName))
+		if err != nil {
+			t.Fatalf("NewSQLite() error = %v", err)
+		}
+		return c
+	}},
+}
+
+func TestCacheGet(t *testing.T) {
+	tests := []struct {
+		name        string
+		set         func(ctx context.Context, c Cache) error
+		expected    string
+		expectedErr error
+	}{
+		{name: "miss", set: func(context.Context, Cache) error { return nil }, expectedErr: ErrCacheMiss},
+		{name: "set", set: func(ctx context.Context, c Cache) error { return c.Set(ctx, "key", "value") }, expected: "value"},
+		{
+			name: "overwritten",
+			set: func(ctx context.Context, c Cache) error {
+				if err := c.Set(ctx, "key", "old"); err != nil {
+					return err
+				}
+				return c.Set(ctx, "key", "new")
+			},
+			expected: "new",
+		},
+		{name: "ttl not reached", set: func(ctx context.Context, c Cache) error { return c.SetWithTTL(ctx, "key", "value", time.Hour) }, expected: "value"},
+		{name: "expired", set: func(ctx context.Context, c Cache) error { return c.SetWithTTL(ctx, "key", "value", time.Nanosecond) }, expectedErr: ErrCacheMiss},
+		{
+			name: "deleted",
+			set: func(ctx context.Context, c Cache) error {
+				if err := c.Set(ctx, "key", "value"); err != nil {
+					return err
+				}
+				return c.Delete(ctx, "key")
+			},
+			expectedErr: ErrCacheMiss,
+		},
+		{name: "deleting a missing key", set: func(ctx context.Context, c Cache) error { return c.Delete(ctx, "key") }, expectedErr: ErrCacheMiss},
+	}
+	for _, backend := range backends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				c := backend.open(t, t.TempDir())
+				defer c.Close()
+
+				if err := tt.set(ctx, c); err != nil {
+					t.Fatalf("failed to set up cache: %v", err)
+				}
+				// Let the shortest ttl pass
+				time.Sleep(time.Millisecond)
+
+				got, err := c.Get(ctx, "key")
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("Get() error = %v, expected %v", err, tt.expectedErr)
+				}
+				if got != tt.expected {
+					t.Errorf("Get() = %q, expected %q", got, tt.expected)
+				}
+			})
+		}
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := backend.open(t, t.TempDir())
+			defer c.Close()
+
+			for key, value := range map[string]string{"a": "1", "b": "2"} {
+				if err := c.Set(ctx, key, value); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+			if err := c.SetWithTTL(ctx, "live", "3", time.Hour); err != nil {
+				t.Fatalf("SetWithTTL() error = %v", err)
+			}
+			if err := c.SetWithTTL(ctx, "expired", "4", time.Nanosecond); err != nil {
+				t.Fatalf("SetWithTTL() error = %v", err)
+			}
+			time.Sleep(time.Millisecond)
+
+			got, err := c.Entries(ctx)
+			if err != nil {
+				t.Fatalf("Entries() error = %v", err)
+			}
+			if expected := map[string]string{"a": "1", "b": "2", "live": "3"}; !maps.Equal(got, expected) {
+				t.Errorf("Entries() = %v, expected %v", got, expected)
+			}
+		})
+	}
+}
+
+// TestCachePersistence reopens the caches that are stored in a file
+func TestCachePersistence(t *testing.T) {
+	for _, backend := range backends[1:] {
+		t.Run(backend.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			c := backend.open(t, dir)
+			if err := c.Set(ctx, "key", "value"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := c.SetWithTTL(ctx, "ttl", "value", time.Hour); err != nil {
+				t.Fatalf("SetWithTTL() error = %v", err)
+			}
+			c.Close()
+
+			c = backend.open(t, dir)
+			defer c.Close()
+			got, err := c.Entries(ctx)
+			if err != nil {
+				t.Fatalf("Entries() error = %v", err)
+			}
+			if expected := map[string]string{"key": "value", "ttl": "value"}; !maps.Equal(got, expected) {
+				t.Errorf("Entries() = %v, expected %v", got, expected)
+			}
+		})
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	expiresAt := time.Unix(1700000000, 0)
+	tests := []struct {
+		name       string
+		text       string
+		expected   entry
+		expectedOK bool
+	}{
+		{name: "without expiry", text: "245000", expected: entry{value: "245000"}, expectedOK: true},
+		{name: "empty value", text: "", expected: entry{}, expectedOK: true},
+		{name: "with expiry", text: "245000\t1700000000", expected: entry{value: "245000", expiresAt: expiresAt}, expectedOK: true},
+		{name: "invalid expiry", text: "245000\tsoon", expectedOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseEntry(tt.text)
+			if ok != tt.expectedOK {
+				t.Fatalf("parseEntry(%q) ok = %v, expected %v", tt.text, ok, tt.expectedOK)
+			}
+			if got != tt.expected {
+				t.Errorf("parseEntry(%q) = %+v, expected %+v", tt.text, got, tt.expected)
+			}
+			if ok && formatEntry(got) != tt.text {
+				t.Errorf("formatEntry() = %q, expected %q", formatEntry(got), tt.text)
+			}
+		})
+	}
+}
+
+func TestCompactFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CacheFileName)
+	content := "a=1\nb=2\na=3\nmalformed\n=4\nc=5\tsoon\n"
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatalf("failed to write cache file: %v", err)
+	}
+
+	stats, err := CompactFile(path, false)
+	if err != nil {
+		t.Fatalf("CompactFile() error = %v", err)
+	}
+	if expected := (FileStats{TotalLines: 6, UniqueKeys: 2, DuplicateKeys: 1, MalformedLines: 3}); stats != expected {
+		t.Errorf("CompactFile() = %+v, expected %+v", stats, expected)
+	}
+
+	data, stats, err := readFile(path)
+	if err != nil {
+		t.Fatalf("readFile() error = %v", err)
+	}
+	if stats.TotalLines != 2 || liveEntries(data)["a"] != "3" || liveEntries(data)["b"] != "2" {
+		t.Errorf("compacted file has %d lines and %v, expected a=3 and b=2", stats.TotalLines, liveEntries(data))
+	}
+}
+
+func TestSQLiteBusyTimeout(t *testing.T) {
+	c, err := NewSQLite(filepath.Join(t.TempDir(), SQLiteFileName))
+	if err != nil {
+		t.Fatalf("NewSQLite() error = %v", err)
+	}
+	defer c.Close()
+
+	var timeout int64
+	if err := c.(*SQLite).db.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout); err != nil {
+		t.Fatalf("failed to read busy_timeout: %v", err)
+	}
+	if timeout != sqliteBusyTimeout.Milliseconds() {
+		t.Errorf("busy_timeout = %d, expected %d", timeout, sqliteBusyTimeout.Milliseconds())
+	}
+}
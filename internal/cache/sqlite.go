@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const SQLiteFileName = "cache.sqlite"
+
+// sqliteBusyTimeout makes writers wait for the lock of another connection or process, like a refresh running
+// during a run, instead of failing right away with SQLITE_BUSY
+const sqliteBusyTimeout = 5 * time.Second
+
+// expires_at is a Unix time, 0 never expires
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS kv (key TEXT PRIMARY KEY, value TEXT NOT NULL, expires_at INTEGER NOT NULL DEFAULT 0)`
+
+// SQLite persists each value as soon as it is set, unlike File which rewrites the whole cache on flush
+type SQLite struct {
+	db     *sql.DB
+	get    *sql.Stmt
+	set    *sql.Stmt
+	delete *sql.Stmt
+}
+
+func NewSQLite(path string) (Cache, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)", path, sqliteBusyTimeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite cache: %w", err)
+	}
+	c := &SQLite{db: db}
+
+	if err := c.prepare(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SQLite) prepare() error {
+	if _, err := c.db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create sqlite cache schema: %w", err)
+	}
+	// Databases created before expiries were supported lack the column
+	var hasExpiry bool
+	if err := c.db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info('kv') WHERE name = 'expires_at'`).Scan(&hasExpiry); err != nil {
+		return fmt.Errorf("failed to read sqlite cache schema: %w", err)
+	}
+	if !hasExpiry {
+		if _, err := c.db.Exec(`ALTER TABLE kv ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return fmt.Errorf("failed to add expiry to sqlite cache schema: %w", err)
+		}
+	}
+
+	var err error
+	if c.get, err = c.db.Prepare(`SELECT value FROM kv WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`); err != nil {
+		return fmt.Errorf("failed to prepare sqlite cache get: %w", err)
+	}
+	if c.set, err = c.db.Prepare(`INSERT INTO kv (key, value, expires_at) VALUES (?, ?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`); err != nil {
+		return fmt.Errorf("failed to prepare sqlite cache set: %w", err)
+	}
+	if c.delete, err = c.db.Prepare(`DELETE FROM kv WHERE key = ?`); err != nil {
+		return fmt.Errorf("failed to prepare sqlite cache delete: %w", err)
+	}
+	return nil
+}
+
+func (c *SQLite) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	if err := c.get.QueryRowContext(ctx, key, time.Now().Unix()).Scan(&value); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrCacheMiss
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+func (c *SQLite) Set(ctx context.Context, key string, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *SQLite) SetWithTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).Unix()
+	}
+	_, err := c.set.ExecContext(ctx, key, value, expiresAt)
+	return err
+}
+
+func (c *SQLite) Delete(ctx context.Context, key string) error {
+	_, err := c.delete.ExecContext(ctx, key)
+	return err
+}
+
+func (c *SQLite) Entries(ctx context.Context) (map[string]string, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT key, value FROM kv WHERE expires_at = 0 OR expires_at > ?`, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			slog.Error("Failed to close sqlite cache rows", "error", err)
+		}
+	}()
+
+	entries := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		entries[key] = value
+	}
+	return entries, rows.Err()
+}
+
+func (c *SQLite) Close() {
+	for _, stmt := range []*sql.Stmt{c.get, c.set, c.delete} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil {
+			slog.Error("Failed to close sqlite cache statement", "error", err)
+		}
+	}
+	if err := c.db.Close(); err != nil {
+		slog.Error("Failed to close sqlite cache", "error", err)
+	}
+}
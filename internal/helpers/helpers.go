@@ -1,8 +1,13 @@
 package helpers
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func CloseFile(f *os.File) {
@@ -37,3 +42,44 @@ func SplitRange(min, max, divisions int) [][2]int {
 	}
 	return ranges
 }
+
+// SleepContext pauses for d or until ctx is done, whichever comes first
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ParseClockDuration parses a duration displayed as a clock, like "4:05" or "1:02:03".
+// An empty text returns a zero duration without error.
+func ParseClockDuration(text string) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid clock duration %q", text)
+	}
+
+	var duration time.Duration
+	for i, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid clock duration %q", text)
+		}
+		// Only the leading unit may exceed its clock range
+		if i > 0 && value >= 60 {
+			return 0, fmt.Errorf("invalid clock duration %q", text)
+		}
+		duration = duration*60 + time.Duration(value)*time.Second
+	}
+	return duration, nil
+}
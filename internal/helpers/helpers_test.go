@@ -0,0 +1,40 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseClockDuration(t *testing.T) {
+	tests := []struct {
+		text        string
+		expected    time.Duration
+		expectedErr bool
+	}{
+		{text: "", expected: 0},
+		{text: "  ", expected: 0},
+		{text: "45", expected: 45 * time.Second},
+		{text: "4:05", expected: 4*time.Minute + 5*time.Second},
+		{text: " 4:05 ", expected: 4*time.Minute + 5*time.Second},
+		{text: "75:00", expected: 75 * time.Minute},
+		{text: "1:02:03", expected: time.Hour + 2*time.Minute + 3*time.Second},
+		{text: "0:00", expected: 0},
+		{text: "4:60", expectedErr: true},
+		{text: "1:60:00", expectedErr: true},
+		{text: "1:2:3:4", expectedErr: true},
+		{text: "4:", expectedErr: true},
+		{text: "-1:00", expectedErr: true},
+		{text: "four", expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseClockDuration(tt.text)
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("ParseClockDuration(%q) error = %v, expected error %v", tt.text, err, tt.expectedErr)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseClockDuration(%q) = %s, expected %s", tt.text, got, tt.expected)
+			}
+		})
+	}
+}
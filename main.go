@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
+	"runtime"
+	"runtime/pprof"
 	"time"
 
 	"github.com/cterence/scrobble-deduplicator/internal/app"
-	altsrc "github.com/urfave/cli-altsrc/v3"
-	"github.com/urfave/cli-altsrc/v3/yaml"
 	"github.com/urfave/cli/v3"
 )
 
@@ -20,7 +22,8 @@ var (
 	date    = "unknown"
 )
 
-func setLogger(logLevel string) error {
+// setLogger logs to output from the given level
+func setLogger(logLevel string, output io.Writer) error {
 	var slogLogLevel slog.Level
 
 	switch logLevel {
@@ -38,31 +41,134 @@ func setLogger(logLevel string) error {
 	logOpts := slog.HandlerOptions{
 		Level: slogLogLevel,
 	}
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &logOpts)))
+	slog.SetDefault(slog.New(slog.NewTextHandler(output, &logOpts)))
 
 	return nil
 }
 
+func startCPUProfile(filename string) (func(), error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return func() {
+		pprof.StopCPUProfile()
+		if err := f.Close(); err != nil {
+			slog.Error("Failed to close CPU profile", "error", err)
+		}
+		slog.Info("CPU profile written", "file", filename)
+	}, nil
+}
+
+func writeMemProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	slog.Info("Memory profile written", "file", filename)
+	return f.Close()
+}
+
 func main() {
 	var (
-		configFilePath     string
-		cacheType          string
-		lastFMUsername     string
-		lastFMPassword     string
-		startPage          int
-		from               time.Time
-		to                 time.Time
-		browserHeadful     bool
-		browserURL         string
-		redisURL           string
-		canDelete          bool
-		logLevel           string
-		duplicateThreshold int
-		completeThreshold  int
-		processingMode     string
-		dataDir            string
-		telegramBotToken   string
-		telegramChatID     string
+		configFilePath          string
+		configFormat            string
+		envPrefix               string
+		cacheType               string
+		cacheTTL                time.Duration
+		lastFMUsername          string
+		lastFMPassword          string
+		lastFMPasswordFile      string
+		lastFMAPIKey            string
+		startPage               int
+		from                    time.Time
+		to                      time.Time
+		browserHeadful          bool
+		browserURL              string
+		redisURL                string
+		redisURLFile            string
+		sqlitePath              string
+		deleteScrobbles         bool
+		logLevel                string
+		duplicateThreshold      int
+		completeThreshold       int
+		thresholdEpsilon        float64
+		fullPlayAt              int
+		processingMode          string
+		workers                 int
+		dataDir                 string
+		telegramBotToken        string
+		telegramBotTokenFile    string
+		telegramChatID          string
+		deletionWebhookURL      string
+		cachePages              bool
+		replayPages             bool
+		saveTrace               bool
+		continueOnPageError     bool
+		pageDelay               time.Duration
+		pageDelayJitter         time.Duration
+		resultsDB               string
+		csvDialect              string
+		exportFormat            string
+		outputNameTemplate      string
+		csvSanitize             bool
+		csvDelimiter            string
+		csvBOM                  bool
+		onlyNewSinceLastRun     bool
+		resume                  bool
+		importDeletions         string
+		cacheBackup             bool
+		purgeArtist             string
+		purgeTrack              string
+		cacheExportFile         string
+		cacheImportFile         string
+		refreshOlderThan        time.Duration
+		analyzeInput            string
+		pinArtist               string
+		pinTrack                string
+		pinMBID                 string
+		lockWait                time.Duration
+		perScrobbleTimeout      time.Duration
+		review                  bool
+		countOnly               bool
+		incompleteTarget        string
+		onlySurrounded          bool
+		keepOnePerTrack         bool
+		skipUncompared          bool
+		keep                    string
+		disableMusicBrainz      bool
+		disableLastFMFallback   bool
+		includeEqualTimestamps  bool
+		cpuProfile              string
+		memProfile              string
+		alertOnDeletions        int
+		alertOnFailureRate      float64
+		deleteSample            int
+		maxDeleteFailures       int
+		telegramMessageThreadID int
+		digestPeriod            time.Duration
+		progressNotifyEvery     string
+		webhookURL              string
+		webhookHeaders          []string
+		slowMo                  time.Duration
+		localLibrary            string
+		maxDuplicateGap         time.Duration
+		minReplayGap            time.Duration
+		sessionGap              time.Duration
+		browserAuthToken        string
+		browserHeaders          []string
+		browserKeepAlive        time.Duration
 	)
 
 	wd, err := os.Getwd()
@@ -71,10 +177,97 @@ func main() {
 		os.Exit(1)
 	}
 
+	configSource := func(key string) cli.ValueSource {
+		return newConfigFileSource(key, &configFilePath, &configFormat)
+	}
+
+	envSource := func(name string) cli.ValueSource {
+		return newEnvVarSource(name, &envPrefix)
+	}
+
+	newConfig := func() *app.Config {
+		return &app.Config{
+			FilePath:                configFilePath,
+			Version:                 version,
+			CacheType:               cacheType,
+			CacheTTL:                cacheTTL,
+			LastFMUsername:          lastFMUsername,
+			LastFMPassword:          lastFMPassword,
+			StartPage:               startPage,
+			From:                    from,
+			To:                      to,
+			BrowserHeadful:          browserHeadful,
+			RedisURL:                redisURL,
+			SQLitePath:              sqlitePath,
+			BrowserURL:              browserURL,
+			Delete:                  deleteScrobbles,
+			LogLevel:                logLevel,
+			DuplicateThreshold:      duplicateThreshold,
+			CompleteThreshold:       completeThreshold,
+			ThresholdEpsilon:        thresholdEpsilon,
+			FullPlayAt:              fullPlayAt,
+			ProcessingMode:          processingMode,
+			Workers:                 workers,
+			DataDir:                 dataDir,
+			TelegramBotToken:        telegramBotToken,
+			TelegramChatID:          telegramChatID,
+			DeletionWebhookURL:      deletionWebhookURL,
+			CachePages:              cachePages,
+			ReplayPages:             replayPages,
+			SaveTrace:               saveTrace,
+			ContinueOnPageError:     continueOnPageError,
+			PageDelay:               pageDelay,
+			PageDelayJitter:         pageDelayJitter,
+			ResultsDB:               resultsDB,
+			CSVDialect:              csvDialect,
+			ExportFormat:            exportFormat,
+			OutputNameTemplate:      outputNameTemplate,
+			CSVSanitize:             csvSanitize,
+			CSVDelimiter:            csvDelimiter,
+			CSVBOM:                  csvBOM,
+			OnlyNewSinceLastRun:     onlyNewSinceLastRun,
+			Resume:                  resume,
+			ImportDeletions:         importDeletions,
+			IncompleteDeleteTarget:  incompleteTarget,
+			SkipOnlyWhenSurrounded:  onlySurrounded,
+			KeepOnePerTrack:         keepOnePerTrack,
+			SkipUncomparedDurations: skipUncompared,
+			Keep:                    keep,
+			DisableMusicBrainz:      disableMusicBrainz,
+			DisableLastFMFallback:   disableLastFMFallback,
+			LastFMAPIKey:            lastFMAPIKey,
+			LockWait:                lockWait,
+			PerScrobbleTimeout:      perScrobbleTimeout,
+			Review:                  review,
+			CountOnly:               countOnly,
+			IncludeEqualTimestamps:  includeEqualTimestamps,
+			AlertOnDeletions:        alertOnDeletions,
+			AlertOnFailureRate:      alertOnFailureRate,
+			DeleteSample:            deleteSample,
+			MaxDeleteFailures:       maxDeleteFailures,
+			TelegramMessageThreadID: telegramMessageThreadID,
+			DigestPeriod:            digestPeriod,
+			ProgressNotifyEvery:     progressNotifyEvery,
+			WebhookURL:              webhookURL,
+			WebhookHeaders:          webhookHeaders,
+			SlowMo:                  slowMo,
+			LocalLibrary:            localLibrary,
+			MaxDuplicateGap:         maxDuplicateGap,
+			MinReplayGap:            minReplayGap,
+			SessionGap:              sessionGap,
+			BrowserAuthToken:        browserAuthToken,
+			BrowserHeaders:          browserHeaders,
+			BrowserKeepAlive:        browserKeepAlive,
+		}
+	}
+
 	cmd := &cli.Command{
 		Name:    "scrobble-deduplicator",
 		Usage:   "Deduplicate Last.fm scrobbles",
 		Version: fmt.Sprintf("Version: %s\nCommit: %s\nBuild Date: %s", version, commit, date),
+		Before: func(ctx context.Context, _ *cli.Command) (context.Context, error) {
+			return ctx, errors.Join(secretFileErrors...)
+		},
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "config",
@@ -83,156 +276,769 @@ func main() {
 				Usage:       "Path to the configuration file",
 				Destination: &configFilePath,
 			},
+			&cli.StringFlag{
+				Name:        "config-format",
+				Usage:       "Format of the configuration file (yaml, toml, json), guessed from the file extension if not set",
+				Destination: &configFormat,
+				Validator: func(format string) error {
+					if format != "yaml" && format != "toml" && format != "json" {
+						return fmt.Errorf("unknown config format: %s", format)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:        "config-env-prefix",
+				Usage:       "Prefix of the environment variables read for each flag, unprefixed variables are deprecated and only read as a fallback",
+				Value:       "SCROBBLE_DEDUP_",
+				Destination: &envPrefix,
+			},
 			&cli.StringFlag{
 				Name:        "lastfm-username",
 				Aliases:     []string{"u"},
 				Usage:       "Last.fm username",
-				Required:    true,
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("LASTFM_USERNAME"), yaml.YAML("lastfm.username", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("LASTFM_USERNAME"), configSource("lastfm.username")),
 				Destination: &lastFMUsername,
 			},
+			&cli.StringFlag{
+				Name:        "lastfm-password-file",
+				Usage:       "File containing the Last.fm password, like a mounted secret",
+				Sources:     cli.NewValueSourceChain(configSource("lastfm.passwordFile")),
+				Destination: &lastFMPasswordFile,
+			},
 			&cli.StringFlag{
 				Name:        "lastfm-password",
 				Aliases:     []string{"p"},
-				Usage:       "Last.fm password",
-				Required:    true,
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("LASTFM_PASSWORD"), yaml.YAML("lastfm.password", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Usage:       "Last.fm password, optional once a session cookie is saved in the data directory",
+				Sources:     cli.NewValueSourceChain(newSecretFileSource(&lastFMPasswordFile), envSource("LASTFM_PASSWORD"), configSource("lastfm.password")),
 				Destination: &lastFMPassword,
 			},
+			&cli.StringFlag{
+				Name:        "lastfm-api-key",
+				Usage:       "Last.fm API key, durations unknown to MusicBrainz are read from the API instead of scraping the track page, even when replaying pages or analyzing an export",
+				Sources:     cli.NewValueSourceChain(envSource("LASTFM_API_KEY"), configSource("lastfm.apiKey")),
+				Destination: &lastFMAPIKey,
+			},
 			&cli.BoolFlag{
 				Name:        "delete",
 				Usage:       "Delete duplicate scrobbles",
 				Value:       false,
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("DELETE"), yaml.YAML("delete", altsrc.NewStringPtrSourcer(&configFilePath))),
-				Destination: &canDelete,
+				Sources:     cli.NewValueSourceChain(envSource("DELETE"), configSource("delete")),
+				Destination: &deleteScrobbles,
+			},
+			&cli.IntFlag{
+				Name:        "delete-sample",
+				Usage:       "Only delete the first N detected scrobbles to validate deletion, the others are recorded as in dry-run (requires delete)",
+				Sources:     cli.NewValueSourceChain(envSource("DELETE_SAMPLE"), configSource("deleteSample")),
+				Destination: &deleteSample,
+			},
+			&cli.IntFlag{
+				Name:        "max-consecutive-delete-failures",
+				Usage:       "Abort the run after this many scrobble deletions failed in a row, as the deletion selector likely broke (0 to disable)",
+				Value:       5,
+				Sources:     cli.NewValueSourceChain(envSource("MAX_CONSECUTIVE_DELETE_FAILURES"), configSource("maxConsecutiveDeleteFailures")),
+				Destination: &maxDeleteFailures,
 			},
 			&cli.IntFlag{
 				Name:        "duplicate-threshold",
 				Usage:       "Percentage of a track's duration below which two successive scrobbles are considered duplicates",
 				Value:       90,
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("DUPLICATE_THRESHOLD"), yaml.YAML("duplicateThreshold", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("DUPLICATE_THRESHOLD"), configSource("duplicateThreshold")),
 				Destination: &duplicateThreshold,
 			},
+			&cli.BoolFlag{
+				Name:        "include-equal-timestamps",
+				Usage:       "Treat successive scrobbles of the same track with identical timestamps as duplicates, they are ignored otherwise",
+				Sources:     cli.NewValueSourceChain(envSource("INCLUDE_EQUAL_TIMESTAMPS"), configSource("includeEqualTimestamps")),
+				Destination: &includeEqualTimestamps,
+			},
+			&cli.DurationFlag{
+				Name:        "max-duplicate-gap",
+				Usage:       "Time between two scrobbles above which they are never duplicates, whatever the duplicate threshold (ex: 15m, 0 to disable)",
+				Sources:     cli.NewValueSourceChain(envSource("MAX_DUPLICATE_GAP"), configSource("maxDuplicateGap")),
+				Destination: &maxDuplicateGap,
+			},
+			&cli.DurationFlag{
+				Name:        "min-replay-gap",
+				Usage:       "Time between two scrobbles of the same track below which they are always duplicates and above which they are distinct plays, replacing the duplicate threshold (ex: 10s, 0 to disable)",
+				Sources:     cli.NewValueSourceChain(envSource("MIN_REPLAY_GAP"), configSource("minReplayGap")),
+				Destination: &minReplayGap,
+			},
+			&cli.DurationFlag{
+				Name:        "session-gap",
+				Usage:       "Time between two scrobbles above which a new listening session starts, scrobbles of different sessions are never compared (ex: 30m, 0 to disable)",
+				Sources:     cli.NewValueSourceChain(envSource("SESSION_GAP"), configSource("sessionGap")),
+				Destination: &sessionGap,
+			},
+			&cli.IntFlag{
+				Name:        "full-play-at",
+				Usage:       "Percentage of a track's duration from which a play counts as a full play for duplicate detection, capped at 4 minutes of play like the scrobbles of Last.fm which are recorded after half of a track or 4 minutes",
+				Value:       50,
+				Sources:     cli.NewValueSourceChain(envSource("FULL_PLAY_AT"), configSource("fullPlayAt")),
+				Destination: &fullPlayAt,
+			},
 			&cli.IntFlag{
 				Name:        "complete-threshold",
 				Usage:       "Percentage of a track's duration to consider a scrobble complete, set a value to enable",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("COMPLETE_THRESHOLD"), yaml.YAML("completeThreshold", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("COMPLETE_THRESHOLD"), configSource("completeThreshold")),
 				Destination: &completeThreshold,
 			},
+			&cli.StringFlag{
+				Name:        "incomplete-delete-target",
+				Usage:       "Scrobble of an incomplete pair to delete (current, previous), duplicate pairs always delete the previous scrobble",
+				Value:       app.IncompleteDeleteTargetCurrent,
+				Sources:     cli.NewValueSourceChain(envSource("INCOMPLETE_DELETE_TARGET"), configSource("incompleteDeleteTarget")),
+				Destination: &incompleteTarget,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-only-when-surrounded",
+				Usage:       "Delete an incomplete scrobble only when the scrobbles before and after it are complete plays of other tracks",
+				Sources:     cli.NewValueSourceChain(envSource("SKIP_ONLY_WHEN_SURROUNDED"), configSource("skipOnlyWhenSurrounded")),
+				Destination: &onlySurrounded,
+			},
+			&cli.BoolFlag{
+				Name:        "keep-one-per-track",
+				Usage:       "Never delete the last remaining scrobble of a track among the processed scrobbles, kept scrobbles are counted in the run statistics",
+				Sources:     cli.NewValueSourceChain(envSource("KEEP_ONE_PER_TRACK"), configSource("keepOnePerTrack")),
+				Destination: &keepOnePerTrack,
+			},
+			&cli.StringFlag{
+				Name:        "keep",
+				Usage:       "Scrobble kept from successive duplicates of a track (last, highest-completion), highest-completion keeps the most complete play of the cluster",
+				Value:       app.KeepLast,
+				Sources:     cli.NewValueSourceChain(envSource("KEEP"), configSource("keep")),
+				Destination: &keep,
+			},
+			&cli.FloatFlag{
+				Name:        "threshold-epsilon",
+				Usage:       "Tolerance in percentage points under which a completion percentage is considered equal to a threshold (scrobbles are flagged only when strictly below a threshold)",
+				Value:       0.001,
+				Sources:     cli.NewValueSourceChain(envSource("THRESHOLD_EPSILON"), configSource("thresholdEpsilon")),
+				Destination: &thresholdEpsilon,
+			},
+			&cli.BoolFlag{
+				Name:        "count-only",
+				Usage:       "Only print the number of duplicated scrobbles, using cached and user track durations (implies no deletion), logs are written to stderr so that the counts are the only output on stdout",
+				Sources:     cli.NewValueSourceChain(envSource("COUNT_ONLY"), configSource("countOnly")),
+				Destination: &countOnly,
+			},
 			&cli.IntFlag{
 				Name:        "start-page",
 				Aliases:     []string{"s"},
 				Usage:       "Last.fm scrobble library page to start from",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("START_PAGE"), yaml.YAML("startPage", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("START_PAGE"), configSource("startPage")),
 				Destination: &startPage,
 			},
 			&cli.TimestampFlag{
 				Name:  "from",
-				Usage: "Day at which the program should start deduplicating scrobbles (layout: 02-01-2006)",
+				Usage: "Day at which the program should start deduplicating scrobbles, optionally with a time of day (layout: 02-01-2006, 02-01-2006 15:04 or 02-01-2006 15:04:05)",
 				Config: cli.TimestampConfig{
-					Layouts: []string{app.InputDayFormat},
+					Layouts:  []string{app.InputDayFormat, app.InputDayMinuteFormat, app.InputDaySecondFormat},
+					Timezone: time.Local,
 				},
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("FROM"), yaml.YAML("from", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("FROM"), configSource("from")),
 				Destination: &from,
 			},
 			&cli.TimestampFlag{
 				Name:  "to",
-				Usage: "Day at which the program should end deduplicating scrobbles (layout: 02-01-2006)",
+				Usage: "Day at which the program should end deduplicating scrobbles, optionally with a time of day (layout: 02-01-2006, 02-01-2006 15:04 or 02-01-2006 15:04:05)",
 				Config: cli.TimestampConfig{
-					Layouts: []string{app.InputDayFormat},
+					Layouts:  []string{app.InputDayFormat, app.InputDayMinuteFormat, app.InputDaySecondFormat},
+					Timezone: time.Local,
 				},
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("TO"), yaml.YAML("to", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("TO"), configSource("to")),
 				Destination: &to,
 			},
 			&cli.StringFlag{
 				Name:        "processing-mode",
-				Usage:       "Mode for processing the scrobbles (sequential, parallel)",
-				Value:       "sequential",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("PROCESSING_MODE"), yaml.YAML("processingMode", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Usage:       "Mode for processing the scrobbles (sequential, parallel), parallel loads library pages ahead in several browser tabs while scrobbles are still compared in order",
+				Value:       app.ProcessingModeSequential,
+				Sources:     cli.NewValueSourceChain(envSource("PROCESSING_MODE"), configSource("processingMode")),
 				Destination: &processingMode,
 			},
+			&cli.IntFlag{
+				Name:        "workers",
+				Usage:       "Number of browser tabs loading library pages in parallel processing mode",
+				Value:       4,
+				Sources:     cli.NewValueSourceChain(envSource("WORKERS"), configSource("workers")),
+				Destination: &workers,
+			},
+			&cli.BoolFlag{
+				Name:        "disable-musicbrainz",
+				Usage:       "Never query the MusicBrainz API for track durations",
+				Sources:     cli.NewValueSourceChain(envSource("DISABLE_MUSICBRAINZ"), configSource("disableMusicBrainz")),
+				Destination: &disableMusicBrainz,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-uncompared-durations",
+				Usage:       "Only look up the track duration of scrobbles following a scrobble of the same track, to spare MusicBrainz lookups of tracks never compared (incompatible with complete-threshold)",
+				Sources:     cli.NewValueSourceChain(envSource("SKIP_UNCOMPARED_DURATIONS"), configSource("skipUncomparedDurations")),
+				Destination: &skipUncompared,
+			},
+			&cli.BoolFlag{
+				Name:        "disable-lastfm-fallback",
+				Usage:       "Never query the Last.fm API or scrape the Last.fm track page for durations unknown to MusicBrainz (only user track durations and the cache are used when combined with disable-musicbrainz)",
+				Sources:     cli.NewValueSourceChain(envSource("DISABLE_LASTFM_FALLBACK"), configSource("disableLastFMFallback")),
+				Destination: &disableLastFMFallback,
+			},
+			&cli.DurationFlag{
+				Name:        "per-scrobble-timeout",
+				Usage:       "Give up looking up the track duration of a scrobble after this time and skip it, so that a slow lookup does not stall the page (ex: 1m, 0 to disable)",
+				Sources:     cli.NewValueSourceChain(envSource("PER_SCROBBLE_TIMEOUT"), configSource("perScrobbleTimeout")),
+				Destination: &perScrobbleTimeout,
+			},
+			&cli.StringFlag{
+				Name:        "local-library",
+				Usage:       "Directory of FLAC files whose artist, title and duration tags are used before MusicBrainz to find track durations",
+				Sources:     cli.NewValueSourceChain(envSource("LOCAL_LIBRARY"), configSource("localLibrary")),
+				Destination: &localLibrary,
+			},
 			&cli.StringFlag{
 				Name:        "cache-type",
-				Usage:       "Cache type for MusicBrainz API queries (inmemory, file, redis) (must specify redis-url flag for redis)",
+				Usage:       "Cache type for MusicBrainz API queries (inmemory, file, redis, sqlite) (must specify redis-url flag for redis)",
 				Value:       "inmemory",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("CACHE_TYPE"), yaml.YAML("cacheType", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("CACHE_TYPE"), configSource("cacheType")),
 				Destination: &cacheType,
 			},
+			&cli.DurationFlag{
+				Name:        "cache-ttl",
+				Usage:       "Time after which a cached track duration expires and is looked up again, to pick up MusicBrainz corrections (ex: 720h, 0 never expires)",
+				Sources:     cli.NewValueSourceChain(envSource("CACHE_TTL"), configSource("cacheTTL")),
+				Destination: &cacheTTL,
+			},
 			&cli.BoolFlag{
 				Name:        "browser-headful",
 				Usage:       "Run with a visible browser UI",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("BROWSER_HEADFUL"), yaml.YAML("browserHeadful", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("BROWSER_HEADFUL"), configSource("browserHeadful")),
 				Destination: &browserHeadful,
 			},
+			&cli.DurationFlag{
+				Name:        "slow-mo",
+				Usage:       "Pause before each browser navigation and click to follow them in headful mode (ex: 1s), ignored in headless mode",
+				Sources:     cli.NewValueSourceChain(envSource("SLOW_MO"), configSource("slowMo")),
+				Destination: &slowMo,
+			},
+			&cli.BoolFlag{
+				Name:        "review",
+				Usage:       "Highlight each detected scrobble in the browser and ask whether to keep, delete or skip it (requires browser-headful)",
+				Sources:     cli.NewValueSourceChain(envSource("REVIEW"), configSource("review")),
+				Destination: &review,
+			},
 			&cli.StringFlag{
 				Name:        "browser-url",
 				Usage:       "Remote browser URL",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("BROWSER_URL"), yaml.YAML("browserURL", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("BROWSER_URL"), configSource("browserURL")),
 				Destination: &browserURL,
 			},
+			&cli.StringFlag{
+				Name:        "browser-auth-token",
+				Usage:       "Token added to the remote browser URL for browser services requiring authentication (requires browser-url)",
+				Sources:     cli.NewValueSourceChain(envSource("BROWSER_AUTH_TOKEN"), configSource("browserAuthToken")),
+				Destination: &browserAuthToken,
+			},
+			&cli.StringSliceFlag{
+				Name:        "browser-header",
+				Usage:       "Header of the remote browser connection in the \"Name: value\" form, for browser services authenticating with headers (requires browser-url, repeat the flag for several headers)",
+				Sources:     cli.NewValueSourceChain(envSource("BROWSER_HEADERS"), configSource("browserHeaders")),
+				Destination: &browserHeaders,
+			},
+			&cli.DurationFlag{
+				Name:        "browser-keepalive",
+				Usage:       "Idle time after which the remote browser provider reclaims sessions, the session is kept alive with a no-op before it is reached (requires browser-url, ex: 1m)",
+				Sources:     cli.NewValueSourceChain(envSource("BROWSER_KEEPALIVE"), configSource("browserKeepAlive")),
+				Destination: &browserKeepAlive,
+			},
+			&cli.StringFlag{
+				Name:        "sqlite-path",
+				Usage:       "Database file for sqlite cache type, defaults to cache.sqlite in the data directory",
+				Sources:     cli.NewValueSourceChain(envSource("SQLITE_PATH"), configSource("sqlitePath")),
+				Destination: &sqlitePath,
+			},
+			&cli.StringFlag{
+				Name:        "redis-url-file",
+				Usage:       "File containing the Redis URL, like a mounted secret",
+				Sources:     cli.NewValueSourceChain(configSource("redisURLFile")),
+				Destination: &redisURLFile,
+			},
 			&cli.StringFlag{
 				Name:        "redis-url",
 				Usage:       "Redis URL for redis cache type",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("REDIS_URL"), yaml.YAML("redisURL", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(newSecretFileSource(&redisURLFile), envSource("REDIS_URL"), configSource("redisURL")),
 				Destination: &redisURL,
 			},
 			&cli.StringFlag{
 				Name:        "data-dir",
-				Usage:       "Path to a directory that this program can use to read and produce files",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("DATA_DIR"), yaml.YAML("dataDir", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Usage:       "Path to a directory that this program can use to read and produce files, created if it does not exist",
+				Sources:     cli.NewValueSourceChain(envSource("DATA_DIR"), configSource("dataDir")),
 				Value:       path.Join(wd, "data"),
 				Destination: &dataDir,
 			},
+			&cli.DurationFlag{
+				Name:        "lock-wait",
+				Usage:       "How long to wait for another run using the same data directory to finish before giving up",
+				Sources:     cli.NewValueSourceChain(envSource("LOCK_WAIT"), configSource("lockWait")),
+				Destination: &lockWait,
+			},
 			&cli.StringFlag{
 				Name:        "log-level",
 				Usage:       "Log level (debug, info, warn, error)",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("LOG_LEVEL"), yaml.YAML("logLevel", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("LOG_LEVEL"), configSource("logLevel")),
 				Value:       "info",
 				Destination: &logLevel,
 			},
+			&cli.StringFlag{
+				Name:        "telegram-bot-token-file",
+				Usage:       "File containing the Telegram Bot token, like a mounted secret",
+				Sources:     cli.NewValueSourceChain(configSource("telegram.botTokenFile")),
+				Destination: &telegramBotTokenFile,
+			},
 			&cli.StringFlag{
 				Name:        "telegram-bot-token",
 				Usage:       "Telegram Bot token to send a message to when a run finishes",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("TELEGRAM_BOT_TOKEN"), yaml.YAML("telegram.botToken", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(newSecretFileSource(&telegramBotTokenFile), envSource("TELEGRAM_BOT_TOKEN"), configSource("telegram.botToken")),
 				Destination: &telegramBotToken,
 			},
 			&cli.StringFlag{
 				Name:        "telegram-chat-id",
 				Usage:       "Telegram chat ID where the bot can send message to",
-				Sources:     cli.NewValueSourceChain(cli.EnvVar("TELEGRAM_CHAT_ID"), yaml.YAML("telegram.chatID", altsrc.NewStringPtrSourcer(&configFilePath))),
+				Sources:     cli.NewValueSourceChain(envSource("TELEGRAM_CHAT_ID"), configSource("telegram.chatID")),
 				Destination: &telegramChatID,
 			},
+			&cli.IntFlag{
+				Name:        "telegram-message-thread-id",
+				Usage:       "Telegram topic (message thread) ID where the bot sends messages, for forum chats",
+				Sources:     cli.NewValueSourceChain(envSource("TELEGRAM_MESSAGE_THREAD_ID"), configSource("telegram.messageThreadID")),
+				Destination: &telegramMessageThreadID,
+			},
+			&cli.DurationFlag{
+				Name:        "digest-period",
+				Usage:       "Accumulate the runs in a digest and send a single Telegram message once this period elapsed since the first run of the digest (ex: 168h for a weekly digest), runs raising alerts are still notified right away",
+				Sources:     cli.NewValueSourceChain(envSource("DIGEST_PERIOD"), configSource("telegram.digestPeriod")),
+				Destination: &digestPeriod,
+			},
+			&cli.StringFlag{
+				Name:        "progress-notify-every",
+				Usage:       "Send a Telegram progress update (pages processed, duplicated scrobbles, estimated time left) every interval (ex: 30m) or number of pages (ex: 20) of long runs, page updates are at least 5 minutes apart",
+				Sources:     cli.NewValueSourceChain(envSource("PROGRESS_NOTIFY_EVERY"), configSource("telegram.progressNotifyEvery")),
+				Destination: &progressNotifyEvery,
+			},
+			&cli.StringFlag{
+				Name:        "deletion-webhook-url",
+				Usage:       "URL to POST a JSON event to each time a scrobble is deleted",
+				Sources:     cli.NewValueSourceChain(envSource("DELETION_WEBHOOK_URL"), configSource("deletionWebhookURL")),
+				Destination: &deletionWebhookURL,
+			},
+			&cli.StringFlag{
+				Name:        "webhook-url",
+				Usage:       "URL receiving a JSON POST with the run statistics and the deleted scrobbles at the end of each run, to feed dashboards",
+				Sources:     cli.NewValueSourceChain(envSource("WEBHOOK_URL"), configSource("webhookURL")),
+				Destination: &webhookURL,
+			},
+			&cli.StringSliceFlag{
+				Name:        "webhook-header",
+				Usage:       "Header of the webhook-url request in the \"Name: value\" form, for authentication (repeat the flag for several headers)",
+				Sources:     cli.NewValueSourceChain(envSource("WEBHOOK_HEADERS"), configSource("webhookHeaders")),
+				Destination: &webhookHeaders,
+			},
+			&cli.BoolFlag{
+				Name:        "cache-pages",
+				Usage:       "Save the scrobble rows of each library page in the data directory, per user and date range, replacing the pages of the previous run once the run completes",
+				Sources:     cli.NewValueSourceChain(envSource("CACHE_PAGES"), configSource("cachePages")),
+				Destination: &cachePages,
+			},
+			&cli.BoolFlag{
+				Name:        "replay-pages",
+				Usage:       "Process library pages previously saved with cache-pages for the same user and date range instead of browsing Last.fm, a page missing from the cache fails the run (incompatible with delete)",
+				Sources:     cli.NewValueSourceChain(envSource("REPLAY_PAGES"), configSource("replayPages")),
+				Destination: &replayPages,
+			},
+			&cli.BoolFlag{
+				Name:        "save-trace",
+				Usage:       "Save the HTML of each library page in the data directory, with cookies and CSRF tokens redacted, to attach to bug reports",
+				Sources:     cli.NewValueSourceChain(envSource("SAVE_TRACE"), configSource("saveTrace")),
+				Destination: &saveTrace,
+			},
+			&cli.BoolFlag{
+				Name:        "continue-on-page-error",
+				Usage:       "Skip a library page failing all retries instead of aborting the run, skipped pages are listed in the run statistics",
+				Sources:     cli.NewValueSourceChain(envSource("CONTINUE_ON_PAGE_ERROR"), configSource("continueOnPageError")),
+				Destination: &continueOnPageError,
+			},
+			&cli.DurationFlag{
+				Name:        "page-delay",
+				Usage:       "Pause between two library pages (ex: 2s)",
+				Sources:     cli.NewValueSourceChain(envSource("PAGE_DELAY"), configSource("pageDelay")),
+				Destination: &pageDelay,
+			},
+			&cli.DurationFlag{
+				Name:        "page-delay-jitter",
+				Usage:       "Maximum random duration added to page-delay",
+				Sources:     cli.NewValueSourceChain(envSource("PAGE_DELAY_JITTER"), configSource("pageDelayJitter")),
+				Destination: &pageDelayJitter,
+			},
+			&cli.StringFlag{
+				Name:        "results-db",
+				Usage:       "Path to a SQLite database where each run's statistics and deleted scrobbles are appended",
+				Sources:     cli.NewValueSourceChain(envSource("RESULTS_DB"), configSource("resultsDB")),
+				Destination: &resultsDB,
+			},
+			&cli.StringFlag{
+				Name:        "csv-dialect",
+				Usage:       "Format of the deleted scrobbles CSV export (default, lastfm-import), lastfm-import can be given to Last.fm bulk scrobbling tools to restore the scrobbles",
+				Value:       app.CSVDialectDefault,
+				Sources:     cli.NewValueSourceChain(envSource("CSV_DIALECT"), configSource("csvDialect")),
+				Destination: &csvDialect,
+			},
+			&cli.StringFlag{
+				Name:        "export-format",
+				Usage:       "Format of the deleted scrobbles export (csv, json, both)",
+				Value:       app.ExportFormatCSV,
+				Sources:     cli.NewValueSourceChain(envSource("EXPORT_FORMAT"), configSource("exportFormat")),
+				Destination: &exportFormat,
+			},
+			&cli.StringFlag{
+				Name:        "output-name-template",
+				Usage:       "Go template of the exported file names, without extension, with the variables .Artifact, .Username, .StartTime, .From and .To (ex: {{.Username}}-{{.Artifact}}-{{.From}}-{{.To}})",
+				Value:       app.DefaultOutputNameTemplate,
+				Sources:     cli.NewValueSourceChain(envSource("OUTPUT_NAME_TEMPLATE"), configSource("outputNameTemplate")),
+				Destination: &outputNameTemplate,
+			},
+			&cli.BoolFlag{
+				Name:        "csv-sanitize",
+				Usage:       "Prefix CSV fields starting with =, +, - or @ with a quote to prevent formula injection in spreadsheets",
+				Sources:     cli.NewValueSourceChain(envSource("CSV_SANITIZE"), configSource("csvSanitize")),
+				Destination: &csvSanitize,
+			},
+			&cli.StringFlag{
+				Name:        "csv-delimiter",
+				Usage:       "Field delimiter of the CSV exports, ex: ; for spreadsheets of locales using the comma as decimal separator",
+				Value:       ",",
+				Sources:     cli.NewValueSourceChain(envSource("CSV_DELIMITER"), configSource("csvDelimiter")),
+				Destination: &csvDelimiter,
+			},
+			&cli.BoolFlag{
+				Name:        "csv-bom",
+				Usage:       "Start the deleted scrobbles CSV file with a UTF-8 byte order mark, so that spreadsheets render accented names correctly",
+				Sources:     cli.NewValueSourceChain(envSource("CSV_BOM"), configSource("csvBOM")),
+				Destination: &csvBOM,
+			},
+			&cli.BoolFlag{
+				Name:        "only-new-since-last-run",
+				Usage:       "Only process scrobbles newer than the last one processed by a previous run with this flag (incompatible with start-page)",
+				Sources:     cli.NewValueSourceChain(envSource("ONLY_NEW_SINCE_LAST_RUN"), configSource("onlyNewSinceLastRun")),
+				Destination: &onlyNewSinceLastRun,
+			},
+			&cli.BoolFlag{
+				Name:        "resume",
+				Usage:       "Resume an interrupted run from the last page it processed, recorded in checkpoint.json in the data directory (incompatible with start-page and only-new-since-last-run)",
+				Sources:     cli.NewValueSourceChain(envSource("RESUME"), configSource("resume")),
+				Destination: &resume,
+			},
+			&cli.StringFlag{
+				Name:        "import-deletions",
+				Usage:       "Delete the scrobbles of a deleted scrobbles CSV export, such as the one of a reviewed dry run, without detecting duplicates",
+				Sources:     cli.NewValueSourceChain(envSource("IMPORT_DELETIONS"), configSource("importDeletions")),
+				Destination: &importDeletions,
+			},
+			&cli.StringFlag{
+				Name:        "cpuprofile",
+				Usage:       "Write a CPU profile of the run to this file",
+				Sources:     cli.NewValueSourceChain(envSource("CPU_PROFILE"), configSource("cpuProfile")),
+				Destination: &cpuProfile,
+			},
+			&cli.StringFlag{
+				Name:        "memprofile",
+				Usage:       "Write a heap profile to this file at the end of the run",
+				Sources:     cli.NewValueSourceChain(envSource("MEM_PROFILE"), configSource("memProfile")),
+				Destination: &memProfile,
+			},
+			&cli.IntFlag{
+				Name:        "alert-on-deletions",
+				Usage:       "Escalate the run notification when more duplicated scrobbles than this are found (0 to disable)",
+				Sources:     cli.NewValueSourceChain(envSource("ALERT_ON_DELETIONS"), configSource("alertOnDeletions")),
+				Destination: &alertOnDeletions,
+			},
+			&cli.FloatFlag{
+				Name:        "alert-on-failure-rate",
+				Usage:       "Escalate the run notification when the share of failed deletions is above this rate, between 0 and 1 (0 to disable)",
+				Sources:     cli.NewValueSourceChain(envSource("ALERT_ON_FAILURE_RATE"), configSource("alertOnFailureRate")),
+				Destination: &alertOnFailureRate,
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "diff",
+				Usage:     "Compare two deleted scrobbles CSV exports",
+				ArgsUsage: "OLD_EXPORT NEW_EXPORT",
+				Action: func(_ context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 2 {
+						return errors.New("diff expects exactly two CSV files")
+					}
+
+					return app.DiffExports(cmd.Args().Get(0), cmd.Args().Get(1), os.Stdout)
+				},
+			},
+			{
+				Name:  "scan",
+				Usage: "Report library statistics (scrobbles, unique tracks, unknown durations, most scrobbled tracks) without detecting duplicates",
+				Action: func(context.Context, *cli.Command) error {
+					ctx := context.Background()
+
+					c := newConfig()
+					if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+						return fmt.Errorf("failed to set logger: %w", err)
+					}
+
+					return app.Scan(ctx, c, os.Stdout)
+				},
+			},
+			{
+				Name:  "analyze",
+				Usage: "Detect duplicated scrobbles in a JSON export of the Last.fm history instead of the library pages, without browser and without deletion",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "input",
+						Usage:       "JSON export in the format of the Last.fm API user.getRecentTracks method (a list of tracks, of pages, or a single response)",
+						Required:    true,
+						Destination: &analyzeInput,
+					},
+				},
+				Action: func(context.Context, *cli.Command) error {
+					ctx := context.Background()
+
+					c := newConfig()
+					if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+						return fmt.Errorf("failed to set logger: %w", err)
+					}
+
+					f, err := os.Open(analyzeInput)
+					if err != nil {
+						return fmt.Errorf("failed to open scrobbles export: %w", err)
+					}
+					defer func() { _ = f.Close() }()
+					return app.Analyze(ctx, c, f)
+				},
+			},
+			{
+				Name:  "digest",
+				Usage: "Manage the digest of runs sent to Telegram",
+				Commands: []*cli.Command{
+					{
+						Name:  "send",
+						Usage: "Send the accumulated digest now and reset it",
+						Action: func(ctx context.Context, _ *cli.Command) error {
+							c := newConfig()
+							if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+								return fmt.Errorf("failed to set logger: %w", err)
+							}
+
+							return app.SendDigest(ctx, c)
+						},
+					},
+				},
+			},
+			{
+				Name:  "warm-cache",
+				Usage: "Resolve the duration of every track of the library into the cache without detecting duplicates, to speed up a later run",
+				Action: func(context.Context, *cli.Command) error {
+					ctx := context.Background()
+
+					c := newConfig()
+					if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+						return fmt.Errorf("failed to set logger: %w", err)
+					}
+
+					return app.WarmCache(ctx, c, os.Stdout)
+				},
+			},
+			{
+				Name:  "pin",
+				Usage: "Pin the MusicBrainz recording of a track so that its duration never comes from a recording search",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "artist",
+						Usage:       "Artist of the track, as scrobbled on Last.fm",
+						Required:    true,
+						Destination: &pinArtist,
+					},
+					&cli.StringFlag{
+						Name:        "track",
+						Usage:       "Title of the track, as scrobbled on Last.fm",
+						Required:    true,
+						Destination: &pinTrack,
+					},
+					&cli.StringFlag{
+						Name:        "mbid",
+						Usage:       "MusicBrainz ID of the recording",
+						Required:    true,
+						Destination: &pinMBID,
+					},
+				},
+				Action: func(ctx context.Context, _ *cli.Command) error {
+					c := newConfig()
+					if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+						return fmt.Errorf("failed to set logger: %w", err)
+					}
+
+					return app.PinTrack(ctx, c, pinArtist, pinTrack, pinMBID)
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the MusicBrainz API queries cache",
+				Commands: []*cli.Command{
+					{
+						Name:  "compact",
+						Usage: "Compact the file cache in the data directory and report its statistics",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:        "backup",
+								Usage:       "Keep a copy of the cache file before compacting it",
+								Destination: &cacheBackup,
+							},
+						},
+						Action: func(context.Context, *cli.Command) error {
+							c := newConfig()
+							if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+								return fmt.Errorf("failed to set logger: %w", err)
+							}
+
+							return app.CompactCache(c, cacheBackup)
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "Delete the cached durations of a track, searched and pinned, so that the next run looks it up again",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:        "artist",
+								Usage:       "Artist of the track, as scrobbled on Last.fm",
+								Required:    true,
+								Destination: &purgeArtist,
+							},
+							&cli.StringFlag{
+								Name:        "track",
+								Usage:       "Title of the track, as scrobbled on Last.fm",
+								Required:    true,
+								Destination: &purgeTrack,
+							},
+						},
+						Action: func(ctx context.Context, _ *cli.Command) error {
+							c := newConfig()
+							if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+								return fmt.Errorf("failed to set logger: %w", err)
+							}
+
+							return app.PurgeCacheEntry(ctx, c, purgeArtist, purgeTrack)
+						},
+					},
+					{
+						Name:  "export",
+						Usage: "Export the cached track durations as an artist, track and duration CSV",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:        "file",
+								Usage:       "CSV file to write",
+								Required:    true,
+								Destination: &cacheExportFile,
+							},
+						},
+						Action: func(ctx context.Context, _ *cli.Command) error {
+							c := newConfig()
+							if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+								return fmt.Errorf("failed to set logger: %w", err)
+							}
+
+							f, err := os.Create(cacheExportFile)
+							if err != nil {
+								return fmt.Errorf("failed to create export file: %w", err)
+							}
+							if err := app.ExportCache(ctx, c, f); err != nil {
+								_ = f.Close()
+								return err
+							}
+							return f.Close()
+						},
+					},
+					{
+						Name:  "import",
+						Usage: "Cache the track durations of a CSV written by cache export",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:        "file",
+								Usage:       "CSV file to read",
+								Required:    true,
+								Destination: &cacheImportFile,
+							},
+						},
+						Action: func(ctx context.Context, _ *cli.Command) error {
+							c := newConfig()
+							if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+								return fmt.Errorf("failed to set logger: %w", err)
+							}
+
+							f, err := os.Open(cacheImportFile)
+							if err != nil {
+								return fmt.Errorf("failed to open import file: %w", err)
+							}
+							defer func() { _ = f.Close() }()
+							return app.ImportCache(ctx, c, f)
+						},
+					},
+					{
+						Name:  "refresh-durations",
+						Usage: "Look the cached track durations up again in MusicBrainz and replace those that changed",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:        "refresh-older-than",
+								Usage:       "Only look up the durations cached before this duration, durations cached by older versions are always looked up",
+								Value:       30 * 24 * time.Hour,
+								Destination: &refreshOlderThan,
+							},
+						},
+						Action: func(ctx context.Context, _ *cli.Command) error {
+							c := newConfig()
+							if err := setLogger(c.LogLevel, os.Stdout); err != nil {
+								return fmt.Errorf("failed to set logger: %w", err)
+							}
+							return app.RefreshDurations(ctx, c, refreshOlderThan, os.Stdout)
+						},
+					},
+				},
+			},
 		},
 		Action: func(context.Context, *cli.Command) error {
 			ctx := context.Background()
 
-			c := app.Config{
-				FilePath:           configFilePath,
-				CacheType:          cacheType,
-				LastFMUsername:     lastFMUsername,
-				LastFMPassword:     lastFMPassword,
-				StartPage:          startPage,
-				From:               from,
-				To:                 to,
-				BrowserHeadful:     browserHeadful,
-				RedisURL:           redisURL,
-				BrowserURL:         browserURL,
-				CanDelete:          canDelete,
-				LogLevel:           logLevel,
-				DuplicateThreshold: duplicateThreshold,
-				CompleteThreshold:  completeThreshold,
-				ProcessingMode:     processingMode,
-				DataDir:            dataDir,
-				TelegramBotToken:   telegramBotToken,
-				TelegramChatID:     telegramChatID,
-			}
+			c := newConfig()
 
-			err := setLogger(c.LogLevel)
+			// Counts are the only output on stdout in count-only mode, so that scripts can read them
+			logOutput := os.Stdout
+			if c.CountOnly {
+				logOutput = os.Stderr
+			}
+			err := setLogger(c.LogLevel, logOutput)
 			if err != nil {
 				return fmt.Errorf("failed to set logger: %w", err)
 			}
 
-			return app.Run(ctx, &c)
+			if cpuProfile != "" {
+				stopCPUProfile, err := startCPUProfile(cpuProfile)
+				if err != nil {
+					return fmt.Errorf("failed to start CPU profile: %w", err)
+				}
+				defer stopCPUProfile()
+			}
+
+			if memProfile != "" {
+				defer func() {
+					if err := writeMemProfile(memProfile); err != nil {
+						slog.Error("Failed to write memory profile", "error", err)
+					}
+				}()
+			}
+
+			return app.Run(ctx, c)
 		},
 	}
 
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// secretFileSuffix marks an environment variable holding the path of a file whose content is the value,
+// the convention of container images reading mounted secrets
+const secretFileSuffix = "_FILE"
+
+// secretFileErrors are the failures to read secret files, value sources can't return errors so they are returned
+// before any command runs instead of leaving the value unset
+var secretFileErrors []error
+
+// secretFileSource reads a flag value from the file set by another flag, like a mounted secret
+type secretFileSource struct {
+	path *string
+}
+
+func newSecretFileSource(path *string) cli.ValueSource {
+	return &secretFileSource{path: path}
+}
+
+func (s *secretFileSource) Lookup() (string, bool) {
+	if *s.path == "" {
+		return "", false
+	}
+
+	value, err := readSecretFile(*s.path)
+	if err != nil {
+		secretFileErrors = append(secretFileErrors, fmt.Errorf("failed to read secret file %s: %w", *s.path, err))
+		return "", false
+	}
+	return value, true
+}
+
+func (s *secretFileSource) String() string {
+	return fmt.Sprintf("file %q", *s.path)
+}
+
+func (s *secretFileSource) GoString() string {
+	return fmt.Sprintf("&secretFileSource{path:%q}", *s.path)
+}
+
+// readSecretFile returns the content of a secret file without the trailing newline editors and generators add
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// lookupEnvOrFile reads an environment variable, or the file its _FILE variant points to
+func lookupEnvOrFile(name string) (string, bool) {
+	if value, found := os.LookupEnv(name); found {
+		return value, true
+	}
+
+	path, found := os.LookupEnv(name + secretFileSuffix)
+	if !found {
+		return "", false
+	}
+	value, err := readSecretFile(path)
+	if err != nil {
+		secretFileErrors = append(secretFileErrors, fmt.Errorf("failed to read secret file of %s: %w", name+secretFileSuffix, err))
+		return "", false
+	}
+	return value, true
+}